@@ -178,9 +178,9 @@ func (arb *Arbiter) Update(contacts []Contact, numContacts int,
 	arb.handler 	= handler;
 	arb.swappedColl = (a.collision_type != handler.a);	
 	
-	arb.e = a.e * b.e;
-	arb.u = a.u * b.u;
-	arb.surface_vr = a.surface_v.Sub(b.surface_v)
+	arb.e = a.elasticity() * b.elasticity();
+	arb.u = a.friction() * b.friction();
+	arb.surface_vr = b.surfaceV().Sub(a.surfaceV())
 	
 	// For collisions between two similar primitive types, the order could have been swapped.
 	arb.private_a = a; arb.private_b = b;
@@ -218,14 +218,8 @@ func (arb *Arbiter) PreStep(dt_inv Float) {
 
 
 func (arb *Arbiter) ApplyCachedImpulse() { 
-	shapea := arb.private_a
-	shapeb := arb.private_b
-		
-	arb.u 		= shapea.u * shapeb.u
-	arb.surface_vr 	= shapeb.surface_v.Sub(shapea.surface_v)
-
-	a 	       := shapea.Body
-	b 	       := shapeb.Body
+	a := arb.private_a.Body
+	b := arb.private_b.Body
 	
 	for  i:=0 ; i<arb.numContacts ; i++ {
 		con := &arb.contacts[i]
@@ -291,10 +285,20 @@ func (arb *Arbiter) GetShapes() ( a *Shape, b *Shape) {
 }
 
 
+// GetBodies returns the bodies of the shapes, in the order of GetShapes.
+func (arb *Arbiter) GetBodies() (a *Body, b *Body) {
+  shapeA, shapeB := arb.GetShapes()
+  return shapeA.Body, shapeB.Body
+}
+
 func (arb *Arbiter) IsFirstContact() (bool) {
 	return arb.state == ArbiterStateFirstColl
 }
 
+func (arb * Arbiter) NumContacts() (int) {
+  return arb.numContacts
+}
+
 func (arb * Arbiter) GetNormal(i int) (Vect) {
   n := arb.contacts[i].N;
   if arb.swappedColl { 
@@ -307,3 +311,85 @@ func (arb * Arbiter) GetPoint(i int) (Vect) {
   p := arb.contacts[i].P;
   return p
 }
+
+func (arb * Arbiter) GetDepth(i int) (Float) {
+  return arb.contacts[i].Dist
+}
+
+// A contact point of an arbiter, as GetPoint, GetNormal and GetDepth
+// return it. The distance is negative when the shapes overlap.
+type ContactPoint struct {
+  Point, Normal Vect
+  Dist Float
+}
+
+// The contact points of an arbiter, to read and change them at once.
+type ContactPointSet struct {
+  Count int
+  Points [CP_MAX_CONTACTS_PER_ARBITER]ContactPoint
+}
+
+// GetContactPointSet returns the contact points of the arbiter.
+func (arb * Arbiter) GetContactPointSet() (ContactPointSet) {
+  set := ContactPointSet{Count: arb.numContacts}
+  for i:=0; i < arb.numContacts; i++ {
+    set.Points[i] = ContactPoint{arb.GetPoint(i), arb.GetNormal(i),
+      arb.contacts[i].Dist}
+  }
+  return set
+}
+
+// SetContactPointSet changes the contact points of the arbiter, from a
+// preSolve callback. The number of points can't change.
+func (arb * Arbiter) SetContactPointSet(set *ContactPointSet) {
+  Assert(set.Count == arb.numContacts,
+    "The number of contact points cannot be changed.")
+  for i:=0; i < set.Count; i++ {
+    con     := &arb.contacts[i]
+    point   := set.Points[i]
+    con.P    = point.Point
+    con.N    = point.Normal
+    if arb.swappedColl { con.N = con.N.Neg() }
+    con.Dist = point.Dist
+  }
+}
+
+// Elasticity returns the elasticity of the collision, which is the product
+// of the elasticities of the shapes unless a preSolve callback changed it.
+func (arb * Arbiter) Elasticity() (Float) {
+  return arb.e
+}
+
+// SetElasticity overrides the elasticity of the collision, from a
+// preSolve callback.
+func (arb * Arbiter) SetElasticity(e Float) {
+  arb.e = e
+}
+
+// Friction returns the friction of the collision, which is the product of
+// the frictions of the shapes unless a preSolve callback changed it.
+func (arb * Arbiter) Friction() (Float) {
+  return arb.u
+}
+
+// SetFriction overrides the friction of the collision, from a preSolve
+// callback.
+func (arb * Arbiter) SetFriction(u Float) {
+  arb.u = u
+}
+
+// SurfaceVelocity returns the surface velocity of shape B relative to
+// shape A, as GetShapes orders them, so the surface velocity of B minus
+// the one of A. Friction drives the shapes to this relative velocity.
+func (arb * Arbiter) SurfaceVelocity() (Vect) {
+  if arb.swappedColl { return arb.surface_vr.Neg() }
+  return arb.surface_vr
+}
+
+// SetSurfaceVelocity overrides the relative surface velocity of the
+// collision from a preSolve callback, like for a conveyor belt. vr is the
+// one of B relative to A, like SurfaceVelocity returns.
+func (arb * Arbiter) SetSurfaceVelocity(vr Vect) {
+  if arb.swappedColl { vr = vr.Neg() }
+  arb.surface_vr = vr
+}
@@ -57,6 +57,15 @@ type Body struct  {
 	v_bias Vect;
 	w_bias Float;
 	
+	// Space that the body or its shapes were added to.
+	space *Space
+	// Shapes on the body and constraints on it that are in the space.
+	// Kept by the Add and Remove functions of the space.
+	shapes []*Shape
+	constraints []Constraint
+	// Arbiters of the shapes of the body during the last step.
+	arbiters []*Arbiter
+	
 	//	int active;
 }	
 
@@ -263,6 +272,72 @@ func ApplyDampedSpring(a, b *Body, anchr1, anchr2 Vect, rlen, k, dmp, dt Float)
 }
 
 
+// Removes shape from the shapes of the body.
+func (body * Body) removeShape(shape * Shape) {
+  for i, other := range body.shapes {
+    if other == shape {
+      body.shapes = append(body.shapes[0:i], body.shapes[i + 1:]...)
+      return
+    }
+  }
+}
+
+// Removes constraint from the constraints of the body.
+func (body * Body) removeConstraint(constraint Constraint) {
+  for i, other := range body.constraints {
+    if other == constraint {
+      body.constraints = append(body.constraints[0:i],
+        body.constraints[i + 1:]...)
+      return
+    }
+  }
+}
+
+// EachShape calls iter for every shape on the body that is in a space.
+// iter may remove the shape.
+func (body * Body) EachShape(iter func(shape * Shape)) {
+  shapes := make([]*Shape, len(body.shapes))
+  copy(shapes, body.shapes)
+  for _, shape := range shapes {
+    iter(shape)
+  }
+}
+
+// EachConstraint calls iter for every constraint on the body that is in a
+// space. iter may remove the constraint.
+func (body * Body) EachConstraint(iter func(constraint Constraint)) {
+  constraints := make([]Constraint, len(body.constraints))
+  copy(constraints, body.constraints)
+  for _, constraint := range constraints {
+    iter(constraint)
+  }
+}
+
+// Removes arb from the arbiters of the body.
+func (body * Body) removeArbiter(arb * Arbiter) {
+  for i, other := range body.arbiters {
+    if other == arb {
+      body.arbiters = append(body.arbiters[0:i], body.arbiters[i + 1:]...)
+      return
+    }
+  }
+}
+
+// EachArbiter calls iter for every arbiter of a shape on the body that was
+// active during the last step of its space. While iter runs, the arbiter
+// is turned so that the body is the one of shape A, like
+// cpBodyEachArbiter does.
+func (body * Body) EachArbiter(iter func(arb * Arbiter)) {
+  arbiters := make([]*Arbiter, len(body.arbiters))
+  copy(arbiters, body.arbiters)
+  for _, arb := range arbiters {
+    swapped        := arb.swappedColl
+    arb.swappedColl = body == arb.private_b.Body
+    iter(arb)
+    arb.swappedColl = swapped
+  }
+}
+
 //int
 //cpBodyMarkLowEnergy(cpBody *body, cpFloat dvsq, int max)
 //{
@@ -0,0 +1,49 @@
+package tamias
+
+// Chains of segments, for floors and walls that shapes slide along.
+//
+// A segment of a chain knows the vertexes before and after it. Where it
+// joins its neighbours, it doesn't collide in the directions that they
+// hide, so shapes don't catch on the vertexes inside the chain. Segments
+// may also be one-sided, to only collide with shapes in front of them.
+
+// SetNeighbors sets the vertexes before the end a and after the end b of
+// the segment, in body space. A neighbour that is the end itself means
+// the chain stops there.
+func (seg *SegmentShape) SetNeighbors(prev, next Vect) {
+  seg.aTangent = prev.Sub(seg.a)
+  seg.bTangent = next.Sub(seg.b)
+  if seg.Body != nil {
+    seg.taTangent = seg.aTangent.Rotate(seg.Body.rot)
+    seg.tbTangent = seg.bTangent.Rotate(seg.Body.rot)
+  }
+}
+
+// Neighbors returns the vertexes before and after the segment.
+func (seg *SegmentShape) Neighbors() (prev, next Vect) {
+  return seg.a.Add(seg.aTangent), seg.b.Add(seg.bTangent)
+}
+
+// SetOneSided makes the segment collide only with shapes on the side that
+// its normal points to. That is the left side going from a to b with the Y
+// axis pointing up, so the outside of a clockwise loop.
+func (seg *SegmentShape) SetOneSided(oneSided bool) {
+  seg.oneSided = oneSided
+}
+
+func (seg *SegmentShape) OneSided() (bool) {
+  return seg.oneSided
+}
+
+// AddStaticChain makes a chain of segments on the static body along the
+// points, and adds them to the space as static shapes. The chain is
+// closed if the last point is the first one again.
+func (space *Space) AddStaticChain(body *Body, points []Vect, radius Float,
+  oneSided bool) ([]*SegmentShape) {
+  shapes := SegmentShapesNew(body, points, radius)
+  for _, seg := range shapes {
+    seg.SetOneSided(oneSided)
+    space.AddStaticShape(seg.Shape)
+  }
+  return shapes
+}
@@ -1,102 +1,241 @@
 package tamias
 
+// The narrowphase: contact points for pairs of shapes that touch.
+// Normals point from the first shape to the second, and distances are
+// negative when the shapes overlap.
+//
+// Polygons may have a radius, which rounds them. Where the cores of two
+// shapes are apart, they touch at the closest points of their cores, like
+// circles. Where the cores overlap, the separating axes decide.
 
-type CollisionFunc func (a *Shape, b * Shape, * Contact) (bool);
-
+// Returns the next contact of the buffer, and counts it. When the buffer
+// is full, the last contact is used again.
+func nextContactPoint(arr []Contact, num *int) (*Contact) {
+  index := *num
+  if index < CP_MAX_CONTACTS_PER_ARBITER {
+    *num = index + 1
+  } else {
+    index = CP_MAX_CONTACTS_PER_ARBITER - 1
+  }
+  return &arr[index]
+}
 
 // Add contact points for circle to circle collisions.
 // Used by several collision tests.
-func circle2circleQuery(p1, p2 Vect, r1, r2 Float, con * Contact) (int) {
-  mindist := r1 + r2;
+func circle2circleQuery(p1, p2 Vect, r1, r2 Float, con *Contact) (int) {
+  mindist := r1 + r2
   delta   := p2.Sub(p1)
   distsq  := delta.Lengthsq()
-  if distsq >= mindist*mindist { 
+  if distsq >= mindist * mindist {
     return 0
-  }  
-  
-  dist    := distsq.Sqrt()
-  // To avoid singularities, do nothing in the case of dist = 0.
-  nz_dist := dist
-  if dist == 0.0 { 
-   nz_dist := INFINITY
   }
-  
-  c1 := p1.Add(delta.Mult(Float(0.5) + (r1 - Float(0.5)*mindist) / nz_dist))
-  c2 := delta.Mult(Float(1.0) / nz_dist)
-  con.Init(c1, c2, dist - mindist, 0) 
+
+  dist := distsq.Sqrt()
+  // To avoid singularities, pick a normal in the case of dist = 0.
+  n := V(1.0, 0.0)
+  if dist != 0.0 {
+    n = delta.Mult(Float(1.0) / dist)
+  }
+  con.Init(p1.Add(n.Mult(r1 + (dist - mindist) * Float(0.5))), n,
+    dist - mindist, 0)
   return 1
 }
 
 // Collide circle shapes.
-func circle2circle(circ1, circ2 *CircleShape, arr * Contact) (int)
-{
-  return circle2circleQuery(circ1.tc, circ2.tc, circ1.r, circ2.r, arr);
+func circle2circle(circ1, circ2 *CircleShape, con *Contact) (int) {
+  return circle2circleQuery(circ1.tc, circ2.tc, circ1.r, circ2.r, con)
 }
 
 // Collide circles to segment shapes.
 func circle2segment(circ *CircleShape, seg *SegmentShape, con *Contact) (int) {
   // Radius sum
   rsum  := circ.r + seg.r
-  
+
   // Calculate normal distance from segment.
   dn    := seg.tn.Dot(circ.tc) - seg.ta.Dot(seg.tn)
-  dist  := dn.Abs() - rsum;
-  if dist > Float(0.0) return 0;
-  
+  dist  := dn.Abs() - rsum
+  if dist > Float(0.0) { return 0 }
+
   // Calculate tangential distance along segment.
   dt    := - seg.tn.Cross(circ.tc)
   dtMin := - seg.tn.Cross(seg.ta)
   dtMax := - seg.tn.Cross(seg.tb)
-  
+
   // Decision tree to decide which feature of the segment to collide with.
+  // The normals point to the segment, so they are turned around to ask
+  // whether it hides the feature.
   if dt < dtMin {
-    if dt < (dtMin - rsum) {
+    if dt < (dtMin - rsum) { return 0 }
+    if circle2circleQuery(circ.tc, seg.ta, circ.r, seg.r, con) == 0 ||
+       seg.hides(segEndA, con.N.Neg()) {
       return 0
-    } else {
-      return circle2circleQuery(circ.tc, seg.ta, circ.r, seg.r, con)
     }
-  } else {
-    if dt < dtMax  {
-      n := seg.tn.Neg();
-      if dn < 0.0 { 
-        n = seg.tn
-      }    
-      c1 := n.Mult(circ.r + dist * Float(0.5)) 
-      con.Init(circ.tc, c1, n, dist, 0)
-      return 1
-    } else {
-      if dt < (dtMax + rsum) {
-        return circle2circleQuery(circ.tc, seg.tb, circ.r, seg.r, con)
-      } else {
-        return 0
-      }
+    return 1
+  } else if dt < dtMax {
+    n := seg.tn.Neg()
+    if dn < 0.0 { n = seg.tn }
+    if seg.hides(segFace, n.Neg()) { return 0 }
+    con.Init(circ.tc.Add(n.Mult(circ.r + dist * Float(0.5))), n, dist, 0)
+    return 1
+  } else if dt < (dtMax + rsum) {
+    if circle2circleQuery(circ.tc, seg.tb, circ.r, seg.r, con) == 0 ||
+       seg.hides(segEndB, con.N.Neg()) {
+      return 0
     }
-  }  
-  return 1
+    return 1
+  }
+  return 0
 }
 
-// Helper function for working with contact buffers
-// This used to malloc/realloc memory on the fly but was repurposed.
-func nextContactPoint(arr []Contact, num int) (contact * cpContact, num int) 
-{
-  if (num <= MAX_CONTACTS_PER_ARBITER) { 
-    num = num + 1
-  }    
-  return arr[num], num;
+// Returns true if v is at most r outside of the faces of the polygon that
+// don't point away from n.
+func (poly *PolyShape) containsVertPartial(v, n Vect, r Float) (bool) {
+  for _, axis := range poly.tAxes {
+    if axis.n.Dot(n) < 0.0 { continue }
+    if axis.n.Dot(v) - axis.d > r { return false }
+  }
+  return true
 }
 
-// Find the minimum separating axis for the given poly and axis list.
-func findMSA(poly *PolyShape, axes []PolyShapeAxis, num int) (result int, 
-  min_out Float) {
-  
-  min_index   := 0
-  min         := poly.ShapeValueOnAxis(axes.n[0], axes.d[0])
-  if min > Float(0.0) { 
-    return -1, Float(-1.0)
+// Returns true if v is at most r outside of every face of the polygon.
+func (poly *PolyShape) containsVertRadius(v Vect, r Float) (bool) {
+  for _, axis := range poly.tAxes {
+    if axis.n.Dot(v) - axis.d > r { return false }
+  }
+  return true
+}
+
+// Returns the closest point to p on the segment ab.
+func closestPointOnSegment(p, a, b Vect) (Vect) {
+  ab := b.Sub(a)
+  t  := Float(0.0)
+  if l := ab.Lengthsq(); l > 0 {
+    t = (p.Sub(a).Dot(ab) / l).Max(0.0).Min(1.0)
+  }
+  return a.Add(ab.Mult(t))
+}
+
+// Returns the number of edges of a polygon, or 1 for a segment of 2
+// vertexes.
+func edgeCount(verts []Vect) (int) {
+  if len(verts) == 2 { return 1 }
+  return len(verts)
+}
+
+// Returns the pairs of an end of one of the segments a0 a1 and b0 b1,
+// which don't cross, and the point of the other segment closest to it,
+// the closest pair first. Apart segments are closest at an end of one of
+// them. ends tells which end each pair has: 0 for a0, 1 for a1, 2 for b0
+// and 3 for b1.
+func edgePairs(a0, a1, b0, b1 Vect) (pairs [4][2]Vect, ends [4]int) {
+  pairs = [4][2]Vect{
+    [2]Vect{a0, closestPointOnSegment(a0, b0, b1)},
+    [2]Vect{a1, closestPointOnSegment(a1, b0, b1)},
+    [2]Vect{closestPointOnSegment(b0, a0, a1), b0},
+    [2]Vect{closestPointOnSegment(b1, a0, a1), b1}}
+  ends  = [4]int{0, 1, 2, 3}
+  for i:=1; i < 4; i++ {
+    for j := i; j > 0 && pairs[j][0].Dist(pairs[j][1]) <
+        pairs[j - 1][0].Dist(pairs[j - 1][1]); j-- {
+      pairs[j], pairs[j - 1] = pairs[j - 1], pairs[j]
+      ends[j], ends[j - 1]   = ends[j - 1], ends[j]
+    }
+  }
+  return pairs, ends
+}
+
+// Returns the index of the closest pair after the first of edgePairs that
+// is away from the first one at both ends, or -1 if there is none.
+func secondPair(pairs [4][2]Vect) (int) {
+  for k:=1; k < 4; k++ {
+    if !pairs[k][0].Near(pairs[0][0], COLLISION_SLOP) &&
+       !pairs[k][1].Near(pairs[0][1], COLLISION_SLOP) {
+      return k
+    }
+  }
+  return -1
+}
+
+// Returns the edges of two polygons that are closest, where a may be a
+// segment of 2 vertexes. Of edges that are about as close, the ones that
+// are most alike in distance at both ends win, so faces that lie on each
+// other beat the corners next to them. Returns false if two edges cross,
+// since then there are no closest edges.
+// Every edge of a is tried against every edge of b, which takes O(n·m)
+// for n and m vertexes. That is cheap for the few vertexes of a polygon
+// shape, and ConcavePolyShapesNew keeps the pieces under a vertex limit.
+// Polygons with many vertexes should be decomposed first.
+func closestEdges(a, b []Vect) (ea, eb int, ok bool) {
+  best, bestNext := INFINITY, INFINITY
+  for i:=0; i < edgeCount(a); i++ {
+    a0, a1 := a[i], a[(i + 1) % len(a)]
+    for j:=0; j < edgeCount(b); j++ {
+      b0, b1 := b[j], b[(j + 1) % len(b)]
+      if segmentsIntersect(a0, a1, b0, b1) { return 0, 0, false }
+      pairs, _ := edgePairs(a0, a1, b0, b1)
+      d     := pairs[0][0].Dist(pairs[0][1])
+      next  := INFINITY
+      if k := secondPair(pairs); k > 0 {
+        next = pairs[k][0].Dist(pairs[k][1])
+      }
+      if d < best - COLLISION_SLOP * 0.1 ||
+         (d < best + COLLISION_SLOP * 0.1 && next < bestNext) {
+        best, bestNext, ea, eb = d.Min(best), next, i, j
+      }
+    }
+  }
+  return ea, eb, true
+}
+
+// Collides a rounded polygon with a segment or polygon of the vertexes a
+// and the radius ra, if their cores are apart. polyA is the polygon of a,
+// or nil for the segment seg, and hash is the hash id of its shape.
+// Returns false if the cores overlap.
+// The closest edges touch like circles at the closest points, and also at
+// the other ends of the edges that are near enough, so faces that lie on
+// each other get two contacts. Contacts are hashed by the vertex at their
+// end, like findVerts does, so they keep their cached impulses while the
+// shapes move.
+func roundedCores(a []Vect, ra Float, polyA, poly *PolyShape,
+  seg *SegmentShape, hash HashValue, arr []Contact) (int, bool) {
+  ea, eb, ok := closestEdges(a, poly.tVerts)
+  if !ok || poly.ContainsVert(a[0]) ||
+     (polyA != nil && polyA.ContainsVert(poly.tVerts[0])) {
+    return 0, false
+  }
+  b           := poly.tVerts
+  pairs, ends := edgePairs(a[ea], a[(ea + 1) % len(a)], b[eb],
+    b[(eb + 1) % len(b)])
+  // The vertexes at the ends of the pairs, as in edgePairs.
+  vertHashes  := [4]HashValue{
+    HASH_PAIR(hash, HashValue(ea)),
+    HASH_PAIR(hash, HashValue((ea + 1) % len(a))),
+    HASH_PAIR(poly.Shape.hashid, HashValue(eb)),
+    HASH_PAIR(poly.Shape.hashid, HashValue((eb + 1) % len(b)))}
+  num         := 0
+  // The closest pair decides, and the second pair may add a contact.
+  for _, k := range []int{0, secondPair(pairs)} {
+    if k < 0 || (k > 0 && num == 0) { continue }
+    con := &arr[num]
+    if circle2circleQuery(pairs[k][0], pairs[k][1], ra, poly.r, con) == 0 ||
+       (seg != nil && seg.hides(seg.featureAt(pairs[k][0]), con.N)) {
+      continue
+    }
+    con.Hash = vertHashes[ends[k]]
+    num++
   }
+  return num, true
+}
+
+// Find the minimum separating axis for the given poly and axis list, with
+// the radius of both shapes. Returns -1 if the axes separate them.
+func findMSA(poly *PolyShape, axes []PolyShapeAxis, rsum Float) (int, Float) {
+  min_index := 0
+  min       := poly.ValueOnAxis(axes[0].n, axes[0].d) - rsum
+  if min > Float(0.0) { return -1, Float(-1.0) }
 
-  for i:=1; i<num; i++ {
-    dist := poly.ShapeValueOnAxis(axes[i].n, axes[i].d)
+  for i:=1; i < len(axes); i++ {
+    dist := poly.ValueOnAxis(axes[i].n, axes[i].d) - rsum
     if dist > Float(0.0) {
       return -1, Float(-1.0)
     } else if dist > min {
@@ -104,172 +243,159 @@ func findMSA(poly *PolyShape, axes []PolyShapeAxis, num int) (result int,
       min_index = i
     }
   }
-    
-  min_out = min
-  return min_index, min_out
+  return min_index, min
 }
 
 // Add contacts for penetrating vertexes.
-func findVerts(arr []Contact, poly1, poly2 *PolyShape, n Vect, dist Float)
-{
-  num := 0;  
-  for i:=0; i<poly1.numVerts; i++ {
-    v := poly1->tVerts[i]
-    if poly2.ContainsVertsPartial(v, n.Neg()) { 
-       con, num := nextContactPoint(arr, num)
-       con.Init(v, n, dist, HASH_PAIR(poly1.Shape.hashid, i))
-    }   
+func findVerts(arr []Contact, poly1, poly2 *PolyShape, n Vect,
+  dist Float) (int) {
+  num := 0
+  for i, v := range poly1.tVerts {
+    if poly2.containsVertPartial(v, n.Neg(), poly1.r + poly2.r) {
+      nextContactPoint(arr, &num).Init(v.Add(n.Mult(poly1.r)), n, dist,
+        HASH_PAIR(poly1.Shape.hashid, HashValue(i)))
+    }
   }
-  
-  for i:=0; i<poly2.numVerts; i++ {
-    v := poly2->tVerts[i]
-    if poly1.ContainsVertsPartial(v, n.Neg()) { 
-       con, num := nextContactPoint(arr, num)
-       con.Init(v, n, dist, HASH_PAIR(poly2.Shape.hashid, i))
+  for i, v := range poly2.tVerts {
+    if poly1.containsVertPartial(v, n, poly1.r + poly2.r) {
+      nextContactPoint(arr, &num).Init(v.Sub(n.Mult(poly2.r)), n, dist,
+        HASH_PAIR(poly2.Shape.hashid, HashValue(i)))
     }
   }
-  //  if(!num)
-  //    addContactPoint(arr, &size, &num, cpContactNew(shape1->body->p, n, dist, 0));
-  return num;
+  return num
 }
 
 // Collide poly shapes together.
-func poly2poly(poly1, poly2 *PolyShape, arr []cpContact) (int)
-{  
-  mini1, min1 := findMSA(poly2, poly1.tAxes, poly1.numVerts)
-  if mini1 == -1 { 
-    return 0
-  }  
-  
-  mini2, min2 := findMSA(poly2, poly1.tAxes, poly1.numVerts)
-  if mini2 == -1 { 
-    return 0
-  }   
+func poly2poly(poly1, poly2 *PolyShape, arr []Contact) (int) {
+  if poly1.r > 0 || poly2.r > 0 {
+    num, apart := roundedCores(poly1.tVerts, poly1.r, poly1, poly2, nil,
+      poly1.Shape.hashid, arr)
+    if apart { return num }
+  }
+  rsum := poly1.r + poly2.r
+  mini1, min1 := findMSA(poly2, poly1.tAxes, rsum)
+  if mini1 == -1 { return 0 }
+
+  mini2, min2 := findMSA(poly1, poly2.tAxes, rsum)
+  if mini2 == -1 { return 0 }
+
   // There is overlap, find the penetrating verts
-  if(min1 > min2) { 
+  if min1 > min2 {
     return findVerts(arr, poly1, poly2, poly1.tAxes[mini1].n, min1)
-  } else {
-    return findVerts(arr, poly1, poly2, poly2.tAxes[mini2].n.Neg(), min2)
-  }  
+  }
+  return findVerts(arr, poly1, poly2, poly2.tAxes[mini2].n.Neg(), min2)
 }
 
-// Like cpPolyValueOnAxis(), but for segments.
-func segValueOnAxis(seg * SegmentShape, n Vect, d Float) (Float)
-{
-  a := n.dot(seg.ta) - seg.r
-  b := n.dot(seg.tb) - seg.r
+// Like PolyShape.ValueOnAxis(), but for segments.
+func segValueOnAxis(seg *SegmentShape, n Vect, d Float) (Float) {
+  a := n.Dot(seg.ta) - seg.r
+  b := n.Dot(seg.tb) - seg.r
   return a.Min(b) - d
 }
 
 // Identify vertexes that have penetrated the segment.
-func findPointsBehindSeg(arr [] Contact, num int, seg * SegmentShape, 
-     poly PolyShape, pDist Float, coef Float) (int)
-{
-  dta := seg.tn.Cross(seg->ta)
-  dtb := seg.tn.Cross(seg->tb)
+func findPointsBehindSeg(arr []Contact, num *int, seg *SegmentShape,
+  poly *PolyShape, pDist Float, coef Float) {
+  dta := seg.tn.Cross(seg.ta)
+  dtb := seg.tn.Cross(seg.tb)
   n   := seg.tn.Mult(coef)
-  for i:=0; i < poly->numVerts ; i++) {
-    v := poly->tVerts[i]
-    if v.Dot(n) < (seg.tn.Dot(seg.ta)* coef + seg.r) {     
+  if seg.hides(segFace, n) { return }
+  for i, v := range poly.tVerts {
+    if v.Dot(n) < seg.tn.Dot(seg.ta) * coef + seg.r + poly.r {
       dt := seg.tn.Cross(v)
       if dta >= dt && dt >= dtb {
-        con, num := nextContactPoint(arr, num)
-        con.Init(v, n, pDist, HASH_PAIR(poly.Shape.hashid, i))
+        nextContactPoint(arr, num).Init(v.Sub(n.Mult(poly.r)), n, pDist,
+          HASH_PAIR(poly.Shape.hashid, HashValue(i)))
       }
     }
   }
-  return num
 }
 
-// This one is complicated and gross. Just don't go there... (sic)
-// TODO: Comment me!
-func seg2poly(seg SegmentShape, poly *PolyShape, arr []cpContact ) (int)
-{
-  axes    := poly->tAxes
+// Collide segments to poly shapes. The segment is tested against the
+// faces of the polygon, and the polygon against both sides of the
+// segment. The contacts are the ends of the segment inside the polygon,
+// and the vertexes of the polygon behind the segment.
+func seg2poly(seg *SegmentShape, poly *PolyShape, arr []Contact) (int) {
+  if poly.r > 0 {
+    num, apart := roundedCores([]Vect{seg.ta, seg.tb}, seg.r, nil, poly, seg,
+      seg.Shape.hashid, arr)
+    if apart { return num }
+  }
+  axes    := poly.tAxes
+  rsum    := seg.r + poly.r
   segD    := seg.tn.Dot(seg.ta)
-  minNorm := poly.ShapeValueOnAxis(seg.tn, segD) - seg.r
-  minNeg  := poly.ShapeValueOnAxis(seg.tn.Neg(), -segD) - seg.r
-  
-  if minNeg > float(0.0) || minNorm > float(0.0) { 
-    return 0
-  }  
-  
-  // Find mimimum 
-  mini      := 0
-  poly_min  := segValueOnAxis(seg, axes[0].n, axes[0].d)  
-  if poly_min > Float(0.0) { 
-    return 0
-  }  
-  
-  for  i:=0; i < poly->numVerts ; i++ {
-    dist := segValueOnAxis(seg, axes[i].n, axes[i].d);
+  minNorm := poly.ValueOnAxis(seg.tn, segD) - rsum
+  minNeg  := poly.ValueOnAxis(seg.tn.Neg(), -segD) - rsum
+  if minNeg > Float(0.0) || minNorm > Float(0.0) { return 0 }
+
+  // Find mimimum
+  mini     := 0
+  poly_min := segValueOnAxis(seg, axes[0].n, axes[0].d) - poly.r
+  if poly_min > Float(0.0) { return 0 }
+  for i:=0; i < poly.numVerts; i++ {
+    dist := segValueOnAxis(seg, axes[i].n, axes[i].d) - poly.r
     if dist > Float(0.0) {
       return 0
     } else if dist > poly_min {
       poly_min = dist
-      mini = i
+      mini     = i
     }
   }
-  
-  num     := 0
-  poly_n  := axes[mini].n.Neg();
-  va      := seg.ta.Add(poly_n.Mult(seg.r))
-  vb      := seg.tb.Add(poly_n.Mult(seg.r))
-  if poly.ContainsVert(va) { 
-    con, num := nextContactPoint(arr, num)
-    con.Init(va, poly_n, poly_min, HASH_PAIR(seg.Shape.hashid, 0))
+
+  num    := 0
+  poly_n := axes[mini].n.Neg()
+  va     := seg.ta.Add(poly_n.Mult(seg.r))
+  vb     := seg.tb.Add(poly_n.Mult(seg.r))
+  if poly.containsVertRadius(va, poly.r) && !seg.hides(segEndA, poly_n) {
+    nextContactPoint(arr, &num).Init(va, poly_n, poly_min,
+      HASH_PAIR(seg.Shape.hashid, 0))
+  }
+  if poly.containsVertRadius(vb, poly.r) && !seg.hides(segEndB, poly_n) {
+    nextContactPoint(arr, &num).Init(vb, poly_n, poly_min,
+      HASH_PAIR(seg.Shape.hashid, 1))
   }
-  
-  if poly.ContainsVert(vb) { 
-    con, num := nextContactPoint(arr, num)
-    con.Init(va, poly_n, poly_min, HASH_PAIR(seg.Shape.hashid, 1))
-  }   
+
   // Floating point precision problems here.
   // This will have to do for now.
-  poly_min -= CollisionSlop
+  poly_min -= COLLISION_SLOP
   if minNorm >= poly_min || minNeg >= poly_min {
-    if(minNorm > minNeg) {
-      num = findPointsBehindSeg(arr, num, seg, poly, minNorm, Float(1.0));      
+    if minNorm > minNeg {
+      findPointsBehindSeg(arr, &num, seg, poly, minNorm, Float(1.0))
     } else {
-      num = findPointsBehindSeg(arr, num, seg, poly, minNeg, Float(-1.0));
-    }  
+      findPointsBehindSeg(arr, &num, seg, poly, minNeg, Float(-1.0))
+    }
   }
-  
+
   // If no other collision points are found, try colliding endpoints.
-  if(num == 0) {
-    poly_a := poly.tVerts[mini];
-    poly_b := poly.tVerts[(mini + 1)%poly.numVerts];
-    
-    if circle2circleQuery(seg.ta, poly_a, seg.r, 0.0f, arr[0]) > 0 {
-      return 1;
-    }  
-      
-    if circle2circleQuery(seg.tb, poly_a, seg.r, 0.0f, arr[0]) > 0 {
-      return 1;
-    } 
-      
-    if circle2circleQuery(seg.ta, poly_b, seg.r, 0.0f, arr[0]) > 0 {
-      return 1;
-    }  
-      
-    if circle2circleQuery(seg.tb, poly_b, seg.r, 0.0f, arr[0]) > 0 {
-      return 1;
-    }  
+  if num == 0 {
+    poly_a := poly.tVerts[mini]
+    poly_b := poly.tVerts[(mini + 1) % poly.numVerts]
+    for i, pair := range [][2]Vect{[2]Vect{seg.ta, poly_a},
+      [2]Vect{seg.tb, poly_a}, [2]Vect{seg.ta, poly_b},
+      [2]Vect{seg.tb, poly_b}} {
+      end := segEndA
+      if i % 2 == 1 { end = segEndB }
+      if circle2circleQuery(pair[0], pair[1], seg.r, poly.r, &arr[0]) > 0 &&
+         !seg.hides(end, arr[0].N) {
+        return 1
+      }
+    }
   }
-
-  return num;
+  return num
 }
 
-// This one is less gross, but still gross. (sic)
-// TODO: Comment me!
-func circle2poly(cic *CircleShape, poly *PolyShape, con *Contact) (int) {
-  axes := poly->tAxes;
-  
-  // find minimum  
-  mini := 0;
-  min  := axes[0].n.Dot(circ.tc) - axes[0].d - circ.r
-  for int i=0; i<poly->numVerts; i++ {
-    dist := axes[i].n.Dot(circ.tc) - axes[i].d - circ.r
+// Collide circles to poly shapes. The face of the polygon that the circle
+// is furthest out of decides whether it touches that face or one of its
+// vertexes.
+func circle2poly(circ *CircleShape, poly *PolyShape, con *Contact) (int) {
+  axes := poly.tAxes
+  rsum := circ.r + poly.r
+
+  // find minimum
+  mini := 0
+  min  := axes[0].n.Dot(circ.tc) - axes[0].d - rsum
+  for i:=0; i < poly.numVerts; i++ {
+    dist := axes[i].n.Dot(circ.tc) - axes[i].d - rsum
     if dist > 0.0 {
       return 0
     } else if dist > min {
@@ -277,82 +403,111 @@ func circle2poly(cic *CircleShape, poly *PolyShape, con *Contact) (int) {
       mini  = i
     }
   }
-  
-  n   := axes[mini].n;
-  a   := poly.tVerts[mini];
-  b   := poly.tVerts[(mini + 1)%poly.numVerts];
+
+  n   := axes[mini].n
+  a   := poly.tVerts[mini]
+  b   := poly.tVerts[(mini + 1) % poly.numVerts]
   dta := n.Cross(a)
-  dtb := n.Cross(b) 
-  dt  := n.Cross(n, circ.tc);
+  dtb := n.Cross(b)
+  dt  := n.Cross(circ.tc)
   if dt < dtb {
-    return circle2circleQuery(circ.tc, b, circ.r, Float(0.0), con)
+    return circle2circleQuery(circ.tc, b, circ.r, poly.r, con)
   } else if dt < dta {
-    con.Init(circ.tc.Sub(n.Mult(circ.r + min / Float(2.0))), n.Neg(), min, 0)  
-    return 1;
-  } else {
-    return circle2circleQuery(circ.tc, a, circ.r, Float(0.0), con)
+    con.Init(circ.tc.Sub(n.Mult(circ.r + min / Float(2.0))), n.Neg(), min, 0)
+    return 1
   }
+  return circle2circleQuery(circ.tc, a, circ.r, poly.r, con)
 }
 
-/*
-TODO: this must probably be done differently in Go...
-
-//static const collisionFunc builtinCollisionFuncs[9] = {
-//  circle2circle,
-//  NULL,
-//  NULL,
-//  circle2segment,
-//  NULL,
-//  NULL,
-//  circle2poly,
-//  seg2poly,
-//  poly2poly,
-//};
-//static const collisionFunc *colfuncs = builtinCollisionFuncs;
-
-static collisionFunc *colfuncs = NULL;
-
-static void
-addColFunc(cpShapeType a, cpShapeType b, collisionFunc func)
-{
-  colfuncs[a + b*CP_NUM_SHAPES] = func;
+// The features of a segment that a contact can be on.
+const (
+  segFace = iota
+  segEndA
+  segEndB
+)
+
+// Returns the feature of the segment that p, a point of its core, is on.
+func (seg *SegmentShape) featureAt(p Vect) (int) {
+  if p == seg.ta { return segEndA }
+  if p == seg.tb { return segEndB }
+  return segFace
 }
 
-#ifdef __cplusplus
-extern "C" {
-#endif
-  void cpInitCollisionFuncs(void);
-  
-  // Initializes the array of collision functions.
-  // Called by cpInitChipmunk().
-  void
-  cpInitCollisionFuncs(void)
-  {
-    if(!colfuncs)
-      colfuncs = (collisionFunc *)cpcalloc(CP_NUM_SHAPES*CP_NUM_SHAPES, sizeof(collisionFunc));
-    
-    addColFunc(CP_CIRCLE_SHAPE,  CP_CIRCLE_SHAPE,  circle2circle);
-    addColFunc(CP_CIRCLE_SHAPE,  CP_SEGMENT_SHAPE, circle2segment);
-    addColFunc(CP_SEGMENT_SHAPE, CP_POLY_SHAPE,    seg2poly);
-    addColFunc(CP_CIRCLE_SHAPE,  CP_POLY_SHAPE,    circle2poly);
-    addColFunc(CP_POLY_SHAPE,    CP_POLY_SHAPE,    poly2poly);
-  } 
-#ifdef __cplusplus
+// Returns true if the segment doesn't touch the other shape at feature,
+// where n points from the segment to the other shape. A one-sided segment
+// only touches on the side of its normal. An end that joins the next
+// segment of a chain doesn't touch in directions that the next segment
+// hides, so shapes don't catch on the vertexes inside a chain. Normals of
+// the face are never hidden, also at the ends.
+func (seg *SegmentShape) hides(feature int, n Vect) (bool) {
+  if seg.oneSided && n.Dot(seg.tn) < 0.0 { return true }
+  if feature == segFace || n.Dot(seg.tn).Abs() > 0.999 { return false }
+  if feature == segEndA { return n.Dot(seg.taTangent) > 0.0 }
+  return n.Dot(seg.tbTangent) > 0.0
 }
-#endif
-*/
 
-func CollideShapes(cpShape *a, cpShape *b, cpContact *arr) (int) {
-  // Their shape types must be in order.
-  Assert(a->ShapeClass.Type <= b->ShapeClass->Type, 
-    "Collision shapes passed to cpCollideShapes() are not sorted.");
-  /*
-  collisionFunc cfunc = colfuncs[a->klass->type + b->klass->type*CP_NUM_SHAPES];
-  return (cfunc) ? cfunc(a, b, arr) : 0;
-  */
-  // TODO: make this work
-  return 0  
+// Collides a shape with the columns of the heightfield that its bounding
+// box overlaps, each as a segment with collide, until the buffer is full.
+// The hashes of the contacts get the column, so they stay apart.
+func (field *HeightfieldShape) collideColumns(bb *BB, arr []Contact,
+  collide func(seg *SegmentShape, arr []Contact) (int)) (int) {
+  num := 0
+  first, last := field.columns(*bb)
+  for i := first; i <= last && num < len(arr); i++ {
+    found := collide(field.setColumn(i), arr[num:])
+    for j := num; j < num + found; j++ {
+      arr[j].Hash = HASH_PAIR(arr[j].Hash, HashValue(i))
+    }
+    num += found
+  }
+  return num
 }
 
+// Collide circles to heightfields.
+func circle2heightfield(circ *CircleShape, field *HeightfieldShape,
+  arr []Contact) (int) {
+  return field.collideColumns(circ.BB, arr,
+    func(seg *SegmentShape, arr []Contact) (int) {
+      return circle2segment(circ, seg, &arr[0])
+    })
+}
 
+// Collide poly shapes to heightfields. The normals of seg2poly are turned
+// around, so they point from the polygon to the heightfield.
+func poly2heightfield(poly *PolyShape, field *HeightfieldShape,
+  arr []Contact) (int) {
+  return field.collideColumns(poly.BB, arr,
+    func(seg *SegmentShape, arr []Contact) (int) {
+      num := seg2poly(seg, poly, arr)
+      for i:=0; i < num; i++ { arr[i].N = arr[i].N.Neg() }
+      return num
+    })
+}
 
+// CollideShapes finds the contacts of two shapes, and returns how many
+// there are, at most CP_MAX_CONTACTS_PER_ARBITER. The shape types must be
+// in order. Segments and heightfields don't collide with each other.
+func CollideShapes(a, b *Shape, arr []Contact) (int) {
+  // Their shape types must be in order.
+  Assert(a.ShapeClass.Type <= b.ShapeClass.Type,
+    "Collision shapes passed to CollideShapes() are not sorted.")
+  switch ga := a.geometry.(type) {
+    case *CircleShape:
+      switch gb := b.geometry.(type) {
+        case *CircleShape:  return circle2circle(ga, gb, &arr[0])
+        case *SegmentShape: return circle2segment(ga, gb, &arr[0])
+        case *PolyShape:    return circle2poly(ga, gb, &arr[0])
+        case *HeightfieldShape: return circle2heightfield(ga, gb, arr)
+      }
+    case *SegmentShape:
+      if gb, ok := b.geometry.(*PolyShape); ok {
+        return seg2poly(ga, gb, arr)
+      }
+    case *PolyShape:
+      switch gb := b.geometry.(type) {
+        case *PolyShape:        return poly2poly(ga, gb, arr)
+        case *HeightfieldShape: return poly2heightfield(ga, gb, arr)
+      }
+  }
+  return 0
+}
@@ -11,11 +11,38 @@ type Constraint interface {
   BiasCoef() (Float)
   MaxBias() (Float)
 
-  PreStep(dt, dt_inv int)
+  PreStep(dt, dt_inv Float)
   ApplyImpulse()
   GetImpulse() (Float)
 }
 
+// Implemented by the constraints of the package that have state that 
+// carries over from one step to the next, such as accumulated impulses.
+// Snapshots and StateHash use it, other constraints have no such state.
+type constraintState interface {
+  // Appends the state to state and returns it.
+  saveState(state []Float) ([]Float)
+  // Loads the state that saveState saved, and returns the rest of state.
+  loadState(state []Float) ([]Float)
+}
+
+// Appends the state of the constraint to state, if it has any.
+func saveConstraintState(con Constraint, state []Float) ([]Float) {
+  if stateful, ok := con.(constraintState); ok {
+    return stateful.saveState(state)
+  }
+  return state
+}
+
+// Loads the state of the constraint from state, if it has any, and returns
+// the rest of state.
+func loadConstraintState(con Constraint, state []Float) ([]Float) {
+  if stateful, ok := con.(constraintState); ok {
+    return stateful.loadState(state)
+  }
+  return state
+}
+
 
 
 type constraint struct {
@@ -32,6 +59,23 @@ func (c *  constraint) Bodies() (*Body, *Body) {
   return c.a, c.b
 }
 
+func (c *  constraint) A() (*Body) {
+  return c.a
+}
+
+func (c *  constraint) B() (*Body) {
+  return c.b
+}
+
+func (c *  constraint) Data() (interface{}) {
+  return c.data
+}
+
+// SetData sets the user data of the constraint.
+func (c *  constraint) SetData(data interface{}) {
+  c.data = data
+}
+
 
 func (c *  constraint) MaxForce() (Float) {
   return c.maxForce
@@ -91,7 +135,7 @@ type GrooveJoint struct {
   k1, k2 Vect
   
   jAcc Vect
-  jMaxLen Vect
+  jMaxLen Float
   bias Vect
 }
 
@@ -154,6 +198,82 @@ type SlideJoint struct {
 } 
 
 
+// State of the constraints, used by snapshots.
+
+func (joint * GearJoint) saveState(state []Float) ([]Float) {
+  return append(state, joint.jAcc)
+}
+
+func (joint * GearJoint) loadState(state []Float) ([]Float) {
+  joint.jAcc = state[0]
+  return state[1:]
+}
+
+func (joint * GrooveJoint) saveState(state []Float) ([]Float) {
+  return append(state, joint.jAcc.X, joint.jAcc.Y)
+}
+
+func (joint * GrooveJoint) loadState(state []Float) ([]Float) {
+  joint.jAcc = V(state[0], state[1])
+  return state[2:]
+}
+
+func (joint * PinJoint) saveState(state []Float) ([]Float) {
+  return append(state, joint.jnAcc)
+}
+
+func (joint * PinJoint) loadState(state []Float) ([]Float) {
+  joint.jnAcc = state[0]
+  return state[1:]
+}
+
+func (joint * PivotJoint) saveState(state []Float) ([]Float) {
+  return append(state, joint.jAcc.X, joint.jAcc.Y)
+}
+
+func (joint * PivotJoint) loadState(state []Float) ([]Float) {
+  joint.jAcc = V(state[0], state[1])
+  return state[2:]
+}
+
+// The ratchet angle changes during the simulation, so it is state too.
+func (joint * RatchetJoint) saveState(state []Float) ([]Float) {
+  return append(state, joint.angle, joint.jAcc)
+}
+
+func (joint * RatchetJoint) loadState(state []Float) ([]Float) {
+  joint.angle = state[0]
+  joint.jAcc  = state[1]
+  return state[2:]
+}
+
+func (joint * RotaryLimitJoint) saveState(state []Float) ([]Float) {
+  return append(state, joint.jAcc)
+}
+
+func (joint * RotaryLimitJoint) loadState(state []Float) ([]Float) {
+  joint.jAcc = state[0]
+  return state[1:]
+}
+
+func (joint * SimpleMotor) saveState(state []Float) ([]Float) {
+  return append(state, joint.jAcc)
+}
+
+func (joint * SimpleMotor) loadState(state []Float) ([]Float) {
+  joint.jAcc = state[0]
+  return state[1:]
+}
+
+func (joint * SlideJoint) saveState(state []Float) ([]Float) {
+  return append(state, joint.jnAcc)
+}
+
+func (joint * SlideJoint) loadState(state []Float) ([]Float) {
+  joint.jnAcc = state[0]
+  return state[1:]
+}
+
 func (spring * DampedRotarySpring) SpringTorque(relativeAngle Float) (Float) {
   return (relativeAngle - spring.restAngle)*spring.stiffness;
 }
@@ -215,859 +335,616 @@ func DampedRotarySpringNew(a, b *Body, restAngle, stiffness,
   return DampedRotarySpringAlloc().Init(a, b, restAngle, stiffness, damping)      
 }
 
-/*
-static cpFloat
-defaultSpringForce(cpDampedSpring *spring, cpFloat dist){
-  return (spring->restLength - dist)*spring->stiffness;
+func (spring * DampedSpring) SpringForce(dist Float) (Float) {
+  return (spring.restLength - dist) * spring.stiffness
 }
 
-static void
-preStep(cpDampedSpring *spring, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = spring->constraint.a;
-  cpBody *b = spring->constraint.b;
-  
-  spring->r1 = cpvrotate(spring->anchr1, a->rot);
-  spring->r2 = cpvrotate(spring->anchr2, b->rot);
-  
-  cpVect delta = cpvsub(cpvadd(b->p, spring->r2), cpvadd(a->p, spring->r1));
-  cpFloat dist = cpvlength(delta);
-  spring->n = cpvmult(delta, 1.0f/(dist ? dist : INFINITY));
-  
+func (spring * DampedSpring) PreStep(dt, dt_inv Float) {
+  a, b      := spring.Bodies()
+  spring.r1  = spring.anchr1.Rotate(a.rot)
+  spring.r2  = spring.anchr2.Rotate(b.rot)
+  delta     := b.p.Add(spring.r2).Sub(a.p.Add(spring.r1))
+  dist      := delta.Length()
+  if dist != 0.0 {
+    spring.n = delta.Mult(Float(1.0) / dist)
+  } else {
+    spring.n = VZERO
+  }
   // calculate mass normal
-  spring->nMass = 1.0f/k_scalar(a, b, spring->r1, spring->r2, spring->n);
-
-  spring->dt = dt;
-  spring->target_vrn = 0.0f;
-
+  spring.nMass      = Float(1.0) / KScalar(a, b, spring.r1, spring.r2, spring.n)
+  spring.dt         = dt
+  spring.target_vrn = Float(0.0)
   // apply spring force
-  cpFloat f_spring = spring->springForceFunc((cpConstraint *)spring, dist);
-  apply_impulses(a, b, spring->r1, spring->r2, cpvmult(spring->n, f_spring*dt));
+  f_spring := spring.SpringForce(dist)
+  ApplyImpulses(a, b, spring.r1, spring.r2, spring.n.Mult(f_spring * dt))
 }
 
-static void
-applyImpulse(cpDampedSpring *spring)
-{
-  cpBody *a = spring->constraint.a;
-  cpBody *b = spring->constraint.b;
-  
-  cpVect n = spring->n;
-  cpVect r1 = spring->r1;
-  cpVect r2 = spring->r2;
-
+func (spring * DampedSpring) ApplyImpulse() {
+  a, b := spring.Bodies()
   // compute relative velocity
-  cpFloat vrn = normal_relative_velocity(a, b, r1, r2, n) - spring->target_vrn;
-  
+  vrn := NormalRelativeVelocity(a, b, spring.r1, spring.r2, spring.n) - 
+    spring.target_vrn
   // compute velocity loss from drag
   // not 100% certain this is derived correctly, though it makes sense
-  cpFloat v_damp = -vrn*(1.0f - cpfexp(-spring->damping*spring->dt/spring->nMass));
-  spring->target_vrn = vrn + v_damp;
-  
-  apply_impulses(a, b, spring->r1, spring->r2, cpvmult(spring->n, v_damp*spring->nMass));
+  v_damp := -vrn * (Float(1.0) - 
+    (-spring.damping * spring.dt / spring.nMass).Exp())
+  spring.target_vrn = vrn + v_damp
+  ApplyImpulses(a, b, spring.r1, spring.r2, 
+    spring.n.Mult(v_damp * spring.nMass))
 }
 
-static cpFloat
-getImpulse(cpConstraint *constraint)
-{
-  return 0.0f;
+func (spring * DampedSpring) GetImpulse() (Float) {
+  return Float(0.0)
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpDampedSpring)
-
-cpDampedSpring *
-cpDampedSpringAlloc(void)
-{
-  return (cpDampedSpring *)cpmalloc(sizeof(cpDampedSpring));
+func DampedSpringAlloc() (* DampedSpring) {
+  return &DampedSpring{}
 }
 
-cpDampedSpring *
-cpDampedSpringInit(cpDampedSpring *spring, cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2, cpFloat restLength, cpFloat stiffness, cpFloat damping)
-{
-  cpConstraintInit((cpConstraint *)spring, cpDampedSpringGetClass(), a, b);
-  
-  spring->anchr1 = anchr1;
-  spring->anchr2 = anchr2;
-  
-  spring->restLength = restLength;
-  spring->stiffness = stiffness;
-  spring->damping = damping;
-  spring->springForceFunc = (cpDampedSpringForceFunc)defaultSpringForce;
-  
-  return spring;
+func (spring * DampedSpring) Init(a, b *Body, anchr1, anchr2 Vect,
+      restLength, stiffness, damping Float) (* DampedSpring) {
+  spring.constraint.Init(a, b)
+  spring.anchr1     = anchr1
+  spring.anchr2     = anchr2
+  spring.restLength = restLength
+  spring.stiffness  = stiffness
+  spring.damping    = damping
+  return spring
 }
 
-cpConstraint *
-cpDampedSpringNew(cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2, cpFloat restLength, cpFloat stiffness, cpFloat damping)
-{
-  return (cpConstraint *)cpDampedSpringInit(cpDampedSpringAlloc(), a, b, anchr1, anchr2, restLength, stiffness, damping);
+func DampedSpringNew(a, b *Body, anchr1, anchr2 Vect,
+      restLength, stiffness, damping Float) (* DampedSpring) {
+  return DampedSpringAlloc().Init(a, b, anchr1, anchr2, restLength, 
+    stiffness, damping)
 }
 
-static void
-preStep(cpGearJoint *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
+func (joint * GearJoint) PreStep(dt, dt_inv Float) {
+  a, b := joint.Bodies()
   // calculate moment of inertia coefficient.
-  joint->iSum = 1.0f/(a->i_inv*joint->ratio_inv + joint->ratio*b->i_inv);
-  
+  joint.iSum = Float(1.0) / (a.i_inv * joint.ratio_inv + joint.ratio * b.i_inv)
   // calculate bias velocity
-  cpFloat maxBias = joint->constraint.maxBias;
-  joint->bias = cpfclamp(-joint->constraint.biasCoef*dt_inv*(b->a*joint->ratio - a->a - joint->phase), -maxBias, maxBias);
-  
+  maxBias   := joint.maxBias
+  joint.bias = (-joint.biasCoef * dt_inv * 
+    (b.a * joint.ratio - a.a - joint.phase)).Clamp(-maxBias, maxBias)
   // compute max impulse
-  joint->jMax = J_MAX(joint, dt);
-
+  joint.jMax = joint.maxForce * dt
   // apply joint torque
-  cpFloat j = joint->jAcc;
-  a->w -= j*a->i_inv*joint->ratio_inv;
-  b->w += j*b->i_inv;
+  j   := joint.jAcc
+  a.w -= j * a.i_inv * joint.ratio_inv
+  b.w += j * b.i_inv
 }
 
-static void
-applyImpulse(cpGearJoint *joint)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
+func (joint * GearJoint) ApplyImpulse() {
+  a, b := joint.Bodies()
   // compute relative rotational velocity
-  cpFloat wr = b->w*joint->ratio - a->w;
-  
-  // compute normal impulse 
-  cpFloat j = (joint->bias - wr)*joint->iSum;
-  cpFloat jOld = joint->jAcc;
-  joint->jAcc = cpfclamp(jOld + j, -joint->jMax, joint->jMax);
-  j = joint->jAcc - jOld;
-  
+  wr := b.w * joint.ratio - a.w
+  // compute normal impulse
+  j         := (joint.bias - wr) * joint.iSum
+  jOld      := joint.jAcc
+  joint.jAcc = (jOld + j).Clamp(-joint.jMax, joint.jMax)
+  j          = joint.jAcc - jOld
   // apply impulse
-  a->w -= j*a->i_inv*joint->ratio_inv;
-  b->w += j*b->i_inv;
+  a.w -= j * a.i_inv * joint.ratio_inv
+  b.w += j * b.i_inv
 }
 
-static cpFloat
-getImpulse(cpGearJoint *joint)
-{
-  return cpfabs(joint->jAcc);
+func (joint * GearJoint) GetImpulse() (Float) {
+  return joint.jAcc.Abs()
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpGearJoint)
-
-cpGearJoint *
-cpGearJointAlloc(void)
-{
-  return (cpGearJoint *)cpmalloc(sizeof(cpGearJoint));
+func GearJointAlloc() (* GearJoint) {
+  return &GearJoint{}
 }
 
-cpGearJoint *
-cpGearJointInit(cpGearJoint *joint, cpBody *a, cpBody *b, cpFloat phase, cpFloat ratio)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->phase = phase;
-  joint->ratio = ratio;
-  joint->ratio_inv = 1.0f/ratio;
-  
-  joint->jAcc = 0.0f;
-  
-  return joint;
+func (joint * GearJoint) Init(a, b *Body, phase, ratio Float) (* GearJoint) {
+  joint.constraint.Init(a, b)
+  joint.phase     = phase
+  joint.ratio     = ratio
+  joint.ratio_inv = Float(1.0) / ratio
+  joint.jAcc      = Float(0.0)
+  return joint
 }
 
-cpConstraint *
-cpGearJointNew(cpBody *a, cpBody *b, cpFloat phase, cpFloat ratio)
-{
-  return (cpConstraint *)cpGearJointInit(cpGearJointAlloc(), a, b, phase, ratio);
+func GearJointNew(a, b *Body, phase, ratio Float) (* GearJoint) {
+  return GearJointAlloc().Init(a, b, phase, ratio)
 }
 
-void
-cpGearJointSetRatio(cpConstraint *constraint, cpFloat value)
-{
-  cpConstraintCheckCast(constraint, cpGearJoint);
-  ((cpGearJoint *)constraint)->ratio = value;
-  ((cpGearJoint *)constraint)->ratio_inv = 1.0f/value;
+func (joint * GearJoint) SetRatio(ratio Float) {
+  joint.ratio     = ratio
+  joint.ratio_inv = Float(1.0) / ratio
 }
 
-
-static void
-preStep(cpGrooveJoint *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
+func (joint * GrooveJoint) PreStep(dt, dt_inv Float) {
+  a, b := joint.Bodies()
   // calculate endpoints in worldspace
-  cpVect ta = cpBodyLocal2World(a, joint->grv_a);
-  cpVect tb = cpBodyLocal2World(a, joint->grv_b);
-
+  ta := a.Local2World(joint.grv_a)
+  tb := a.Local2World(joint.grv_b)
   // calculate axis
-  cpVect n = cpvrotate(joint->grv_n, a->rot);
-  cpFloat d = cpvdot(ta, n);
-  
-  joint->grv_tn = n;
-  joint->r2 = cpvrotate(joint->anchr2, b->rot);
-  
+  n := joint.grv_n.Rotate(a.rot)
+  d := ta.Dot(n)
+  joint.grv_tn = n
+  joint.r2     = joint.anchr2.Rotate(b.rot)
   // calculate tangential distance along the axis of r2
-  cpFloat td = cpvcross(cpvadd(b->p, joint->r2), n);
+  td := b.p.Add(joint.r2).Cross(n)
   // calculate clamping factor and r2
-  if(td <= cpvcross(ta, n)){
-    joint->clamp = 1.0f;
-    joint->r1 = cpvsub(ta, a->p);
-  } else if(td >= cpvcross(tb, n)){
-    joint->clamp = -1.0f;
-    joint->r1 = cpvsub(tb, a->p);
+  if td <= ta.Cross(n) {
+    joint.clamp = Float(1.0)
+    joint.r1    = ta.Sub(a.p)
+  } else if td >= tb.Cross(n) {
+    joint.clamp = Float(-1.0)
+    joint.r1    = tb.Sub(a.p)
   } else {
-    joint->clamp = 0.0f;
-    joint->r1 = cpvsub(cpvadd(cpvmult(cpvperp(n), -td), cpvmult(n, d)), a->p);
+    joint.clamp = Float(0.0)
+    joint.r1    = n.Perp().Mult(-td).Add(n.Mult(d)).Sub(a.p)
   }
-  
   // Calculate mass tensor
-  k_tensor(a, b, joint->r1, joint->r2, &joint->k1, &joint->k2); 
-  
+  joint.k1, joint.k2 = KTensor(a, b, joint.r1, joint.r2)
   // compute max impulse
-  joint->jMaxLen = J_MAX(joint, dt);
-  
+  joint.jMaxLen = joint.maxForce * dt
   // calculate bias velocity
-  cpVect delta = cpvsub(cpvadd(b->p, joint->r2), cpvadd(a->p, joint->r1));
-  joint->bias = cpvclamp(cpvmult(delta, -joint->constraint.biasCoef*dt_inv), joint->constraint.maxBias);
-  
+  delta     := b.p.Add(joint.r2).Sub(a.p.Add(joint.r1))
+  joint.bias = delta.Mult(-joint.biasCoef * dt_inv).Clamp(joint.maxBias)
   // apply accumulated impulse
-  apply_impulses(a, b, joint->r1, joint->r2, joint->jAcc);
+  ApplyImpulses(a, b, joint.r1, joint.r2, joint.jAcc)
 }
 
-static inline cpVect
-grooveConstrain(cpGrooveJoint *joint, cpVect j){
-  cpVect n = joint->grv_tn;
-  cpVect jClamp = (joint->clamp*cpvcross(j, n) > 0.0f) ? j : cpvproject(j, n);
-  return cpvclamp(jClamp, joint->jMaxLen);
+// Clamps the impulse to the groove.
+func (joint * GrooveJoint) constrain(j Vect) (Vect) {
+  n      := joint.grv_tn
+  jClamp := j
+  if joint.clamp * j.Cross(n) <= 0.0 {
+    jClamp = j.Project(n)
+  }
+  return jClamp.Clamp(joint.jMaxLen)
 }
 
-static void
-applyImpulse(cpGrooveJoint *joint)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  cpVect r1 = joint->r1;
-  cpVect r2 = joint->r2;
-  
+func (joint * GrooveJoint) ApplyImpulse() {
+  a, b := joint.Bodies()
   // compute impulse
-  cpVect vr = relative_velocity(a, b, r1, r2);
-
-  cpVect j = mult_k(cpvsub(joint->bias, vr), joint->k1, joint->k2);
-  cpVect jOld = joint->jAcc;
-  joint->jAcc = grooveConstrain(joint, cpvadd(jOld, j));
-  j = cpvsub(joint->jAcc, jOld);
-  
+  vr        := RelativeVelocity(a, b, joint.r1, joint.r2)
+  j         := joint.bias.Sub(vr).MultK(joint.k1, joint.k2)
+  jOld      := joint.jAcc
+  joint.jAcc = joint.constrain(jOld.Add(j))
+  j          = joint.jAcc.Sub(jOld)
   // apply impulse
-  apply_impulses(a, b, joint->r1, joint->r2, j);
+  ApplyImpulses(a, b, joint.r1, joint.r2, j)
 }
 
-static cpFloat
-getImpulse(cpGrooveJoint *joint)
-{
-  return cpvlength(joint->jAcc);
+func (joint * GrooveJoint) GetImpulse() (Float) {
+  return joint.jAcc.Length()
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpGrooveJoint)
-
-cpGrooveJoint *
-cpGrooveJointAlloc(void)
-{
-  return (cpGrooveJoint *)cpmalloc(sizeof(cpGrooveJoint));
+func GrooveJointAlloc() (* GrooveJoint) {
+  return &GrooveJoint{}
 }
 
-cpGrooveJoint *
-cpGrooveJointInit(cpGrooveJoint *joint, cpBody *a, cpBody *b, cpVect groove_a, cpVect groove_b, cpVect anchr2)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->grv_a = groove_a;
-  joint->grv_b = groove_b;
-  joint->grv_n = cpvperp(cpvnormalize(cpvsub(groove_b, groove_a)));
-  joint->anchr2 = anchr2;
-  
-  joint->jAcc = cpvzero;
-  
-  return joint;
+func (joint * GrooveJoint) Init(a, b *Body, groove_a, groove_b, 
+      anchr2 Vect) (* GrooveJoint) {
+  joint.constraint.Init(a, b)
+  joint.grv_a  = groove_a
+  joint.grv_b  = groove_b
+  joint.grv_n  = groove_b.Sub(groove_a).Normalize().Perp()
+  joint.anchr2 = anchr2
+  joint.jAcc   = VZERO
+  return joint
 }
 
-cpConstraint *
-cpGrooveJointNew(cpBody *a, cpBody *b, cpVect groove_a, cpVect groove_b, cpVect anchr2)
-{
-  return (cpConstraint *)cpGrooveJointInit(cpGrooveJointAlloc(), a, b, groove_a, groove_b, anchr2);
+func GrooveJointNew(a, b *Body, groove_a, groove_b, 
+      anchr2 Vect) (* GrooveJoint) {
+  return GrooveJointAlloc().Init(a, b, groove_a, groove_b, anchr2)
 }
 
-static void
-preStep(cpPinJoint *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  joint->r1 = cpvrotate(joint->anchr1, a->rot);
-  joint->r2 = cpvrotate(joint->anchr2, b->rot);
-  
-  cpVect delta = cpvsub(cpvadd(b->p, joint->r2), cpvadd(a->p, joint->r1));
-  cpFloat dist = cpvlength(delta);
-  joint->n = cpvmult(delta, 1.0f/(dist ? dist : (cpFloat)INFINITY));
-  
+func (joint * PinJoint) PreStep(dt, dt_inv Float) {
+  a, b    := joint.Bodies()
+  joint.r1 = joint.anchr1.Rotate(a.rot)
+  joint.r2 = joint.anchr2.Rotate(b.rot)
+  delta   := b.p.Add(joint.r2).Sub(a.p.Add(joint.r1))
+  dist    := delta.Length()
+  if dist != 0.0 {
+    joint.n = delta.Mult(Float(1.0) / dist)
+  } else {
+    joint.n = VZERO
+  }
   // calculate mass normal
-  joint->nMass = 1.0f/k_scalar(a, b, joint->r1, joint->r2, joint->n);
-  
+  joint.nMass = Float(1.0) / KScalar(a, b, joint.r1, joint.r2, joint.n)
   // calculate bias velocity
-  cpFloat maxBias = joint->constraint.maxBias;
-  joint->bias = cpfclamp(-joint->constraint.biasCoef*dt_inv*(dist - joint->dist), -maxBias, maxBias);
-  
+  maxBias   := joint.maxBias
+  joint.bias = (-joint.biasCoef * dt_inv * 
+    (dist - joint.dist)).Clamp(-maxBias, maxBias)
   // compute max impulse
-  joint->jnMax = J_MAX(joint, dt);
-  
+  joint.jnMax = joint.maxForce * dt
   // apply accumulated impulse
-  cpVect j = cpvmult(joint->n, joint->jnAcc);
-  apply_impulses(a, b, joint->r1, joint->r2, j);
+  ApplyImpulses(a, b, joint.r1, joint.r2, joint.n.Mult(joint.jnAcc))
 }
 
-static void
-applyImpulse(cpPinJoint *joint)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  cpVect n = joint->n;
-
+func (joint * PinJoint) ApplyImpulse() {
+  a, b := joint.Bodies()
+  n    := joint.n
   // compute relative velocity
-  cpFloat vrn = normal_relative_velocity(a, b, joint->r1, joint->r2, n);
-  
+  vrn := NormalRelativeVelocity(a, b, joint.r1, joint.r2, n)
   // compute normal impulse
-  cpFloat jn = (joint->bias - vrn)*joint->nMass;
-  cpFloat jnOld = joint->jnAcc;
-  joint->jnAcc = cpfclamp(jnOld + jn, -joint->jnMax, joint->jnMax);
-  jn = joint->jnAcc - jnOld;
-  
+  jn          := (joint.bias - vrn) * joint.nMass
+  jnOld       := joint.jnAcc
+  joint.jnAcc  = (jnOld + jn).Clamp(-joint.jnMax, joint.jnMax)
+  jn           = joint.jnAcc - jnOld
   // apply impulse
-  apply_impulses(a, b, joint->r1, joint->r2, cpvmult(n, jn));
+  ApplyImpulses(a, b, joint.r1, joint.r2, n.Mult(jn))
 }
 
-static cpFloat
-getImpulse(cpPinJoint *joint)
-{
-  return cpfabs(joint->jnAcc);
+func (joint * PinJoint) GetImpulse() (Float) {
+  return joint.jnAcc.Abs()
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpPinJoint);
-
-
-cpPinJoint *
-cpPinJointAlloc(void)
-{
-  return (cpPinJoint *)cpmalloc(sizeof(cpPinJoint));
+func PinJointAlloc() (* PinJoint) {
+  return &PinJoint{}
 }
 
-cpPinJoint *
-cpPinJointInit(cpPinJoint *joint, cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->anchr1 = anchr1;
-  joint->anchr2 = anchr2;
-  
-  cpVect p1 = cpvadd(a->p, cpvrotate(anchr1, a->rot));
-  cpVect p2 = cpvadd(b->p, cpvrotate(anchr2, b->rot));
-  joint->dist = cpvlength(cpvsub(p2, p1));
-
-  joint->jnAcc = 0.0f;
-  
-  return joint;
+// Init keeps the anchors at the distance they have now.
+func (joint * PinJoint) Init(a, b *Body, anchr1, anchr2 Vect) (* PinJoint) {
+  joint.constraint.Init(a, b)
+  joint.anchr1 = anchr1
+  joint.anchr2 = anchr2
+  p1          := a.Local2World(anchr1)
+  p2          := b.Local2World(anchr2)
+  joint.dist   = p2.Sub(p1).Length()
+  joint.jnAcc  = Float(0.0)
+  return joint
 }
 
-cpConstraint *
-cpPinJointNew(cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2)
-{
-  return (cpConstraint *)cpPinJointInit(cpPinJointAlloc(), a, b, anchr1, anchr2);
+func PinJointNew(a, b *Body, anchr1, anchr2 Vect) (* PinJoint) {
+  return PinJointAlloc().Init(a, b, anchr1, anchr2)
 }
 
-static void
-preStep(cpPivotJoint *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  joint->r1 = cpvrotate(joint->anchr1, a->rot);
-  joint->r2 = cpvrotate(joint->anchr2, b->rot);
-  
+func (joint * PivotJoint) PreStep(dt, dt_inv Float) {
+  a, b    := joint.Bodies()
+  joint.r1 = joint.anchr1.Rotate(a.rot)
+  joint.r2 = joint.anchr2.Rotate(b.rot)
   // Calculate mass tensor
-  k_tensor(a, b, joint->r1, joint->r2, &joint->k1, &joint->k2);
-  
+  joint.k1, joint.k2 = KTensor(a, b, joint.r1, joint.r2)
   // compute max impulse
-  joint->jMaxLen = J_MAX(joint, dt);
-  
+  joint.jMaxLen = joint.maxForce * dt
   // calculate bias velocity
-  cpVect delta = cpvsub(cpvadd(b->p, joint->r2), cpvadd(a->p, joint->r1));
-  joint->bias = cpvclamp(cpvmult(delta, -joint->constraint.biasCoef*dt_inv), joint->constraint.maxBias);
-  
+  delta     := b.p.Add(joint.r2).Sub(a.p.Add(joint.r1))
+  joint.bias = delta.Mult(-joint.biasCoef * dt_inv).Clamp(joint.maxBias)
   // apply accumulated impulse
-  apply_impulses(a, b, joint->r1, joint->r2, joint->jAcc);
+  ApplyImpulses(a, b, joint.r1, joint.r2, joint.jAcc)
 }
 
-static void
-applyImpulse(cpPivotJoint *joint)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  cpVect r1 = joint->r1;
-  cpVect r2 = joint->r2;
-    
+func (joint * PivotJoint) ApplyImpulse() {
+  a, b := joint.Bodies()
   // compute relative velocity
-  cpVect vr = relative_velocity(a, b, r1, r2);
-  
+  vr := RelativeVelocity(a, b, joint.r1, joint.r2)
   // compute normal impulse
-  cpVect j = mult_k(cpvsub(joint->bias, vr), joint->k1, joint->k2);
-  cpVect jOld = joint->jAcc;
-  joint->jAcc = cpvclamp(cpvadd(joint->jAcc, j), joint->jMaxLen);
-  j = cpvsub(joint->jAcc, jOld);
-  
+  j         := joint.bias.Sub(vr).MultK(joint.k1, joint.k2)
+  jOld      := joint.jAcc
+  joint.jAcc = joint.jAcc.Add(j).Clamp(joint.jMaxLen)
+  j          = joint.jAcc.Sub(jOld)
   // apply impulse
-  apply_impulses(a, b, joint->r1, joint->r2, j);
+  ApplyImpulses(a, b, joint.r1, joint.r2, j)
 }
 
-static cpFloat
-getImpulse(cpConstraint *joint)
-{
-  return cpvlength(((cpPivotJoint *)joint)->jAcc);
+func (joint * PivotJoint) GetImpulse() (Float) {
+  return joint.jAcc.Length()
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpPivotJoint)
-
-cpPivotJoint *
-cpPivotJointAlloc(void)
-{
-  return (cpPivotJoint *)cpmalloc(sizeof(cpPivotJoint));
+func PivotJointAlloc() (* PivotJoint) {
+  return &PivotJoint{}
 }
 
-cpPivotJoint *
-cpPivotJointInit(cpPivotJoint *joint, cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->anchr1 = anchr1;
-  joint->anchr2 = anchr2;
-  
-  joint->jAcc = cpvzero;
-  
-  return joint;
+func (joint * PivotJoint) Init(a, b *Body, anchr1, anchr2 Vect) (* PivotJoint) {
+  joint.constraint.Init(a, b)
+  joint.anchr1 = anchr1
+  joint.anchr2 = anchr2
+  joint.jAcc   = VZERO
+  return joint
 }
 
-cpConstraint *
-cpPivotJointNew2(cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2)
-{
-  return (cpConstraint *)cpPivotJointInit(cpPivotJointAlloc(), a, b, anchr1, anchr2);
+// PivotJointNew makes a pivot joint around the pivot point in world 
+// coordinates.
+func PivotJointNew(a, b *Body, pivot Vect) (* PivotJoint) {
+  return PivotJointNew2(a, b, a.World2Local(pivot), b.World2Local(pivot))
 }
 
-cpConstraint *
-cpPivotJointNew(cpBody *a, cpBody *b, cpVect pivot)
-{
-  return cpPivotJointNew2(a, b, cpBodyWorld2Local(a, pivot), cpBodyWorld2Local(b, pivot));
+// PivotJointNew2 makes a pivot joint between two anchors in the body 
+// coordinates of each body.
+func PivotJointNew2(a, b *Body, anchr1, anchr2 Vect) (* PivotJoint) {
+  return PivotJointAlloc().Init(a, b, anchr1, anchr2)
 }
 
-static void
-preStep(cpRatchetJoint *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  cpFloat angle = joint->angle;
-  cpFloat phase = joint->phase;
-  cpFloat ratchet = joint->ratchet;
-  
-  cpFloat delta = b->a - a->a;
-  cpFloat diff = angle - delta;
-  cpFloat pdist = 0.0f;
-  
-  if(diff*ratchet > 0.0f){
-    pdist = diff;
+func (joint * RatchetJoint) PreStep(dt, dt_inv Float) {
+  a, b    := joint.Bodies()
+  angle   := joint.angle
+  phase   := joint.phase
+  ratchet := joint.ratchet
+  delta   := b.a - a.a
+  diff    := angle - delta
+  pdist   := Float(0.0)
+  if diff * ratchet > 0.0 {
+    pdist = diff
   } else {
-    joint->angle = cpffloor((delta - phase)/ratchet)*ratchet + phase;
+    joint.angle = ((delta - phase) / ratchet).Floor() * ratchet + phase
   }
-  
   // calculate moment of inertia coefficient.
-  joint->iSum = 1.0f/(a->i_inv + b->i_inv);
-  
+  joint.iSum = Float(1.0) / (a.i_inv + b.i_inv)
   // calculate bias velocity
-  cpFloat maxBias = joint->constraint.maxBias;
-  joint->bias = cpfclamp(-joint->constraint.biasCoef*dt_inv*pdist, -maxBias, maxBias);
-  
+  maxBias   := joint.maxBias
+  joint.bias = (-joint.biasCoef * dt_inv * pdist).Clamp(-maxBias, maxBias)
   // compute max impulse
-  joint->jMax = J_MAX(joint, dt);
-
+  joint.jMax = joint.maxForce * dt
   // If the bias is 0, the joint is not at a limit. Reset the impulse.
-  if(!joint->bias)
-    joint->jAcc = 0.0f;
-
+  if joint.bias == 0.0 {
+    joint.jAcc = Float(0.0)
+  }
   // apply joint torque
-  a->w -= joint->jAcc*a->i_inv;
-  b->w += joint->jAcc*b->i_inv;
+  a.w -= joint.jAcc * a.i_inv
+  b.w += joint.jAcc * b.i_inv
 }
 
-static void
-applyImpulse(cpRatchetJoint *joint)
-{
-  if(!joint->bias) return; // early exit
-
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
+func (joint * RatchetJoint) ApplyImpulse() {
+  if joint.bias == 0.0 { return } // early exit
+  a, b := joint.Bodies()
   // compute relative rotational velocity
-  cpFloat wr = b->w - a->w;
-  cpFloat ratchet = joint->ratchet;
-  
-  // compute normal impulse 
-  cpFloat j = -(joint->bias + wr)*joint->iSum;
-  cpFloat jOld = joint->jAcc;
-  joint->jAcc = cpfclamp((jOld + j)*ratchet, 0.0f, joint->jMax*cpfabs(ratchet))/ratchet;
-  j = joint->jAcc - jOld;
-  
+  wr      := b.w - a.w
+  ratchet := joint.ratchet
+  // compute normal impulse
+  j         := -(joint.bias + wr) * joint.iSum
+  jOld      := joint.jAcc
+  joint.jAcc = ((jOld + j) * ratchet).Clamp(0.0, 
+    joint.jMax * ratchet.Abs()) / ratchet
+  j          = joint.jAcc - jOld
   // apply impulse
-  a->w -= j*a->i_inv;
-  b->w += j*b->i_inv;
+  a.w -= j * a.i_inv
+  b.w += j * b.i_inv
 }
 
-static cpFloat
-getImpulse(cpRatchetJoint *joint)
-{
-  return cpfabs(joint->jAcc);
+func (joint * RatchetJoint) GetImpulse() (Float) {
+  return joint.jAcc.Abs()
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpRatchetJoint)
-
-cpRatchetJoint *
-cpRatchetJointAlloc(void)
-{
-  return (cpRatchetJoint *)cpmalloc(sizeof(cpRatchetJoint));
+func RatchetJointAlloc() (* RatchetJoint) {
+  return &RatchetJoint{}
 }
 
-cpRatchetJoint *
-cpRatchetJointInit(cpRatchetJoint *joint, cpBody *a, cpBody *b, cpFloat phase, cpFloat ratchet)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->angle = 0.0f;
-  joint->phase = phase;
-  joint->ratchet = ratchet;
-  
-  joint->angle = b->a - a->a;
-  
-  return joint;
+func (joint * RatchetJoint) Init(a, b *Body, phase, 
+      ratchet Float) (* RatchetJoint) {
+  joint.constraint.Init(a, b)
+  joint.phase   = phase
+  joint.ratchet = ratchet
+  joint.angle   = b.a - a.a
+  return joint
 }
 
-cpConstraint *
-cpRatchetJointNew(cpBody *a, cpBody *b, cpFloat phase, cpFloat ratchet)
-{
-  return (cpConstraint *)cpRatchetJointInit(cpRatchetJointAlloc(), a, b, phase, ratchet);
+func RatchetJointNew(a, b *Body, phase, ratchet Float) (* RatchetJoint) {
+  return RatchetJointAlloc().Init(a, b, phase, ratchet)
 }
 
-static void
-preStep(cpRotaryLimitJoint *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  cpFloat dist = b->a - a->a;
-  cpFloat pdist = 0.0f;
-  if(dist > joint->max) {
-    pdist = joint->max - dist;
-  } else if(dist < joint->min) {
-    pdist = joint->min - dist;
+func (joint * RotaryLimitJoint) PreStep(dt, dt_inv Float) {
+  a, b  := joint.Bodies()
+  dist  := b.a - a.a
+  pdist := Float(0.0)
+  if dist > joint.max {
+    pdist = joint.max - dist
+  } else if dist < joint.min {
+    pdist = joint.min - dist
   }
-  
   // calculate moment of inertia coefficient.
-  joint->iSum = 1.0f/(a->i_inv + b->i_inv);
-  
+  joint.iSum = Float(1.0) / (a.i_inv + b.i_inv)
   // calculate bias velocity
-  cpFloat maxBias = joint->constraint.maxBias;
-  joint->bias = cpfclamp(-joint->constraint.biasCoef*dt_inv*(pdist), -maxBias, maxBias);
-  
+  maxBias   := joint.maxBias
+  joint.bias = (-joint.biasCoef * dt_inv * pdist).Clamp(-maxBias, maxBias)
   // compute max impulse
-  joint->jMax = J_MAX(joint, dt);
-
+  joint.jMax = joint.maxForce * dt
   // If the bias is 0, the joint is not at a limit. Reset the impulse.
-  if(!joint->bias)
-    joint->jAcc = 0.0f;
-
+  if joint.bias == 0.0 {
+    joint.jAcc = Float(0.0)
+  }
   // apply joint torque
-  a->w -= joint->jAcc*a->i_inv;
-  b->w += joint->jAcc*b->i_inv;
+  a.w -= joint.jAcc * a.i_inv
+  b.w += joint.jAcc * b.i_inv
 }
 
-static void
-applyImpulse(cpRotaryLimitJoint *joint)
-{
-  if(!joint->bias) return; // early exit
-
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
+func (joint * RotaryLimitJoint) ApplyImpulse() {
+  if joint.bias == 0.0 { return } // early exit
+  a, b := joint.Bodies()
   // compute relative rotational velocity
-  cpFloat wr = b->w - a->w;
-  
-  // compute normal impulse 
-  cpFloat j = -(joint->bias + wr)*joint->iSum;
-  cpFloat jOld = joint->jAcc;
-  if(joint->bias < 0.0f){
-    joint->jAcc = cpfclamp(jOld + j, 0.0f, joint->jMax);
+  wr := b.w - a.w
+  // compute normal impulse
+  j    := -(joint.bias + wr) * joint.iSum
+  jOld := joint.jAcc
+  if joint.bias < 0.0 {
+    joint.jAcc = (jOld + j).Clamp(0.0, joint.jMax)
   } else {
-    joint->jAcc = cpfclamp(jOld + j, -joint->jMax, 0.0f);
+    joint.jAcc = (jOld + j).Clamp(-joint.jMax, 0.0)
   }
-  j = joint->jAcc - jOld;
-  
+  j = joint.jAcc - jOld
   // apply impulse
-  a->w -= j*a->i_inv;
-  b->w += j*b->i_inv;
+  a.w -= j * a.i_inv
+  b.w += j * b.i_inv
 }
 
-static cpFloat
-getImpulse(cpRotaryLimitJoint *joint)
-{
-  return cpfabs(joint->jAcc);
+func (joint * RotaryLimitJoint) GetImpulse() (Float) {
+  return joint.jAcc.Abs()
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpRotaryLimitJoint)
-
-cpRotaryLimitJoint *
-cpRotaryLimitJointAlloc(void)
-{
-  return (cpRotaryLimitJoint *)cpmalloc(sizeof(cpRotaryLimitJoint));
+func RotaryLimitJointAlloc() (* RotaryLimitJoint) {
+  return &RotaryLimitJoint{}
 }
 
-cpRotaryLimitJoint *
-cpRotaryLimitJointInit(cpRotaryLimitJoint *joint, cpBody *a, cpBody *b, cpFloat min, cpFloat max)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->min = min;
-  joint->max  = max;
-  
-  joint->jAcc = 0.0f;
-  
-  return joint;
+func (joint * RotaryLimitJoint) Init(a, b *Body, 
+      min, max Float) (* RotaryLimitJoint) {
+  joint.constraint.Init(a, b)
+  joint.min  = min
+  joint.max  = max
+  joint.jAcc = Float(0.0)
+  return joint
 }
 
-cpConstraint *
-cpRotaryLimitJointNew(cpBody *a, cpBody *b, cpFloat min, cpFloat max)
-{
-  return (cpConstraint *)cpRotaryLimitJointInit(cpRotaryLimitJointAlloc(), a, b, min, max);
+func RotaryLimitJointNew(a, b *Body, min, max Float) (* RotaryLimitJoint) {
+  return RotaryLimitJointAlloc().Init(a, b, min, max)
 }
 
-static void
-preStep(cpSimpleMotor *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
+func (joint * SimpleMotor) PreStep(dt, dt_inv Float) {
+  a, b := joint.Bodies()
   // calculate moment of inertia coefficient.
-  joint->iSum = 1.0f/(a->i_inv + b->i_inv);
-  
+  joint.iSum = Float(1.0) / (a.i_inv + b.i_inv)
   // compute max impulse
-  joint->jMax = J_MAX(joint, dt);
-
+  joint.jMax = joint.maxForce * dt
   // apply joint torque
-  a->w -= joint->jAcc*a->i_inv;
-  b->w += joint->jAcc*b->i_inv;
+  a.w -= joint.jAcc * a.i_inv
+  b.w += joint.jAcc * b.i_inv
 }
 
-static void
-applyImpulse(cpSimpleMotor *joint)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
+func (joint * SimpleMotor) ApplyImpulse() {
+  a, b := joint.Bodies()
   // compute relative rotational velocity
-  cpFloat wr = b->w - a->w + joint->rate;
-  
-  // compute normal impulse 
-  cpFloat j = -wr*joint->iSum;
-  cpFloat jOld = joint->jAcc;
-  joint->jAcc = cpfclamp(jOld + j, -joint->jMax, joint->jMax);
-  j = joint->jAcc - jOld;
-  
+  wr := b.w - a.w + joint.rate
+  // compute normal impulse
+  j         := -wr * joint.iSum
+  jOld      := joint.jAcc
+  joint.jAcc = (jOld + j).Clamp(-joint.jMax, joint.jMax)
+  j          = joint.jAcc - jOld
   // apply impulse
-  a->w -= j*a->i_inv;
-  b->w += j*b->i_inv;
+  a.w -= j * a.i_inv
+  b.w += j * b.i_inv
 }
 
-static cpFloat
-getImpulse(cpSimpleMotor *joint)
-{
-  return cpfabs(joint->jAcc);
+func (joint * SimpleMotor) GetImpulse() (Float) {
+  return joint.jAcc.Abs()
 }
 
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpSimpleMotor)
-
-cpSimpleMotor *
-cpSimpleMotorAlloc(void)
-{
-  return (cpSimpleMotor *)cpmalloc(sizeof(cpSimpleMotor));
+func SimpleMotorAlloc() (* SimpleMotor) {
+  return &SimpleMotor{}
 }
 
-cpSimpleMotor *
-cpSimpleMotorInit(cpSimpleMotor *joint, cpBody *a, cpBody *b, cpFloat rate)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->rate = rate;
-  
-  joint->jAcc = 0.0f;
-  
-  return joint;
+func (joint * SimpleMotor) Init(a, b *Body, rate Float) (* SimpleMotor) {
+  joint.constraint.Init(a, b)
+  joint.rate = rate
+  joint.jAcc = Float(0.0)
+  return joint
 }
 
-cpConstraint *
-cpSimpleMotorNew(cpBody *a, cpBody *b, cpFloat rate)
-{
-  return (cpConstraint *)cpSimpleMotorInit(cpSimpleMotorAlloc(), a, b, rate);
+func SimpleMotorNew(a, b *Body, rate Float) (* SimpleMotor) {
+  return SimpleMotorAlloc().Init(a, b, rate)
 }
 
-static void
-preStep(cpSlideJoint *joint, cpFloat dt, cpFloat dt_inv)
-{
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  joint->r1 = cpvrotate(joint->anchr1, a->rot);
-  joint->r2 = cpvrotate(joint->anchr2, b->rot);
-  
-  cpVect delta = cpvsub(cpvadd(b->p, joint->r2), cpvadd(a->p, joint->r1));
-  cpFloat dist = cpvlength(delta);
-  cpFloat pdist = 0.0f;
-  if(dist > joint->max) {
-    pdist = dist - joint->max;
-  } else if(dist < joint->min) {
-    pdist = joint->min - dist;
-    dist = -dist;
+func (joint * SlideJoint) PreStep(dt, dt_inv Float) {
+  a, b    := joint.Bodies()
+  joint.r1 = joint.anchr1.Rotate(a.rot)
+  joint.r2 = joint.anchr2.Rotate(b.rot)
+  delta   := b.p.Add(joint.r2).Sub(a.p.Add(joint.r1))
+  dist    := delta.Length()
+  pdist   := Float(0.0)
+  if dist > joint.max {
+    pdist = dist - joint.max
+  } else if dist < joint.min {
+    pdist = joint.min - dist
+    dist  = -dist
+  }
+  if dist != 0.0 {
+    joint.n = delta.Mult(Float(1.0) / dist)
+  } else {
+    joint.n = VZERO
   }
-  joint->n = cpvmult(delta, 1.0f/(dist ? dist : (cpFloat)INFINITY));
-  
   // calculate mass normal
-  joint->nMass = 1.0f/k_scalar(a, b, joint->r1, joint->r2, joint->n);
-  
+  joint.nMass = Float(1.0) / KScalar(a, b, joint.r1, joint.r2, joint.n)
   // calculate bias velocity
-  cpFloat maxBias = joint->constraint.maxBias;
-  joint->bias = cpfclamp(-joint->constraint.biasCoef*dt_inv*(pdist), -maxBias, maxBias);
-  
+  maxBias   := joint.maxBias
+  joint.bias = (-joint.biasCoef * dt_inv * pdist).Clamp(-maxBias, maxBias)
   // compute max impulse
-  joint->jnMax = J_MAX(joint, dt);
-
+  joint.jnMax = joint.maxForce * dt
+  // if bias is 0, then the joint is not at a limit.
+  if joint.bias == 0.0 {
+    joint.jnAcc = Float(0.0)
+  }
   // apply accumulated impulse
-  if(!joint->bias) //{
-    // if bias is 0, then the joint is not at a limit.
-    joint->jnAcc = 0.0f;
-//  } else {
-    cpVect j = cpvmult(joint->n, joint->jnAcc);
-    apply_impulses(a, b, joint->r1, joint->r2, j);
-//  }
-}
-
-static void
-applyImpulse(cpSlideJoint *joint)
-{
-  if(!joint->bias) return;  // early exit
-
-  cpBody *a = joint->constraint.a;
-  cpBody *b = joint->constraint.b;
-  
-  cpVect n = joint->n;
-  cpVect r1 = joint->r1;
-  cpVect r2 = joint->r2;
-    
+  ApplyImpulses(a, b, joint.r1, joint.r2, joint.n.Mult(joint.jnAcc))
+}
+
+func (joint * SlideJoint) ApplyImpulse() {
+  if joint.bias == 0.0 { return } // early exit
+  a, b := joint.Bodies()
+  n    := joint.n
   // compute relative velocity
-  cpVect vr = relative_velocity(a, b, r1, r2);
-  cpFloat vrn = cpvdot(vr, n);
-  
+  vrn := RelativeVelocity(a, b, joint.r1, joint.r2).Dot(n)
   // compute normal impulse
-  cpFloat jn = (joint->bias - vrn)*joint->nMass;
-  cpFloat jnOld = joint->jnAcc;
-  joint->jnAcc = cpfclamp(jnOld + jn, -joint->jnMax, 0.0f);
-  jn = joint->jnAcc - jnOld;
-  
+  jn          := (joint.bias - vrn) * joint.nMass
+  jnOld       := joint.jnAcc
+  joint.jnAcc  = (jnOld + jn).Clamp(-joint.jnMax, 0.0)
+  jn           = joint.jnAcc - jnOld
   // apply impulse
-  apply_impulses(a, b, joint->r1, joint->r2, cpvmult(n, jn));
-}
-
-static cpFloat
-getImpulse(cpConstraint *joint)
-{
-  return cpfabs(((cpSlideJoint *)joint)->jnAcc);
-}
-
-static const cpConstraintClass klass = {
-  (cpConstraintPreStepFunction)preStep,
-  (cpConstraintApplyImpulseFunction)applyImpulse,
-  (cpConstraintGetImpulseFunction)getImpulse,
-};
-CP_DefineClassGetter(cpSlideJoint)
-
-cpSlideJoint *
-cpSlideJointAlloc(void)
-{
-  return (cpSlideJoint *)cpmalloc(sizeof(cpSlideJoint));
-}
-
-cpSlideJoint *
-cpSlideJointInit(cpSlideJoint *joint, cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2, cpFloat min, cpFloat max)
-{
-  cpConstraintInit((cpConstraint *)joint, &klass, a, b);
-  
-  joint->anchr1 = anchr1;
-  joint->anchr2 = anchr2;
-  joint->min = min;
-  joint->max = max;
-  
-  joint->jnAcc = 0.0f;
-  
-  return joint;
-}
-
-cpConstraint *
-cpSlideJointNew(cpBody *a, cpBody *b, cpVect anchr1, cpVect anchr2, cpFloat min, cpFloat max)
-{
-  return (cpConstraint *)cpSlideJointInit(cpSlideJointAlloc(), a, b, anchr1, anchr2, min, max);
+  ApplyImpulses(a, b, joint.r1, joint.r2, n.Mult(jn))
+}
+
+func (joint * SlideJoint) GetImpulse() (Float) {
+  return joint.jnAcc.Abs()
+}
+
+func SlideJointAlloc() (* SlideJoint) {
+  return &SlideJoint{}
+}
+
+func (joint * SlideJoint) Init(a, b *Body, anchr1, anchr2 Vect, 
+      min, max Float) (* SlideJoint) {
+  joint.constraint.Init(a, b)
+  joint.anchr1 = anchr1
+  joint.anchr2 = anchr2
+  joint.min    = min
+  joint.max    = max
+  joint.jnAcc  = Float(0.0)
+  return joint
+}
+
+func SlideJointNew(a, b *Body, anchr1, anchr2 Vect, 
+      min, max Float) (* SlideJoint) {
+  return SlideJointAlloc().Init(a, b, anchr1, anchr2, min, max)
+}
+
+// Returns the base of a constraint, or nil for an unknown kind.
+func constraintBase(con Constraint) (*constraint) {
+  switch joint := con.(type) {
+    case *DampedRotarySpring:
+      return &joint.constraint
+    case *DampedSpring:
+      return &joint.constraint
+    case *GearJoint:
+      return &joint.constraint
+    case *GrooveJoint:
+      return &joint.constraint
+    case *PinJoint:
+      return &joint.constraint
+    case *PivotJoint:
+      return &joint.constraint
+    case *RatchetJoint:
+      return &joint.constraint
+    case *RotaryLimitJoint:
+      return &joint.constraint
+    case *SimpleMotor:
+      return &joint.constraint
+    case *SlideJoint:
+      return &joint.constraint
+  }
+  return nil
+}
+
+// ConstraintAnchors returns the points where the constraint acts on its
+// bodies, in world coordinates. For constraints without anchors these are
+// the positions of the bodies, and for groove joints the start of the
+// groove.
+func ConstraintAnchors(con Constraint) (Vect, Vect) {
+  base := constraintBase(con)
+  if base == nil { return VZERO, VZERO }
+  anchr1, anchr2 := VZERO, VZERO
+  switch joint := con.(type) {
+    case *DampedSpring:
+      anchr1, anchr2 = joint.anchr1, joint.anchr2
+    case *GrooveJoint:
+      anchr1, anchr2 = joint.grv_a, joint.anchr2
+    case *PinJoint:
+      anchr1, anchr2 = joint.anchr1, joint.anchr2
+    case *PivotJoint:
+      anchr1, anchr2 = joint.anchr1, joint.anchr2
+    case *SlideJoint:
+      anchr1, anchr2 = joint.anchr1, joint.anchr2
+  }
+  return base.a.Local2World(anchr1), base.b.Local2World(anchr2)
 }
-
-
-
-*/
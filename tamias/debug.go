@@ -0,0 +1,159 @@
+package tamias
+
+// Debug drawing of spaces, through an interface that any renderer can
+// implement.
+
+// A color, with components from 0 to 1.
+type DebugColor struct {
+  R, G, B, A Float
+}
+
+// DebugDrawer draws the primitives of a debug drawing, in world
+// coordinates.
+type DebugDrawer interface {
+  // Draws a circle, with a mark that shows its angle.
+  DrawCircle(center Vect, angle, radius Float, outline, fill DebugColor)
+  DrawSegment(a, b Vect, color DebugColor)
+  // Draws a segment with a radius.
+  DrawFatSegment(a, b Vect, radius Float, outline, fill DebugColor)
+  DrawPolygon(verts []Vect, outline, fill DebugColor)
+  // Draws a dot with a size in pixels.
+  DrawDot(size Float, pos Vect, color DebugColor)
+}
+
+// How shapes are colored.
+type DebugColorMode int
+
+const (
+  // Shapes of static bodies get StaticColor, others ShapeColor.
+  DEBUG_COLOR_DEFAULT DebugColorMode = iota
+  // Shapes get a color of the palette, by collision type.
+  DEBUG_COLOR_COLLISION_TYPE
+)
+
+type DebugDrawOptions struct {
+  // What to draw.
+  Shapes, Constraints, Contacts, BBs bool
+  ColorMode DebugColorMode
+  ShapeColor, StaticColor DebugColor
+  ConstraintColor, ContactColor, BBColor DebugColor
+  // Colors for DEBUG_COLOR_COLLISION_TYPE.
+  Palette []DebugColor
+  // Length of the contact normals, in world units.
+  NormalLength Float
+}
+
+var DefaultDebugDrawOptions = DebugDrawOptions{
+  Shapes:          true,
+  Constraints:     true,
+  Contacts:        true,
+  BBs:             false,
+  ColorMode:       DEBUG_COLOR_DEFAULT,
+  ShapeColor:      DebugColor{0.2, 0.4, 0.75, 1.0},
+  StaticColor:     DebugColor{0.4, 0.4, 0.4, 1.0},
+  ConstraintColor: DebugColor{0.2, 0.6, 0.2, 1.0},
+  ContactColor:    DebugColor{0.9, 0.1, 0.1, 1.0},
+  BBColor:         DebugColor{0.75, 0.6, 0.1, 1.0},
+  Palette:         []DebugColor{DebugColor{0.2, 0.4, 0.75, 1.0},
+    DebugColor{0.75, 0.3, 0.2, 1.0}, DebugColor{0.3, 0.65, 0.3, 1.0},
+    DebugColor{0.6, 0.3, 0.7, 1.0}, DebugColor{0.8, 0.6, 0.1, 1.0},
+    DebugColor{0.2, 0.6, 0.65, 1.0}},
+  NormalLength:    1.0,
+}
+
+// Returns a translucent version of the color, to fill shapes with.
+func (color DebugColor) Translucent() (DebugColor) {
+  color.A *= 0.25
+  return color
+}
+
+func (options *DebugDrawOptions) shapeColor(shape *Shape,
+  static bool) (DebugColor) {
+  switch options.ColorMode {
+    case DEBUG_COLOR_COLLISION_TYPE:
+      if len(options.Palette) > 0 {
+        i := int(shape.collision_type) % len(options.Palette)
+        if i < 0 { i += len(options.Palette) }
+        return options.Palette[i]
+      }
+  }
+  if static { return options.StaticColor }
+  return options.ShapeColor
+}
+
+// Draws a shape with the drawer.
+func debugDrawShape(drawer DebugDrawer, shape *Shape, color DebugColor) {
+  body := shape.Body
+  fill := color.Translucent()
+  switch geometry := shape.geometry.(type) {
+    case *CircleShape:
+      center := body.Local2World(geometry.c)
+      drawer.DrawCircle(center, body.a, geometry.r, color, fill)
+    case *SegmentShape:
+      a := body.Local2World(geometry.a)
+      b := body.Local2World(geometry.b)
+      drawer.DrawFatSegment(a, b, geometry.r, color, fill)
+    case *PolyShape:
+      verts := make([]Vect, geometry.numVerts)
+      for i := range verts {
+        verts[i] = body.Local2World(geometry.verts[i])
+      }
+      drawer.DrawPolygon(verts, color, fill)
+      // Rounded polygons get their rim as fat edges.
+      for i:=0; i < len(verts) && geometry.r > 0; i++ {
+        drawer.DrawFatSegment(verts[i], verts[(i + 1) % len(verts)],
+          geometry.r, color, fill)
+      }
+    case *HeightfieldShape:
+      for i:=0; i + 1 < len(geometry.heights); i++ {
+        drawer.DrawSegment(body.Local2World(geometry.point(i)),
+          body.Local2World(geometry.point(i + 1)), color)
+      }
+  }
+}
+
+func debugDrawBB(drawer DebugDrawer, bb *BB, color DebugColor) {
+  // The top and bottom of a BB are not always in order.
+  b, t := bb.B.Min(bb.T), bb.B.Max(bb.T)
+  drawer.DrawPolygon([]Vect{V(bb.L, b), V(bb.L, t), V(bb.R, t), V(bb.R, b)},
+    color, DebugColor{})
+}
+
+// DebugDraw draws the shapes, constraints, contacts and bounding boxes of
+// the space with the drawer, as the options say. Uses the default options
+// if options is nil.
+func (space *Space) DebugDraw(drawer DebugDrawer, options *DebugDrawOptions) {
+  if options == nil { options = &DefaultDebugDrawOptions }
+  if options.Shapes || options.BBs {
+    draw := func(shape *Shape, static bool) {
+      if options.Shapes {
+        debugDrawShape(drawer, shape, options.shapeColor(shape, static))
+      }
+      if options.BBs && shape.BB != nil {
+        debugDrawBB(drawer, shape.BB, options.BBColor)
+      }
+    }
+    space.EachStaticShape(func(shape *Shape) { draw(shape, true) })
+    space.EachShape(func(shape *Shape) { draw(shape, false) })
+  }
+  if options.Constraints {
+    color := options.ConstraintColor
+    space.EachConstraint(func(constraint Constraint) {
+      a, b := ConstraintAnchors(constraint)
+      drawer.DrawSegment(a, b, color)
+      drawer.DrawDot(5.0, a, color)
+      drawer.DrawDot(5.0, b, color)
+    })
+  }
+  if options.Contacts {
+    color := options.ContactColor
+    space.EachArbiter(func(arb *Arbiter) {
+      for i:=0; i < arb.numContacts; i++ {
+        p := arb.GetPoint(i)
+        n := arb.GetNormal(i).Mult(options.NormalLength)
+        drawer.DrawSegment(p, p.Add(n), color)
+        drawer.DrawDot(4.0, p, color)
+      }
+    })
+  }
+}
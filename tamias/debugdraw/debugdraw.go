@@ -0,0 +1,234 @@
+// Package debugdraw draws tamias spaces into images, without a display,
+// to debug physics on servers. Frames can be saved as PNG images or as
+// an animated GIF. Renderer implements tamias.DebugDrawer.
+package debugdraw
+
+import "image"
+import "image/png"
+import "io"
+import "os"
+import "tamias"
+
+// The camera decides which part of the world is drawn.
+type Camera struct {
+  // World point at the center of the image.
+  Center tamias.Vect
+  // Pixels per unit of the world.
+  Zoom tamias.Float
+  // Rotation of the view, in radians.
+  Angle tamias.Float
+}
+
+// Renderer draws into an RGBA image. The Y axis of the world points up.
+type Renderer struct {
+  Image *image.RGBA
+  Camera Camera
+  Background image.RGBAColor
+  Options tamias.DebugDrawOptions
+}
+
+func RendererAlloc() (*Renderer) {
+  return &Renderer{}
+}
+
+func (r *Renderer) Init(width, height int) (*Renderer) {
+  r.Image   = image.NewRGBA(image.Rect(0, 0, width, height))
+  r.Camera     = Camera{tamias.VZERO, 1.0, 0.0}
+  r.Background = image.RGBAColor{0xff, 0xff, 0xff, 0xff}
+  r.Options    = tamias.DefaultDebugDrawOptions
+  return r
+}
+
+func RendererNew(width, height int) (*Renderer) {
+  return RendererAlloc().Init(width, height)
+}
+
+// Fills the whole image with a color.
+func (r *Renderer) Clear(color image.RGBAColor) {
+  bounds := r.Image.Bounds()
+  for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+    for x := bounds.Min.X; x < bounds.Max.X; x++ {
+      r.Image.Set(x, y, color)
+    }
+  }
+}
+
+// Converts a point of the world to pixel coordinates.
+func (r *Renderer) ToScreen(p tamias.Vect) (tamias.Vect) {
+  bounds := r.Image.Bounds()
+  rot    := r.Camera.Angle.VectForAngle()
+  v      := p.Sub(r.Camera.Center).Unrotate(rot).Mult(r.Camera.Zoom)
+  return tamias.V(tamias.Float(bounds.Dx()) / 2.0 + v.X,
+    tamias.Float(bounds.Dy()) / 2.0 - v.Y)
+}
+
+// Blends a color over a pixel, according to its alpha.
+func (r *Renderer) blend(x, y int, color image.RGBAColor) {
+  if color.A == 0 || !(image.Point{x, y}).In(r.Image.Bounds()) { return }
+  if color.A == 0xff {
+    r.Image.Set(x, y, color)
+    return
+  }
+  dst := r.Image.At(x, y).(image.RGBAColor)
+  a   := uint32(color.A)
+  mix := func(dst uint8, src uint8) (uint8) {
+    return uint8((uint32(src) * a + uint32(dst) * (255 - a)) / 255)
+  }
+  r.Image.Set(x, y, image.RGBAColor{mix(dst.R, color.R), mix(dst.G, color.G),
+    mix(dst.B, color.B), mix(dst.A, 0xff)})
+}
+
+// Converts a color of a debug drawing to a color of the image.
+func toRGBA(color tamias.DebugColor) (image.RGBAColor) {
+  channel := func(c tamias.Float) (uint8) {
+    return uint8((c.Max(0.0).Min(1.0) * 255.0 + 0.5).Floor())
+  }
+  return image.RGBAColor{channel(color.R), channel(color.G),
+    channel(color.B), channel(color.A)}
+}
+
+func imin(a, b int) (int) {
+  if a < b { return a }
+  return b
+}
+
+func imax(a, b int) (int) {
+  if a > b { return a }
+  return b
+}
+
+// Calls plot for the center of every pixel in the box of pixel
+// coordinates that is also in the image.
+func (r *Renderer) eachPixel(min, max tamias.Vect,
+  plot func(x, y int, p tamias.Vect)) {
+  bounds := r.Image.Bounds()
+  x0 := imax(int(min.X.Floor()), bounds.Min.X)
+  y0 := imax(int(min.Y.Floor()), bounds.Min.Y)
+  x1 := imin(int(max.X.Floor()), bounds.Max.X - 1)
+  y1 := imin(int(max.Y.Floor()), bounds.Max.Y - 1)
+  for y := y0; y <= y1; y++ {
+    for x := x0; x <= x1; x++ {
+      plot(x, y, tamias.V(tamias.Float(x) + 0.5, tamias.Float(y) + 0.5))
+    }
+  }
+}
+
+// Draws a line of one pixel wide between two points in pixel coordinates.
+func (r *Renderer) line(a, b tamias.Vect, color image.RGBAColor) {
+  d     := b.Sub(a)
+  steps := int(d.X.Abs().Max(d.Y.Abs()).Floor()) + 1
+  for i:=0; i <= steps; i++ {
+    p := a.Lerp(b, tamias.Float(i) / tamias.Float(steps))
+    r.blend(int(p.X.Floor()), int(p.Y.Floor()), color)
+  }
+}
+
+// Returns the distance from p to the segment ab.
+func segmentDist(p, a, b tamias.Vect) (tamias.Float) {
+  ab := b.Sub(a)
+  t  := tamias.Float(0.0)
+  if l := ab.Lengthsq(); l > 0 {
+    t = (p.Sub(a).Dot(ab) / l).Max(0.0).Min(1.0)
+  }
+  return p.Dist(a.Add(ab.Mult(t)))
+}
+
+// Fills a capsule around the segment ab, in pixel coordinates.
+func (r *Renderer) fillCapsule(a, b tamias.Vect, radius tamias.Float,
+  color image.RGBAColor) {
+  pad := tamias.V(radius, radius)
+  min := tamias.V(a.X.Min(b.X), a.Y.Min(b.Y)).Sub(pad)
+  max := tamias.V(a.X.Max(b.X), a.Y.Max(b.Y)).Add(pad)
+  r.eachPixel(min, max, func(x, y int, p tamias.Vect) {
+    if segmentDist(p, a, b) <= radius { r.blend(x, y, color) }
+  })
+}
+
+// Fills a polygon in pixel coordinates, with the even-odd rule.
+func (r *Renderer) fillPolygon(verts []tamias.Vect, color image.RGBAColor) {
+  if len(verts) < 3 { return }
+  min, max := verts[0], verts[0]
+  for _, v := range verts {
+    min = tamias.V(min.X.Min(v.X), min.Y.Min(v.Y))
+    max = tamias.V(max.X.Max(v.X), max.Y.Max(v.Y))
+  }
+  r.eachPixel(min, max, func(x, y int, p tamias.Vect) {
+    inside := false
+    for i, a := range verts {
+      b := verts[(i + 1) % len(verts)]
+      if (a.Y > p.Y) != (b.Y > p.Y) &&
+         p.X < a.X + (p.Y - a.Y) * (b.X - a.X) / (b.Y - a.Y) {
+        inside = !inside
+      }
+    }
+    if inside { r.blend(x, y, color) }
+  })
+}
+
+// Draws a circle, with a line from the center that shows its rotation.
+func (r *Renderer) DrawCircle(center tamias.Vect, angle, radius tamias.Float,
+  outline, fill tamias.DebugColor) {
+  line   := toRGBA(outline)
+  c      := r.ToScreen(center)
+  pixels := radius * r.Camera.Zoom
+  r.fillCapsule(c, c, pixels, toRGBA(fill))
+  // The outline is the ring of pixels at the radius.
+  pad := tamias.V(pixels + 1, pixels + 1)
+  r.eachPixel(c.Sub(pad), c.Add(pad), func(x, y int, p tamias.Vect) {
+    if (p.Dist(c) - pixels).Abs() <= 0.5 { r.blend(x, y, line) }
+  })
+  edge := center.Add(angle.VectForAngle().Mult(radius))
+  r.line(c, r.ToScreen(edge), line)
+}
+
+// Draws a thin line between two points of the world.
+func (r *Renderer) DrawSegment(a, b tamias.Vect, color tamias.DebugColor) {
+  r.line(r.ToScreen(a), r.ToScreen(b), toRGBA(color))
+}
+
+// Draws a segment with a radius, as a capsule.
+func (r *Renderer) DrawFatSegment(a, b tamias.Vect, radius tamias.Float,
+  outline, fill tamias.DebugColor) {
+  sa, sb := r.ToScreen(a), r.ToScreen(b)
+  if radius * r.Camera.Zoom >= 1 {
+    r.fillCapsule(sa, sb, radius * r.Camera.Zoom, toRGBA(fill))
+  }
+  r.line(sa, sb, toRGBA(outline))
+}
+
+// Draws a polygon with the vertexes in world coordinates.
+func (r *Renderer) DrawPolygon(verts []tamias.Vect,
+  outline, fill tamias.DebugColor) {
+  screen := make([]tamias.Vect, len(verts))
+  for i, v := range verts {
+    screen[i] = r.ToScreen(v)
+  }
+  r.fillPolygon(screen, toRGBA(fill))
+  line := toRGBA(outline)
+  for i, a := range screen {
+    r.line(a, screen[(i + 1) % len(screen)], line)
+  }
+}
+
+// Draws a dot with a size in pixels.
+func (r *Renderer) DrawDot(size tamias.Float, pos tamias.Vect,
+  color tamias.DebugColor) {
+  p := r.ToScreen(pos)
+  r.fillCapsule(p, p, size / 2.0, toRGBA(color))
+}
+
+// Length of contact normals, in pixels.
+const normalLength = 10.0
+
+// DrawSpace clears the image and draws the space, as the options say.
+func (r *Renderer) DrawSpace(space *tamias.Space) {
+  r.Clear(r.Background)
+  options             := r.Options
+  options.NormalLength = normalLength / r.Camera.Zoom
+  space.DebugDraw(r, &options)
+}
+
+// Writes the image as PNG.
+func (r *Renderer) WritePNG(w io.Writer) (os.Error) {
+  return png.Encode(w, r.Image)
+}
@@ -0,0 +1,151 @@
+package debugdraw
+
+// A minimal encoder of animated GIF images, for sequences of frames.
+// All frames use the same palette, a cube of 6 levels of red, green and
+// blue, which is good enough for debug drawings.
+
+import "bufio"
+import "compress/lzw"
+import "image"
+import "io"
+import "os"
+
+type GIFEncoder struct {
+  w *bufio.Writer
+  width, height int
+  // Time that each frame is shown, in hundredths of a second.
+  Delay int
+  started bool
+  err os.Error
+}
+
+// Writes data in the sub-blocks of at most 255 bytes that GIF uses.
+type gifBlockWriter struct {
+  w *bufio.Writer
+  block [256]byte
+  size int
+}
+
+func (b *gifBlockWriter) Write(data []byte) (int, os.Error) {
+  for i, c := range data {
+    b.size++
+    b.block[b.size] = c
+    if b.size == 255 {
+      if err := b.flush(); err != nil { return i, err }
+    }
+  }
+  return len(data), nil
+}
+
+func (b *gifBlockWriter) flush() (os.Error) {
+  if b.size == 0 { return nil }
+  b.block[0]  = byte(b.size)
+  _, err     := b.w.Write(b.block[0:b.size + 1])
+  b.size      = 0
+  return err
+}
+
+// Writes the last block, and the empty block that ends the data.
+func (b *gifBlockWriter) Close() (os.Error) {
+  if err := b.flush(); err != nil { return err }
+  return b.w.WriteByte(0)
+}
+
+func GIFEncoderAlloc() (*GIFEncoder) {
+  return &GIFEncoder{}
+}
+
+func (enc *GIFEncoder) Init(w io.Writer, width, height, delay int) (
+  *GIFEncoder) {
+  enc.w      = bufio.NewWriter(w)
+  enc.width  = width
+  enc.height = height
+  enc.Delay  = delay
+  return enc
+}
+
+// GIFEncoderNew returns an encoder that writes an animation of frames of
+// the given size to w. Each frame is shown for delay hundredths of a
+// second, and the animation loops forever.
+func GIFEncoderNew(w io.Writer, width, height, delay int) (*GIFEncoder) {
+  return GIFEncoderAlloc().Init(w, width, height, delay)
+}
+
+func (enc *GIFEncoder) write(data ...byte) {
+  if enc.err != nil { return }
+  _, enc.err = enc.w.Write(data)
+}
+
+func (enc *GIFEncoder) writeUint16(v int) {
+  enc.write(byte(v), byte(v >> 8))
+}
+
+// Returns the index in the palette of the level of a color component.
+func gifLevel(c uint32) (byte) {
+  return byte(((c >> 8) * 5 + 127) / 255)
+}
+
+func (enc *GIFEncoder) header() {
+  enc.write([]byte("GIF89a")...)
+  enc.writeUint16(enc.width)
+  enc.writeUint16(enc.height)
+  // A global palette of 256 colors, and no background or aspect ratio.
+  enc.write(0xf7, 0, 0)
+  for i:=0; i < 256; i++ {
+    if i < 216 {
+      enc.write(byte(i / 36 * 51), byte(i / 6 % 6 * 51), byte(i % 6 * 51))
+    } else {
+      enc.write(0, 0, 0)
+    }
+  }
+  // Loop forever.
+  enc.write(0x21, 0xff, 11)
+  enc.write([]byte("NETSCAPE2.0")...)
+  enc.write(3, 1, 0, 0, 0)
+  enc.started = true
+}
+
+// AddFrame writes an image as the next frame. It is cropped to the size of
+// the animation.
+func (enc *GIFEncoder) AddFrame(img image.Image) (os.Error) {
+  if !enc.started { enc.header() }
+  enc.write(0x21, 0xf9, 4, 0)
+  enc.writeUint16(enc.Delay)
+  enc.write(0, 0)
+  enc.write(0x2c)
+  enc.writeUint16(0)
+  enc.writeUint16(0)
+  enc.writeUint16(enc.width)
+  enc.writeUint16(enc.height)
+  enc.write(0)
+  // Minimum code size of the compressed data.
+  enc.write(8)
+  if enc.err != nil { return enc.err }
+
+  blocks := &gifBlockWriter{w: enc.w}
+  lzwer  := lzw.NewWriter(blocks, lzw.LSB, 8)
+  bounds := img.Bounds()
+  row    := make([]byte, enc.width)
+  for y:=0; y < enc.height && enc.err == nil; y++ {
+    for x:=0; x < enc.width; x++ {
+      row[x] = 0
+      p := image.Point{bounds.Min.X + x, bounds.Min.Y + y}
+      if p.In(bounds) {
+        r, g, b, _ := img.At(p.X, p.Y).RGBA()
+        row[x] = gifLevel(r) * 36 + gifLevel(g) * 6 + gifLevel(b)
+      }
+    }
+    _, enc.err = lzwer.Write(row)
+  }
+  if enc.err == nil { enc.err = lzwer.Close() }
+  if enc.err == nil { enc.err = blocks.Close() }
+  return enc.err
+}
+
+// Close ends the animation. It doesn't close the underlying writer.
+func (enc *GIFEncoder) Close() (os.Error) {
+  if !enc.started { enc.header() }
+  enc.write(0x3b)
+  if enc.err == nil { enc.err = enc.w.Flush() }
+  return enc.err
+}
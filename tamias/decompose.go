@@ -0,0 +1,235 @@
+package tamias
+
+// Decomposition of concave polygons into convex pieces, which PolyShape
+// can use.
+
+import "os"
+
+// The error of the decompositions for polygons whose edges cross.
+var ErrSelfIntersecting = os.NewError("polygon is self-intersecting")
+
+// AreaForPoly returns the signed area of a polygon. It is positive if the
+// vertexes are counter-clockwise, and negative if they are clockwise.
+func AreaForPoly(verts []Vect) (Float) {
+  area := Float(0.0)
+  for i, v := range verts {
+    area += v.Cross(verts[(i + 1) % len(verts)])
+  }
+  return area / 2.0
+}
+
+// ReverseVerts reverses the order of the vertexes in place, which
+// reverses the winding of the polygon.
+func ReverseVerts(verts []Vect) {
+  for i, j := 0, len(verts) - 1; i < j; i, j = i + 1, j - 1 {
+    verts[i], verts[j] = verts[j], verts[i]
+  }
+}
+
+// Returns a copy of a simple polygon with a counter-clockwise winding, or
+// an error if the polygon isn't simple.
+func counterClockwise(verts []Vect) ([]Vect, os.Error) {
+  if PolySelfIntersects(verts) { return nil, ErrSelfIntersecting }
+  area := AreaForPoly(verts)
+  if area == 0 { return nil, os.NewError("polygon has no area") }
+  ccw := make([]Vect, len(verts))
+  copy(ccw, verts)
+  if area < 0 { ReverseVerts(ccw) }
+  return ccw, nil
+}
+
+// Returns the orientation of c to the line through a and b: positive to
+// the left, negative to the right, and 0 on the line.
+func orientation(a, b, c Vect) (Float) {
+  return b.Sub(a).Cross(c.Sub(a))
+}
+
+// Returns true if p is on the segment ab, knowing that it is on its line.
+func onSegment(a, b, p Vect) (bool) {
+  return p.X >= a.X.Min(b.X) && p.X <= a.X.Max(b.X) &&
+    p.Y >= a.Y.Min(b.Y) && p.Y <= a.Y.Max(b.Y)
+}
+
+// Returns true if the segments ab and cd touch or cross.
+func segmentsIntersect(a, b, c, d Vect) (bool) {
+  o1, o2 := orientation(a, b, c), orientation(a, b, d)
+  o3, o4 := orientation(c, d, a), orientation(c, d, b)
+  if ((o1 > 0 && o2 < 0) || (o1 < 0 && o2 > 0)) &&
+     ((o3 > 0 && o4 < 0) || (o3 < 0 && o4 > 0)) {
+    return true
+  }
+  return (o1 == 0 && onSegment(a, b, c)) || (o2 == 0 && onSegment(a, b, d)) ||
+    (o3 == 0 && onSegment(c, d, a)) || (o4 == 0 && onSegment(c, d, b))
+}
+
+// PolySelfIntersects returns true if two edges of the polygon that are not
+// neighbours touch or cross, so it is not a simple polygon.
+func PolySelfIntersects(verts []Vect) (bool) {
+  n := len(verts)
+  for i:=0; i < n; i++ {
+    a, b := verts[i], verts[(i + 1) % n]
+    // The edges next to edge i share a vertex with it.
+    for j:=i + 2; j < n; j++ {
+      if i == 0 && j == n - 1 { continue }
+      if segmentsIntersect(a, b, verts[j], verts[(j + 1) % n]) { return true }
+    }
+  }
+  return false
+}
+
+// Splits the convex, counter-clockwise polygon into pieces of at most
+// maxVerts vertexes, by cutting off fans from its first vertex.
+func limitVerts(poly []Vect, maxVerts int) ([][]Vect) {
+  pieces := make([][]Vect, 0, 1)
+  for maxVerts >= 3 && len(poly) > maxVerts {
+    piece := make([]Vect, maxVerts)
+    copy(piece, poly[0:maxVerts])
+    pieces = append(pieces, piece)
+    rest  := make([]Vect, 0, len(poly) - maxVerts + 2)
+    rest   = append(rest, poly[0])
+    poly   = append(rest, poly[maxVerts - 1:]...)
+  }
+  return append(pieces, poly)
+}
+
+// Returns the point where the lines through a1 and a2, and through b1 and
+// b2, meet. Returns a1 if they are parallel.
+func lineIntersection(a1, a2, b1, b2 Vect) (Vect) {
+  da, db := a2.Sub(a1), b2.Sub(b1)
+  denom  := da.Cross(db)
+  if denom == 0 { return a1 }
+  return a1.Add(da.Mult(b1.Sub(a1).Cross(db) / denom))
+}
+
+// Returns the vertexes from index i to index j of the polygon, both
+// included, going forward and wrapping around.
+func polyRange(poly []Vect, i, j int) ([]Vect) {
+  n := len(poly)
+  if j < i { j += n }
+  part := make([]Vect, 0, j - i + 2)
+  for k := i; k <= j; k++ {
+    part = append(part, poly[k % n])
+  }
+  return part
+}
+
+// Mark Bayazit's decomposition of a counter-clockwise polygon. Every
+// reflex vertex is joined to the best vertex it can see, or to a new point
+// if it sees none, and both halves are decomposed again.
+func bayazit(poly []Vect, depth int, pieces [][]Vect) ([][]Vect, os.Error) {
+  n := len(poly)
+  if n < 3 { return pieces, nil }
+  if depth > 10 * n + 100 {
+    return nil, os.NewError("polygon can't be decomposed")
+  }
+  at := func(i int) (Vect) { return poly[((i % n) + n) % n] }
+  left := func(a, b, c Vect) (bool) { return orientation(a, b, c) > 0 }
+  leftOn := func(a, b, c Vect) (bool) { return orientation(a, b, c) >= 0 }
+  right := func(a, b, c Vect) (bool) { return orientation(a, b, c) < 0 }
+  rightOn := func(a, b, c Vect) (bool) { return orientation(a, b, c) <= 0 }
+
+  for i:=0; i < n; i++ {
+    if !right(at(i - 1), at(i), at(i + 1)) { continue }
+    // The vertex is reflex. Extend its edges until they hit the polygon.
+    upperDist, lowerDist := INFINITY, INFINITY
+    var upperInt, lowerInt Vect
+    upperIndex, lowerIndex := 0, 0
+    for j:=0; j < n; j++ {
+      if left(at(i - 1), at(i), at(j)) && rightOn(at(i - 1), at(i), at(j - 1)) {
+        p := lineIntersection(at(i - 1), at(i), at(j), at(j - 1))
+        if right(at(i + 1), at(i), p) {
+          if d := at(i).Distsq(p); d < lowerDist {
+            lowerDist, lowerInt, lowerIndex = d, p, j
+          }
+        }
+      }
+      if left(at(i + 1), at(i), at(j + 1)) && rightOn(at(i + 1), at(i), at(j)) {
+        p := lineIntersection(at(i + 1), at(i), at(j), at(j + 1))
+        if left(at(i - 1), at(i), p) {
+          if d := at(i).Distsq(p); d < upperDist {
+            upperDist, upperInt, upperIndex = d, p, j
+          }
+        }
+      }
+    }
+
+    var lower, upper []Vect
+    if lowerIndex == (upperIndex + 1) % n {
+      // No vertex is in reach, so cut to the middle of the hits.
+      p    := lowerInt.Add(upperInt).Mult(0.5)
+      lower = append(polyRange(poly, i, upperIndex), p)
+      upper = append([]Vect{p}, polyRange(poly, lowerIndex, i)...)
+    } else {
+      // Cut to the closest vertex in reach.
+      if lowerIndex > upperIndex { upperIndex += n }
+      closestDist  := INFINITY
+      closestIndex := -1
+      for j := lowerIndex; j <= upperIndex; j++ {
+        if leftOn(at(i - 1), at(i), at(j)) && rightOn(at(i + 1), at(i), at(j)) {
+          if d := at(i).Distsq(at(j)); d < closestDist && j % n != i {
+            closestDist, closestIndex = d, j % n
+          }
+        }
+      }
+      if closestIndex < 0 {
+        return nil, os.NewError("polygon can't be decomposed")
+      }
+      lower = polyRange(poly, i, closestIndex)
+      upper = polyRange(poly, closestIndex, i)
+    }
+    if len(lower) >= n || len(upper) >= n {
+      return nil, os.NewError("polygon can't be decomposed")
+    }
+
+    var err os.Error
+    if len(lower) > len(upper) { lower, upper = upper, lower }
+    pieces, err = bayazit(lower, depth + 1, pieces)
+    if err != nil { return nil, err }
+    return bayazit(upper, depth + 1, pieces)
+  }
+  return append(pieces, poly), nil
+}
+
+// ConvexDecompose splits a simple polygon into convex polygons with at
+// most maxVerts vertexes each, with a clockwise winding so they pass
+// PolyShapeValidate. A maxVerts of 0 means no limit, and it can't be 1 or
+// 2. The polygon may have either winding. A convex polygon under the limit
+// is returned as the only piece. It uses Mark Bayazit's decomposition,
+// which may add vertexes where it cuts, but makes few pieces. Returns
+// ErrSelfIntersecting if edges of the polygon cross.
+func ConvexDecompose(verts []Vect, maxVerts int) ([][]Vect, os.Error) {
+  Assert(maxVerts == 0 || maxVerts >= 3, 
+    "The vertex limit of a polygon must be 0, or at least 3.")
+  if len(verts) < 3 {
+    return nil, os.NewError("polygon has less than 3 vertexes")
+  }
+  ccw, err := counterClockwise(verts)
+  if err != nil { return nil, err }
+
+  pieces, err := bayazit(ccw, 0, nil)
+  if err != nil { return nil, err }
+  result := make([][]Vect, 0, len(pieces))
+  for _, piece := range pieces {
+    for _, limited := range limitVerts(piece, maxVerts) {
+      if AreaForPoly(limited) <= 0 { continue }
+      ReverseVerts(limited)
+      result = append(result, limited)
+    }
+  }
+  return result, nil
+}
+
+// ConcavePolyShapesNew makes polygon shapes on the body for the convex
+// pieces of a simple polygon of any winding, with at most maxVerts
+// vertexes each, or any number if it is 0. The shapes still have to be
+// added to a space.
+func ConcavePolyShapesNew(body *Body, verts []Vect, maxVerts int,
+  offset Vect) ([]*PolyShape, os.Error) {
+  pieces, err := ConvexDecompose(verts, maxVerts)
+  if err != nil { return nil, err }
+  shapes := make([]*PolyShape, len(pieces))
+  for i, piece := range pieces {
+    shapes[i] = PolyShapeNew(body, piece, offset)
+  }
+  return shapes, nil
+}
@@ -0,0 +1,150 @@
+package tamias
+
+// Support for the deterministic mode of the space, and state hashing
+// to detect desyncs between two simulations that should be identical.
+
+import "math"
+import "sort"
+
+// Sorts arbiters on the ids of their shapes.
+type arbiterOrder []ArrayElement
+
+func (order arbiterOrder) Len() (int) {
+  return len(order)
+}
+
+func (order arbiterOrder) Less(i, j int) (bool) {
+  a := order[i].(*Arbiter)
+  b := order[j].(*Arbiter)
+  if a.private_a.hashid != b.private_a.hashid {
+    return a.private_a.hashid < b.private_a.hashid
+  }
+  return a.private_b.hashid < b.private_b.hashid
+}
+
+func (order arbiterOrder) Swap(i, j int) {
+  order[i], order[j] = order[j], order[i]
+}
+
+// Sorts the active arbiters of the space in a stable order.
+func (space *Space) sortArbiters() {
+  arbiters := space.arbiters
+  sort.Sort(arbiterOrder(arbiters.arr[0:arbiters.num]))
+}
+
+// Sorts pair keys in ascending order.
+type pairKeyOrder []pairKey
+
+func (order pairKeyOrder) Len() (int) {
+  return len(order)
+}
+
+func (order pairKeyOrder) Less(i, j int) (bool) {
+  if order[i].a != order[j].a { return order[i].a < order[j].a }
+  return order[i].b < order[j].b
+}
+
+func (order pairKeyOrder) Swap(i, j int) {
+  order[i], order[j] = order[j], order[i]
+}
+
+// Returns the keys of the contact set. They are sorted if the space is
+// deterministic, since the iteration order of a map is unspecified.
+func (space *Space) contactSetKeys() ([]pairKey) {
+  keys := make([]pairKey, len(space.contactSet))
+  i    := 0
+  for key, _ := range space.contactSet {
+    keys[i] = key
+    i++
+  }
+  if space.Deterministic {
+    sort.Sort(pairKeyOrder(keys))
+  }
+  return keys
+}
+
+// Returns the keys of the contact set, always sorted.
+func (space *Space) sortedContactSetKeys() ([]pairKey) {
+  keys := space.contactSetKeys()
+  if !space.Deterministic {
+    sort.Sort(pairKeyOrder(keys))
+  }
+  return keys
+}
+
+// FNV-1a, 64 bits.
+const (
+  stateHashOffset = uint64(14695981039346656037)
+  stateHashPrime  = uint64(1099511628211)
+)
+
+type stateHasher struct {
+  sum uint64
+}
+
+func (hasher *stateHasher) Word(w uint64) {
+  for i:=0; i < 8; i++ {
+    hasher.sum ^= w & 0xff
+    hasher.sum *= stateHashPrime
+    w >>= 8
+  }
+}
+
+func (hasher *stateHasher) Int(i int) {
+  hasher.Word(uint64(i))
+}
+
+// Hashes the bits of the float, so -0.0 and 0.0 hash differently.
+func (hasher *stateHasher) Float(f Float) {
+  hasher.Word(math.Float64bits(f.Float64()))
+}
+
+func (hasher *stateHasher) Vect(v Vect) {
+  hasher.Float(v.X)
+  hasher.Float(v.Y)
+}
+
+// StateHash returns a hash of the state of all bodies in the space, of
+// the cached impulses of the arbiters and of the accumulated impulses of
+// the constraints. Two spaces that are simulated identically have the same
+// state hash, so comparing the hashes of the players every frame detects a
+// desync in lockstep networking.
+func (space *Space) StateHash() (uint64) {
+  hasher := &stateHasher{stateHashOffset}
+  hasher.Int(space.stamp)
+
+  for i:=0; i < space.bodies.Size(); i++ {
+    body := space.bodies.Index(i).(*Body)
+    hasher.Vect(body.p)
+    hasher.Vect(body.v)
+    hasher.Vect(body.f)
+    hasher.Float(body.a)
+    hasher.Float(body.w)
+    hasher.Float(body.t)
+  }
+
+  // Always sorted here, or the hash would depend on the map order.
+  for _, key := range space.sortedContactSetKeys() {
+    arb := space.contactSet[key]
+    hasher.Word(uint64(arb.private_a.hashid))
+    hasher.Word(uint64(arb.private_b.hashid))
+    for i:=0; i < arb.numContacts; i++ {
+      con := &arb.contacts[i]
+      hasher.Vect(con.P)
+      hasher.Vect(con.N)
+      hasher.Float(con.Dist)
+      hasher.Float(con.jnAcc)
+      hasher.Float(con.jtAcc)
+    }
+  }
+
+  state := make([]Float, 0, space.constraints.Size())
+  for i:=0; i < space.constraints.Size(); i++ {
+    state = saveConstraintState(space.constraints.Index(i).(Constraint),
+      state)
+  }
+  for _, f := range state {
+    hasher.Float(f)
+  }
+  return hasher.sum
+}
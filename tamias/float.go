@@ -5,7 +5,9 @@ package tamias
 import "math"
 import "fmt"
 
-type Float float32;
+// The Float type itself is defined in float32.go or float64.go, 
+// depending on FLOATSIZE in the Makefile. It is always a floating point
+// type, see the Makefile about fixed point.
 
 func F64Float(in float64) (Float) {
   return Float(in)
@@ -36,6 +38,10 @@ func (self Float) Floor() (Float) {
   return Float(math.Floor(self.Float64()))
 }
 
+func (self Float) Pow(exp Float) (Float) {
+  return Float(math.Pow(self.Float64(), exp.Float64()))
+}
+
 
 func (a Float) Max(b Float) (Float) { 
   if a > b { 
@@ -109,6 +115,8 @@ func (a Float) VectForAngle() (Vect) {
 
 var INFINITY = Float(math.Inf(1))
 
+const PI = Float(math.Pi)
+
 func (self Float) String() (string) {
   return fmt.Sprintf("%f", self)
 }
@@ -0,0 +1,8 @@
+package tamias
+
+// Float is a 32 bits floating point number. Selected in the Makefile with
+// FLOATSIZE=32, which is the default.
+type Float float32;
+
+// Number of bits of Float.
+const FLOAT_BITS = 32
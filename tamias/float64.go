@@ -0,0 +1,8 @@
+package tamias
+
+// Float is a 64 bits floating point number. Selected in the Makefile with
+// FLOATSIZE=64.
+type Float float64;
+
+// Number of bits of Float.
+const FLOAT_BITS = 64
@@ -4,6 +4,9 @@ package tamias
 // Other than the transformation functions, there is nothing fancy going on.
 type HashValue int64
 
+// Coefficient used by HASH_PAIR.
+const HASH_COEF = HashValue(3344921057)
+
 type HashElement interface {
   Equals(interface {}) (bool)
   /*
@@ -95,7 +98,7 @@ func (set * HashSet) Init(size int) (* HashSet) {
   set.size          = next_prime(size)
   set.entries       = 0
   set.default_value = nil
-  set.table         = make([]*HashSetBin, 0, set.size)
+  set.table         = make([]*HashSetBin, set.size)
   set.pooledbins    = nil
   return set
   // set.buffers       = nil
@@ -114,7 +117,7 @@ func (set * HashSet) Resize() {
   // Get the next approximate doubled prime.
   newsize := next_prime(set.size + 1)
   // Allocate a new table.
-  newtable := make([]*HashSetBin, set.size, newsize) 
+  newtable := make([]*HashSetBin, newsize)
   // Iterate over the chains.
   for  i:=0 ; i < set.size ; i++ {
     // Rehash the bins into the new table.
@@ -123,7 +126,7 @@ func (set * HashSet) Resize() {
     var idx int    
     for bin != nil {
       next          = bin.next      
-      idx           = int(bin.hash) % newsize;
+      idx           = int(uint64(bin.hash) % uint64(newsize));
       bin.next      = newtable[idx]
       newtable[idx] = bin; 
       bin           = next;
@@ -157,7 +160,8 @@ func (set * HashSet) getUnusedBin() (* HashSetBin) {
 }
 
 func (set *HashSet) hashIndex(hash HashValue) (HashValue) {
-  return hash % HashValue(set.size)
+  // Hash pairs may be negative, take the modulo unsigned.
+  return HashValue(uint64(hash) % uint64(set.size))
 }
 
 // Find the correct has bin for this element, or nil if not found
@@ -223,7 +227,9 @@ func (set * HashSet) Remove(hash HashValue, ptr HashElement) (HashElement) {
     // Update the previous bin next pointer to point to the next bin.
     if prev != nil { 
       prev.next = bin.next
-    }  
+    } else {
+      set.table[set.hashIndex(hash)] = bin.next
+    }
     set.entries--    
     return_value := bin.elt
     set.recycleBin(bin)
@@ -0,0 +1,188 @@
+package tamias
+
+// Heightfields: terrain with heights at regular X intervals, as one shape
+// in stead of a segment for every column.
+
+var HeightfieldShapeClass = &ShapeClass{ HEIGHTFIELD_SHAPE }
+
+// Heightfield shape structure. The surface runs through the points
+// (offset.X + i * spacing, offset.Y + heights[i]) in body space, and the
+// terrain is solid from the surface down to its lowest height. Shapes only
+// collide with the top of the surface, one column at a time, and slide from
+// column to column without catching on the heights between them.
+type HeightfieldShape struct {
+  * Shape
+  // Position of the first height, and the X distance between the heights.
+  // (body space coordinates)
+  offset Vect
+  spacing Float
+  heights []Float
+  // Lowest and highest height.
+  min, max Float
+  // Segment that the columns are collided as, one at a time.
+  column SegmentShape
+}
+
+func HeightfieldShapeAlloc() (*HeightfieldShape) {
+  return &HeightfieldShape{}
+}
+
+func (field *HeightfieldShape) Init(body *Body, offset Vect, spacing Float,
+  heights []Float) (*HeightfieldShape) {
+  Assert(spacing > 0, "The heights of a heightfield need a spacing.")
+  Assert(len(heights) >= 2, "A heightfield needs at least 2 heights.")
+  field.offset  = offset
+  field.spacing = spacing
+  field.heights = make([]Float, len(heights))
+  copy(field.heights, heights)
+  field.Shape          = ShapeNew(HeightfieldShapeClass, body)
+  field.Shape.geometry = field
+  field.column.Shape    = field.Shape
+  field.column.oneSided = true
+  field.bounds()
+  return field
+}
+
+// HeightfieldShapeNew makes a heightfield with the heights, spacing apart
+// on the X axis, starting at offset. The heights are copied.
+func HeightfieldShapeNew(body *Body, offset Vect, spacing Float,
+  heights []Float) (*HeightfieldShape) {
+  return HeightfieldShapeAlloc().Init(body, offset, spacing, heights)
+}
+
+// Finds the lowest and the highest height.
+func (field *HeightfieldShape) bounds() {
+  field.min, field.max = field.heights[0], field.heights[0]
+  for _, h := range field.heights {
+    field.min = field.min.Min(h)
+    field.max = field.max.Max(h)
+  }
+}
+
+// Returns the point of the surface at height i. (body space coordinates)
+func (field *HeightfieldShape) point(i int) (Vect) {
+  return field.offset.Add(V(Float(i) * field.spacing, field.heights[i]))
+}
+
+func (field *HeightfieldShape) CacheBB(p, rot Vect) (BB) {
+  width := Float(len(field.heights) - 1) * field.spacing
+  l, r  := INFINITY, -INFINITY
+  b, t  := INFINITY, -INFINITY
+  for _, corner := range []Vect{V(0, field.min), V(width, field.min),
+    V(0, field.max), V(width, field.max)} {
+    v := p.Add(field.offset.Add(corner).Rotate(rot))
+    l, r = l.Min(v.X), r.Max(v.X)
+    b, t = b.Min(v.Y), t.Max(v.Y)
+  }
+  return BBMake(l, t, r, b)
+}
+
+// Returns the first and the last column that the bounding box overlaps.
+// The last is before the first if it overlaps none.
+func (field *HeightfieldShape) columns(bb BB) (first, last int) {
+  body   := field.Body
+  lo, hi := INFINITY, -INFINITY
+  for _, corner := range []Vect{V(bb.L, bb.T), V(bb.L, bb.B), V(bb.R, bb.T),
+    V(bb.R, bb.B)} {
+    x := corner.Sub(body.p).Unrotate(body.rot).X - field.offset.X
+    lo, hi = lo.Min(x), hi.Max(x)
+  }
+  n := len(field.heights) - 1
+  if hi < 0 || lo > Float(n) * field.spacing { return 0, -1 }
+  first = imax(int((lo / field.spacing).Floor()), 0)
+  last  = imin(int((hi / field.spacing).Floor()), n - 1)
+  return first, last
+}
+
+// Sets up the segment of column i, between height i and i + 1, with the
+// columns next to it as its neighbours.
+func (field *HeightfieldShape) setColumn(i int) (*SegmentShape) {
+  seg := &field.column
+  seg.a, seg.b = field.point(i), field.point(i + 1)
+  seg.n = seg.b.Sub(seg.a).Normalize().Perp()
+  seg.aTangent, seg.bTangent = VZERO, VZERO
+  if i > 0 {
+    seg.aTangent = field.point(i - 1).Sub(seg.a)
+  }
+  if i + 2 < len(field.heights) {
+    seg.bTangent = field.point(i + 2).Sub(seg.b)
+  }
+  seg.CacheBB(field.Body.p, field.Body.rot)
+  return seg
+}
+
+// PointQuery returns true if p is under the surface, and not under the
+// lowest height.
+func (field *HeightfieldShape) PointQuery(p Vect) (bool) {
+  body := field.Body
+  v    := p.Sub(body.p).Unrotate(body.rot).Sub(field.offset)
+  n    := len(field.heights) - 1
+  if v.X < 0 || v.X > Float(n) * field.spacing || v.Y < field.min {
+    return false
+  }
+  i := imin(int((v.X / field.spacing).Floor()), n - 1)
+  t := v.X / field.spacing - Float(i)
+  return v.Y <= field.heights[i] + (field.heights[i + 1] - field.heights[i]) * t
+}
+
+// SegmentQuery finds where the segment from a to b first crosses the
+// surface, in world coordinates. The normal points to the side of a.
+func (field *HeightfieldShape) SegmentQuery(a, b Vect) (
+  info *SegmentQueryInfo) {
+  info  = &SegmentQueryInfo{}
+  d    := b.Sub(a)
+  first, last := field.columns(BBMake(a.X.Min(b.X), a.Y.Max(b.Y),
+    a.X.Max(b.X), a.Y.Min(b.Y)))
+  for i := first; i <= last; i++ {
+    seg   := field.setColumn(i)
+    e     := seg.tb.Sub(seg.ta)
+    denom := d.Cross(e)
+    if denom == 0.0 { continue }
+    t := seg.ta.Sub(a).Cross(e) / denom
+    u := seg.ta.Sub(a).Cross(d) / denom
+    if t < 0.0 || t > 1.0 || u < 0.0 || u > 1.0 { continue }
+    if info.Hit() && t >= info.t { continue }
+    info.shape, info.t, info.n = field.Shape, t, seg.tn
+    if d.Dot(seg.tn) > 0.0 { info.n = seg.tn.Neg() }
+  }
+  return info
+}
+
+// Recaches the bounds and the bounding box after the heights changed.
+func (field *HeightfieldShape) update() {
+  field.bounds()
+  bb      := field.CacheBB(field.Body.p, field.Body.rot)
+  field.BB = &bb
+}
+
+// SetHeight changes height i in place, for terrain that deforms. The
+// bounding box is updated, but a static heightfield in a space also has to
+// be rehashed with Space.RehashStaticShape.
+func (field *HeightfieldShape) SetHeight(i int, height Float) {
+  field.heights[i] = height
+  field.update()
+}
+
+// SetHeights changes the heights from start on in place, like SetHeight.
+func (field *HeightfieldShape) SetHeights(start int, heights []Float) {
+  Assert(start >= 0 && start + len(heights) <= len(field.heights),
+    "The heights don't fit in the heightfield.")
+  copy(field.heights[start:], heights)
+  field.update()
+}
+
+func (field *HeightfieldShape) Height(i int) (Float) {
+  return field.heights[i]
+}
+
+func (field *HeightfieldShape) NumHeights() (int) {
+  return len(field.heights)
+}
+
+func (field *HeightfieldShape) Spacing() (Float) {
+  return field.spacing
+}
+
+func (field *HeightfieldShape) Offset() (Vect) {
+  return field.offset
+}
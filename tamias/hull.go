@@ -0,0 +1,81 @@
+package tamias
+
+// Convex hulls of point clouds, to build valid polygons from any points.
+
+import "sort"
+
+// Sorts points by X, and then by Y.
+type hullOrder []Vect
+
+func (points hullOrder) Len() (int) { return len(points) }
+
+func (points hullOrder) Less(i, j int) (bool) {
+  if points[i].X != points[j].X { return points[i].X < points[j].X }
+  return points[i].Y < points[j].Y
+}
+
+func (points hullOrder) Swap(i, j int) {
+  points[i], points[j] = points[j], points[i]
+}
+
+// Adds p to the chain, after dropping the points that don't make a left
+// turn towards it.
+func hullPush(chain []Vect, min int, p Vect) ([]Vect) {
+  for len(chain) >= min + 2 {
+    a, b := chain[len(chain) - 2], chain[len(chain) - 1]
+    if b.Sub(a).Cross(p.Sub(b)) > 0 { break }
+    chain = chain[0:len(chain) - 1]
+  }
+  return append(chain, p)
+}
+
+// ConvexHull returns the convex hull of the points, with the winding that
+// PolyShapeValidate accepts. That is clockwise with the Y axis pointing up,
+// or counter-clockwise on a screen where it points down. Duplicate and
+// collinear points are left out, and so are the vertexes that are less
+// than tolerance away from the line between their neighbours, so nearly
+// collinear points merge. The points are not changed.
+// Returns less than 3 vertexes if the points have no area.
+func ConvexHull(points []Vect, tolerance Float) ([]Vect) {
+  sorted := make([]Vect, len(points))
+  copy(sorted, points)
+  sort.Sort(hullOrder(sorted))
+  if len(sorted) < 3 { return sorted }
+
+  // Andrew's monotone chain, which gives a counter-clockwise hull.
+  hull := make([]Vect, 0, 2 * len(sorted))
+  for _, p := range sorted {
+    hull = hullPush(hull, 0, p)
+  }
+  lower := len(hull) - 1
+  for i := len(sorted) - 2; i >= 0; i-- {
+    hull = hullPush(hull, lower, sorted[i])
+  }
+  // The last point is the first one again.
+  hull = hull[0:len(hull) - 1]
+
+  for removed := tolerance > 0; removed && len(hull) > 3; {
+    removed = false
+    for i:=0; i < len(hull) && len(hull) > 3; i++ {
+      n       := len(hull)
+      a, b, c := hull[(i + n - 1) % n], hull[i], hull[(i + 1) % n]
+      if c.Sub(a).Cross(b.Sub(a)).Abs() <= tolerance * c.Dist(a) {
+        hull    = append(hull[0:i], hull[i + 1:]...)
+        removed = true
+        i--
+      }
+    }
+  }
+
+  ReverseVerts(hull)
+  return hull
+}
+
+// PolyShapeNewFromPoints makes a polygon shape of the convex hull of any
+// points, which need no order or winding.
+func PolyShapeNewFromPoints(body *Body, points []Vect, offset Vect) (
+  *PolyShape) {
+  hull := ConvexHull(points, 0.0)
+  Assert(len(hull) >= 3, "The points of a polygon need an area.")
+  return PolyShapeNew(body, hull, offset)
+}
@@ -0,0 +1,474 @@
+package tamias
+
+// Collision geometry from bitmaps and density functions, with marching
+// squares, for destructible terrain.
+//
+// The field is sampled on a grid of cells over a BB. Samples at or above
+// the threshold are solid. Outlines go around the solid parts with the
+// solid on their left, so closed outlines around solid parts are
+// counter-clockwise, and holes are clockwise.
+
+import "image"
+
+// SampleFunc returns the density of the field at a point.
+type SampleFunc func(point Vect) (Float)
+
+// ImageSampler returns the alpha of the image, from 0 to 1, stretched over
+// the BB, with the top of the image at the top of the BB. Pixels are
+// interpolated, and the outside of the image is empty.
+func ImageSampler(img image.Image, bb BB) (SampleFunc) {
+  bounds := img.Bounds()
+  alpha  := func(x, y int) (Float) {
+    p := image.Point{bounds.Min.X + x, bounds.Min.Y + y}
+    if !p.In(bounds) { return 0.0 }
+    _, _, _, a := img.At(p.X, p.Y).RGBA()
+    return Float(a) / 0xffff
+  }
+  return func(point Vect) (Float) {
+    // The centers of the pixels are at half units.
+    x  := (point.X - bb.L) / (bb.R - bb.L) * Float(bounds.Dx()) - 0.5
+    y  := (bb.T - point.Y) / (bb.T - bb.B) * Float(bounds.Dy()) - 0.5
+    fx := x - x.Floor()
+    fy := y - y.Floor()
+    ix := int(x.Floor())
+    iy := int(y.Floor())
+    top    := alpha(ix, iy) * (1 - fx) + alpha(ix + 1, iy) * fx
+    bottom := alpha(ix, iy + 1) * (1 - fx) + alpha(ix + 1, iy + 1) * fx
+    return top * (1 - fy) + bottom * fy
+  }
+}
+
+// The grid of samples of a field.
+type marchGrid struct {
+  bb BB
+  cellsX, cellsY int
+  cell Vect
+  threshold Float
+  sample SampleFunc
+}
+
+func marchGridMake(bb BB, cellsX, cellsY int, threshold Float,
+  sample SampleFunc) (marchGrid) {
+  Assert(cellsX > 0 && cellsY > 0, "A grid needs at least one cell.")
+  cell := V((bb.R - bb.L) / Float(cellsX), (bb.T - bb.B) / Float(cellsY))
+  return marchGrid{bb, cellsX, cellsY, cell, threshold, sample}
+}
+
+// Returns the point of sample i, j. Neighbouring regions compute the same
+// points, so their outlines meet exactly.
+func (grid *marchGrid) point(i, j int) (Vect) {
+  return V(grid.bb.L + Float(i) * grid.cell.X,
+    grid.bb.B + Float(j) * grid.cell.Y)
+}
+
+// Returns the rectangle of a region of cells.
+func (grid *marchGrid) rect(x0, y0, x1, y1 int) (BB) {
+  min, max := grid.point(x0, y0), grid.point(x1, y1)
+  return BBMake(min.X, max.Y, max.X, min.Y)
+}
+
+// The crossings of a region, with the samples around its cells.
+type marchRegion struct {
+  grid *marchGrid
+  x0, y0, x1, y1 int
+  values []Float
+  // The crossing that follows every crossing, by edge key, and where
+  // they are.
+  next map[int] int
+  points map[int] Vect
+  // Keys of the crossings that start segments, in the order of the cells.
+  starts []int
+}
+
+func (region *marchRegion) value(i, j int) (Float) {
+  width := region.x1 - region.x0 + 1
+  return region.values[(j - region.y0) * width + i - region.x0]
+}
+
+func (region *marchRegion) solid(i, j int) (bool) {
+  return region.value(i, j) >= region.grid.threshold
+}
+
+// Returns the key of the edge from sample i, j to the right, or up if
+// vertical is true.
+func (region *marchRegion) edgeKey(i, j int, vertical bool) (int) {
+  key := (j * (region.grid.cellsX + 1) + i) * 2
+  if vertical { key++ }
+  return key
+}
+
+// Returns the key of the crossing on an edge, and remembers where it is.
+func (region *marchRegion) crossing(i, j int, vertical bool) (int) {
+  key := region.edgeKey(i, j, vertical)
+  if _, ok := region.points[key]; ok { return key }
+  i2, j2 := i + 1, j
+  if vertical { i2, j2 = i, j + 1 }
+  va, vb := region.value(i, j), region.value(i2, j2)
+  t      := ((region.grid.threshold - va) / (vb - va)).Max(0.0).Min(1.0)
+  a, b   := region.grid.point(i, j), region.grid.point(i2, j2)
+  region.points[key] = a.Lerp(b, t)
+  return key
+}
+
+// Adds the segments of cell i, j.
+func (region *marchRegion) cell(i, j int) {
+  corners := [4][2]int{[2]int{i, j}, [2]int{i + 1, j},
+    [2]int{i + 1, j + 1}, [2]int{i, j + 1}}
+  // The edges of the cell, counter-clockwise from the bottom, as the
+  // sample they start at and whether they are vertical.
+  edges := [4][3]int{[3]int{i, j, 0}, [3]int{i + 1, j, 1},
+    [3]int{i, j + 1, 0}, [3]int{i, j, 1}}
+  keys     := make([]int, 0, 4)
+  leaving  := make([]bool, 0, 4)
+  sum      := Float(0.0)
+  for k:=0; k < 4; k++ {
+    c, d := corners[k], corners[(k + 1) % 4]
+    sum  += region.value(c[0], c[1])
+    a, b := region.solid(c[0], c[1]), region.solid(d[0], d[1])
+    if a == b { continue }
+    edge    := edges[k]
+    keys     = append(keys, region.crossing(edge[0], edge[1], edge[2] == 1))
+    leaving  = append(leaving, a)
+  }
+  // Segments go from where the outline leaves the solid to where it
+  // enters it, going counter-clockwise around the cell. In a saddle,
+  // the center decides whether the solid corners are joined.
+  joined := sum / 4.0 >= region.grid.threshold
+  n      := len(keys)
+  for k:=0; k < n; k++ {
+    if !leaving[k] { continue }
+    to := (k + 1) % n
+    if n == 4 && !joined { to = (k + n - 1) % n }
+    region.next[keys[k]] = keys[to]
+    region.starts = append(region.starts, keys[k])
+  }
+}
+
+// Samples a region of cells and finds its crossings.
+func (grid *marchGrid) march(x0, y0, x1, y1 int) (*marchRegion) {
+  width  := x1 - x0 + 1
+  values := make([]Float, width * (y1 - y0 + 1))
+  for j := y0; j <= y1; j++ {
+    for i := x0; i <= x1; i++ {
+      values[(j - y0) * width + i - x0] = grid.sample(grid.point(i, j))
+    }
+  }
+  region := &marchRegion{grid, x0, y0, x1, y1, values, make(map[int] int),
+    make(map[int] Vect), make([]int, 0)}
+  for j := y0; j < y1; j++ {
+    for i := x0; i < x1; i++ {
+      region.cell(i, j)
+    }
+  }
+  return region
+}
+
+// Returns the outlines of the region. Open outlines start and end on the
+// border of the region. Closed outlines are returned with their first
+// point again at the end.
+func (region *marchRegion) outlines() (open, closed [][]Vect) {
+  targets := make(map[int] bool)
+  for _, to := range region.next {
+    targets[to] = true
+  }
+  done  := make(map[int] bool)
+  chain := func(start int) ([]Vect) {
+    points := []Vect{region.points[start]}
+    done[start] = true
+    for key := start; ; {
+      to, ok := region.next[key]
+      if !ok { break }
+      points = append(points, region.points[to])
+      if done[to] { break }
+      done[to] = true
+      key      = to
+    }
+    return points
+  }
+  open, closed = make([][]Vect, 0), make([][]Vect, 0)
+  for _, start := range region.starts {
+    if !targets[start] { open = append(open, chain(start)) }
+  }
+  for _, start := range region.starts {
+    if !done[start] { closed = append(closed, chain(start)) }
+  }
+  return open, closed
+}
+
+// MarchSquares returns the outlines of the solid parts of a field over
+// the BB, sampled on a grid of cellsX by cellsY cells. Outlines that end
+// at the edge of the BB are open, and closed outlines have their first
+// point again at the end.
+func MarchSquares(bb BB, cellsX, cellsY int, threshold Float,
+  sample SampleFunc) ([][]Vect) {
+  grid := marchGridMake(bb, cellsX, cellsY, threshold, sample)
+  open, closed := grid.march(0, 0, cellsX, cellsY).outlines()
+  return append(open, closed...)
+}
+
+// Returns where a point on the border of the rectangle is, as the distance
+// along the border counter-clockwise from the bottom left corner.
+func perimeterPos(rect BB, p Vect) (Float) {
+  w, h := rect.R - rect.L, rect.T - rect.B
+  switch {
+    case p.Y <= rect.B: return p.X - rect.L
+    case p.X >= rect.R: return w + p.Y - rect.B
+    case p.Y >= rect.T: return w + h + rect.R - p.X
+  }
+  return 2 * w + h + rect.T - p.Y
+}
+
+// Closes the open outlines of a region along its border, counter-clockwise
+// around the solid parts. Each outline continues with the first outline
+// that starts after its end, going counter-clockwise along the border,
+// and passes the corners in between.
+func closeOutlines(rect BB, open [][]Vect) ([][]Vect) {
+  w, h      := rect.R - rect.L, rect.T - rect.B
+  perimeter := 2 * (w + h)
+  corners   := []Vect{V(rect.L, rect.B), V(rect.R, rect.B), V(rect.R, rect.T),
+    V(rect.L, rect.T)}
+  cornerPos := []Float{0, w, w + h, 2 * w + h}
+  // Returns how far to go counter-clockwise from a to b.
+  ahead := func(a, b Float) (Float) {
+    d := b - a
+    if d < 0 { d += perimeter }
+    return d
+  }
+
+  closed := make([][]Vect, 0)
+  used   := make([]bool, len(open))
+  for first := range open {
+    if used[first] { continue }
+    loop := make([]Vect, 0)
+    for k := first; !used[k]; {
+      used[k] = true
+      loop    = append(loop, open[k]...)
+      end    := perimeterPos(rect, open[k][len(open[k]) - 1])
+      best   := -1
+      bestD  := perimeter + 1
+      for m, other := range open {
+        if d := ahead(end, perimeterPos(rect, other[0])); d < bestD {
+          best, bestD = m, d
+        }
+      }
+      // Pass the corners on the way, in order.
+      for c:=1; c <= 4; c++ {
+        corner := (cornerIndex(cornerPos, end) + c) % 4
+        if d := ahead(end, cornerPos[corner]); d > 0 && d < bestD {
+          loop = append(loop, corners[corner])
+        }
+      }
+      k = best
+    }
+    closed = append(closed, loop)
+  }
+  return closed
+}
+
+// Returns the index of the last corner at or before a border position.
+func cornerIndex(cornerPos []Float, pos Float) (int) {
+  index := 0
+  for i, c := range cornerPos {
+    if c <= pos { index = i }
+  }
+  return index
+}
+
+// How MarchTerrain makes shapes.
+type MarchOptions struct {
+  // Samples at or above the threshold are solid.
+  Threshold Float
+  // The outlines are simplified with PolylineSimplify, with this
+  // tolerance.
+  Tolerance Float
+  // Makes convex polygons of the solid parts if true, or else chains of
+  // segments along the outlines.
+  Polys bool
+  // The most vertexes of a polygon, or 0 for any number.
+  MaxVerts int
+  // Radius of the segments.
+  Radius Float
+  // Called for every new shape, to set its friction and the like.
+  Setup func(shape *Shape)
+}
+
+var DefaultMarchOptions = MarchOptions{Threshold: 0.5}
+
+// MarchTerrain keeps the static shapes of a field in a space. The grid is
+// split in square chunks of cells, with their own shapes, so a change to
+// the field only regenerates the chunks it touches.
+type MarchTerrain struct {
+  space *Space
+  body *Body
+  grid marchGrid
+  options MarchOptions
+  chunkSize, chunksX, chunksY int
+  chunks [][]*Shape
+}
+
+func MarchTerrainAlloc() (*MarchTerrain) {
+  return &MarchTerrain{}
+}
+
+// Init samples the field over bb on a grid of cellsX by cellsY cells, in
+// chunks of chunkSize by chunkSize cells, and adds static shapes on the
+// body to the space. Uses the default options if options is nil.
+func (terrain *MarchTerrain) Init(space *Space, body *Body, bb BB,
+  cellsX, cellsY, chunkSize int, sample SampleFunc,
+  options *MarchOptions) (*MarchTerrain) {
+  Assert(chunkSize > 0, "Chunks need at least one cell.")
+  if options == nil { options = &DefaultMarchOptions }
+  terrain.space     = space
+  terrain.body      = body
+  terrain.options   = *options
+  terrain.grid      = marchGridMake(bb, cellsX, cellsY, options.Threshold,
+    sample)
+  terrain.chunkSize = chunkSize
+  terrain.chunksX   = (cellsX + chunkSize - 1) / chunkSize
+  terrain.chunksY   = (cellsY + chunkSize - 1) / chunkSize
+  terrain.chunks    = make([][]*Shape, terrain.chunksX * terrain.chunksY)
+  for i := range terrain.chunks {
+    terrain.generate(i)
+  }
+  return terrain
+}
+
+func MarchTerrainNew(space *Space, body *Body, bb BB,
+  cellsX, cellsY, chunkSize int, sample SampleFunc,
+  options *MarchOptions) (*MarchTerrain) {
+  return MarchTerrainAlloc().Init(space, body, bb, cellsX, cellsY, chunkSize,
+    sample, options)
+}
+
+func (terrain *MarchTerrain) add(shape *Shape) {
+  if terrain.options.Setup != nil { terrain.options.Setup(shape) }
+  terrain.space.AddStaticShape(shape)
+}
+
+// Adds segments along an outline.
+func (terrain *MarchTerrain) addChain(points []Vect) ([]*Shape) {
+  segments := SegmentShapesNew(terrain.body, points, terrain.options.Radius)
+  shapes   := make([]*Shape, len(segments))
+  for i, segment := range segments {
+    shapes[i] = segment.Shape
+    terrain.add(shapes[i])
+  }
+  return shapes
+}
+
+// Adds convex polygons for the solid parts of a region of cells. Regions
+// with holes are split until they have none, since polygons can't have
+// holes.
+func (terrain *MarchTerrain) addPolys(x0, y0, x1, y1 int) ([]*Shape) {
+  grid         := &terrain.grid
+  open, closed := grid.march(x0, y0, x1, y1).outlines()
+  outlines     := closeOutlines(grid.rect(x0, y0, x1, y1), open)
+  for _, loop := range closed {
+    if AreaForPoly(loop) >= 0 {
+      outlines = append(outlines, loop[0:len(loop) - 1])
+      continue
+    }
+    // A hole: split the region across its longer side.
+    if x1 - x0 >= y1 - y0 && x1 - x0 > 1 {
+      mid := (x0 + x1) / 2
+      return append(terrain.addPolys(x0, y0, mid, y1),
+        terrain.addPolys(mid, y0, x1, y1)...)
+    } else if y1 - y0 > 1 {
+      mid := (y0 + y1) / 2
+      return append(terrain.addPolys(x0, y0, x1, mid),
+        terrain.addPolys(x0, mid, x1, y1)...)
+    }
+  }
+  if len(open) == 0 && len(closed) == 0 && grid.sample(grid.point(x0, y0)) >=
+     grid.threshold {
+    // The whole region is solid.
+    rect    := grid.rect(x0, y0, x1, y1)
+    outlines = append(outlines, []Vect{V(rect.L, rect.B), V(rect.R, rect.B),
+      V(rect.R, rect.T), V(rect.L, rect.T)})
+  }
+
+  shapes := make([]*Shape, 0)
+  for _, outline := range outlines {
+    outline = PolylineSimplify(append(outline, outline[0]),
+      terrain.options.Tolerance)
+    pieces, err := ConvexDecompose(PolylineLoopVerts(outline),
+      terrain.options.MaxVerts)
+    if err != nil {
+      // Outlines that can't be split become segments.
+      shapes = append(shapes, terrain.addChain(outline)...)
+      continue
+    }
+    for _, piece := range pieces {
+      shape := PolyShapeNew(terrain.body, piece, VZERO).Shape
+      terrain.add(shape)
+      shapes = append(shapes, shape)
+    }
+  }
+  return shapes
+}
+
+// Replaces the shapes of a chunk.
+func (terrain *MarchTerrain) generate(chunk int) {
+  for _, shape := range terrain.chunks[chunk] {
+    terrain.space.RemoveStaticShape(shape)
+  }
+  size   := terrain.chunkSize
+  x0, y0 := (chunk % terrain.chunksX) * size, (chunk / terrain.chunksX) * size
+  x1, y1 := x0 + size, y0 + size
+  if x1 > terrain.grid.cellsX { x1 = terrain.grid.cellsX }
+  if y1 > terrain.grid.cellsY { y1 = terrain.grid.cellsY }
+
+  if terrain.options.Polys {
+    terrain.chunks[chunk] = terrain.addPolys(x0, y0, x1, y1)
+    return
+  }
+  shapes       := make([]*Shape, 0)
+  open, closed := terrain.grid.march(x0, y0, x1, y1).outlines()
+  for _, outline := range append(open, closed...) {
+    outline = PolylineSimplify(outline, terrain.options.Tolerance)
+    shapes  = append(shapes, terrain.addChain(outline)...)
+  }
+  terrain.chunks[chunk] = shapes
+}
+
+// Regenerate samples the field again in the dirty rectangle, and replaces
+// the shapes of the chunks that it touches.
+func (terrain *MarchTerrain) Regenerate(dirty BB) {
+  grid := &terrain.grid
+  // A changed sample changes the cells on both sides of it.
+  cellX := func(x Float) (int) {
+    return int(((x - grid.bb.L) / grid.cell.X).Floor())
+  }
+  cellY := func(y Float) (int) {
+    return int(((y - grid.bb.B) / grid.cell.Y).Floor())
+  }
+  size := terrain.chunkSize
+  cx0  := imax(cellX(dirty.L) - 1, 0) / size
+  cy0  := imax(cellY(dirty.B.Min(dirty.T)) - 1, 0) / size
+  cx1  := imin(cellX(dirty.R) + 1, grid.cellsX - 1) / size
+  cy1  := imin(cellY(dirty.B.Max(dirty.T)) + 1, grid.cellsY - 1) / size
+  for y := cy0; y <= cy1; y++ {
+    for x := cx0; x <= cx1; x++ {
+      terrain.generate(y * terrain.chunksX + x)
+    }
+  }
+}
+
+// Shapes returns all shapes of the terrain.
+func (terrain *MarchTerrain) Shapes() ([]*Shape) {
+  shapes := make([]*Shape, 0)
+  for _, chunk := range terrain.chunks {
+    shapes = append(shapes, chunk...)
+  }
+  return shapes
+}
+
+// Remove removes all shapes of the terrain from the space.
+func (terrain *MarchTerrain) Remove() {
+  for i, chunk := range terrain.chunks {
+    for _, shape := range chunk {
+      terrain.space.RemoveStaticShape(shape)
+    }
+    terrain.chunks[i] = nil
+  }
+}
@@ -0,0 +1,154 @@
+package tamias
+
+// Materials: surfaces and densities that many shapes share, and the rules
+// that mix the surfaces of two shapes that touch.
+
+// A Material holds the surface and the density of the shapes that use it.
+// Changes to it apply to all of them, from their next collision on.
+type Material struct {
+  // Coefficient of friction.
+  Friction Float
+  // Coefficient of restitution. (elasticity)
+  Elasticity Float
+  // Surface velocity used when solving for friction.
+  SurfaceV Vect
+  // Mass per area, for Body.SetMassFromShapes.
+  Density Float
+}
+
+func MaterialNew(friction, elasticity, density Float) (*Material) {
+  return &Material{Friction: friction, Elasticity: elasticity,
+    Density: density}
+}
+
+// Mixes the friction or the elasticity of two shapes into the one of
+// their collision.
+type MixFunc func(a, b Float) (Float)
+
+func MixMultiply(a, b Float) (Float) {
+  return a * b
+}
+
+func MixAverage(a, b Float) (Float) {
+  return (a + b) * 0.5
+}
+
+func MixMin(a, b Float) (Float) {
+  return a.Min(b)
+}
+
+func MixMax(a, b Float) (Float) {
+  return a.Max(b)
+}
+
+// SetMaterial makes the shape use the surface and the density of the
+// material in stead of its own. nil makes it use its own again.
+func (shape * Shape) SetMaterial(material * Material) {
+  shape.material = material
+}
+
+func (shape * Shape) Material() (* Material) {
+  return shape.material
+}
+
+func (shape * Shape) elasticity() (Float) {
+  if shape.material != nil { return shape.material.Elasticity }
+  return shape.e
+}
+
+func (shape * Shape) friction() (Float) {
+  if shape.material != nil { return shape.material.Friction }
+  return shape.u
+}
+
+func (shape * Shape) surfaceV() (Vect) {
+  if shape.material != nil { return shape.material.SurfaceV }
+  return shape.surface_v
+}
+
+// The material of a pair of collision types, with the types it was set
+// for, so that scenes can save it.
+type pairMaterial struct {
+  a, b CollisionType
+  material *Material
+}
+
+// SetPairMaterial makes shapes with the collision types a and b collide
+// with the friction and the elasticity of the material, in stead of the
+// mix of their own. Their surface velocities stay their own. nil removes
+// the material of the pair.
+func (space * Space) SetPairMaterial(a, b CollisionType,
+  material * Material) {
+  key := pairKeyNew(HashValue(a), HashValue(b))
+  if material == nil {
+    space.pairMaterials[key] = pairMaterial{}, false
+    return
+  }
+  space.pairMaterials[key] = pairMaterial{a, b, material}
+}
+
+// PairMaterial returns the material of the pair of collision types, or nil
+// if it has none.
+func (space * Space) PairMaterial(a, b CollisionType) (* Material) {
+  return space.pairMaterials[pairKeyNew(HashValue(a), HashValue(b))].material
+}
+
+// Sets the friction and the elasticity of a new collision, with the mixing
+// rules and the pair materials of the space. Arbiter.Update multiplies
+// them, which is what is left when the space has no rules.
+func (space * Space) mixSurfaces(arb * Arbiter, a, b * Shape) {
+  if pair, ok := space.pairMaterials[pairKeyNew(
+    HashValue(a.collision_type), HashValue(b.collision_type))]; ok {
+    arb.e = pair.material.Elasticity
+    arb.u = pair.material.Friction
+    return
+  }
+  if space.ElasticityMix != nil {
+    arb.e = space.ElasticityMix(a.elasticity(), b.elasticity())
+  }
+  if space.FrictionMix != nil {
+    arb.u = space.FrictionMix(a.friction(), b.friction())
+  }
+}
+
+// Returns the area of the shape, or 0 for shapes that have no mass.
+func shapeArea(shape * Shape) (Float) {
+  switch geometry := shape.geometry.(type) {
+    case *CircleShape:
+      return AreaForCircle(0.0, geometry.r)
+    case *SegmentShape:
+      return AreaForSegment(geometry.a, geometry.b, geometry.r)
+    case *PolyShape:
+      return AreaForRoundedPoly(geometry.verts, geometry.r)
+  }
+  return 0.0
+}
+
+// Returns the moment of the shape with mass m, around the body.
+func shapeMoment(shape * Shape, m Float) (Float) {
+  switch geometry := shape.geometry.(type) {
+    case *CircleShape:
+      return MomentForCircle(m, 0.0, geometry.r, geometry.c)
+    case *SegmentShape:
+      return MomentForSegment(m, geometry.a, geometry.b, geometry.r)
+    case *PolyShape:
+      return MomentForPoly(m, geometry.verts, VZERO, geometry.r)
+  }
+  return 0.0
+}
+
+// SetMassFromShapes sets the mass and the moment of the body from the
+// areas of its shapes in the space and the densities of their materials.
+// Shapes without a material add nothing.
+func (body * Body) SetMassFromShapes() {
+  m, i := Float(0.0), Float(0.0)
+  for _, shape := range body.shapes {
+    if shape.material == nil { continue }
+    mass := shape.material.Density * shapeArea(shape)
+    m    += mass
+    i    += shapeMoment(shape, mass)
+  }
+  Assert(m > 0, "The shapes of the body need a density and an area.")
+  body.SetMass(m)
+  body.SetMoment(i)
+}
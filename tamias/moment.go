@@ -0,0 +1,89 @@
+package tamias
+
+// Moments of inertia of shapes, for the moment of the body they are on.
+// Add the moments of all shapes of a body, each with its part of the mass.
+
+// MomentForCircle returns the moment of a hollow circle of mass m, with
+// an inner radius r1 and an outer radius r2, whose center is offset from
+// the center of gravity. A solid circle has an inner radius of 0.
+func MomentForCircle(m, r1, r2 Float, offset Vect) (Float) {
+  return m * (Float(0.5) * (r1 * r1 + r2 * r2) + offset.Lengthsq())
+}
+
+// MomentForSegment returns the moment of a segment of mass m from a to b,
+// with a radius, as a box as long as the rounded segment.
+func MomentForSegment(m Float, a, b Vect, radius Float) (Float) {
+  offset := a.Lerp(b, 0.5)
+  length := b.Dist(a) + 2.0 * radius
+  return m * ((length * length + 4.0 * radius * radius) / 12.0 +
+    offset.Lengthsq())
+}
+
+// AreaForCircle returns the area of a hollow circle with an inner radius r1
+// and an outer radius r2.
+func AreaForCircle(r1, r2 Float) (Float) {
+  return PI * (r2 * r2 - r1 * r1).Abs()
+}
+
+// AreaForSegment returns the area of a segment from a to b with a radius,
+// with its rounded ends.
+func AreaForSegment(a, b Vect, radius Float) (Float) {
+  return radius * (PI * radius + 2.0 * a.Dist(b))
+}
+
+// AreaForRoundedPoly returns the area of a convex polygon of either
+// winding, rounded by the radius.
+func AreaForRoundedPoly(verts []Vect, radius Float) (Float) {
+  perimeter := Float(0.0)
+  for i, v := range verts {
+    perimeter += v.Dist(verts[(i + 1) % len(verts)])
+  }
+  return AreaForPoly(verts).Abs() + radius * (perimeter + PI * radius)
+}
+
+// Returns the vertexes of a convex polygon with every edge pushed out by
+// the radius, with sharp corners.
+func inflatePoly(verts []Vect, radius Float) ([]Vect) {
+  n        := len(verts)
+  inflated := make([]Vect, n)
+  // The outward normal of an edge is to the left of it if the polygon is
+  // clockwise.
+  sign := Float(1.0)
+  if AreaForPoly(verts) > 0 { sign = -1.0 }
+  normal := func(a, b Vect) (Vect) {
+    return b.Sub(a).Perp().Normalize().Mult(sign)
+  }
+  for i, v := range verts {
+    n1  := normal(verts[(i + n - 1) % n], v)
+    n2  := normal(v, verts[(i + 1) % n])
+    dir := n1.Add(n2)
+    inflated[i] = v.Add(dir.Mult(radius / (1.0 + n1.Dot(n2))))
+  }
+  return inflated
+}
+
+// MomentForPoly returns the moment of a solid polygon of mass m, offset
+// from the center of gravity, of either winding. A rounded polygon is
+// taken as the polygon with its edges pushed out by the radius, which
+// is a bit more than the rounded one.
+func MomentForPoly(m Float, verts []Vect, offset Vect, radius Float) (
+  Float) {
+  if len(verts) == 2 { return MomentForSegment(m, verts[0], verts[1], radius) }
+  if radius > 0 { verts = inflatePoly(verts, radius) }
+  sum1, sum2 := Float(0.0), Float(0.0)
+  for i, v := range verts {
+    v1 := v.Add(offset)
+    v2 := verts[(i + 1) % len(verts)].Add(offset)
+    a  := v2.Cross(v1)
+    b  := v1.Dot(v1) + v1.Dot(v2) + v2.Dot(v2)
+    sum1 += a * b
+    sum2 += a
+  }
+  return (m * sum1) / (6.0 * sum2)
+}
+
+// MomentForBox returns the moment of a solid box of mass m, centered on
+// the center of gravity.
+func MomentForBox(m, width, height Float) (Float) {
+  return m * (width * width + height * height) / 12.0
+}
@@ -0,0 +1,185 @@
+package tamias
+
+// Tools for polylines, the outlines that come from bitmaps, SVG drawings
+// and maps, to make fewer and better shapes of them.
+//
+// A polyline is a []Vect. It is closed, a loop, if its last point is its
+// first point again. The tools keep polylines closed or open.
+
+// PolylineIsClosed returns true if the polyline is a loop, with its first
+// point again at the end.
+func PolylineIsClosed(points []Vect) (bool) {
+  return len(points) > 2 && points[0] == points[len(points) - 1]
+}
+
+// PolylineClose returns a closed copy of the polyline if its ends are at
+// most tolerance apart, or else an open copy.
+func PolylineClose(points []Vect, tolerance Float) ([]Vect) {
+  result := make([]Vect, len(points))
+  copy(result, points)
+  n := len(result)
+  if n > 2 && result[0].Dist(result[n - 1]) <= tolerance {
+    result[n - 1] = result[0]
+  }
+  return result
+}
+
+// Returns the distance from p to the segment ab.
+func segmentDistance(p, a, b Vect) (Float) {
+  ab := b.Sub(a)
+  t  := Float(0.0)
+  if l := ab.Lengthsq(); l > 0 {
+    t = (p.Sub(a).Dot(ab) / l).Max(0.0).Min(1.0)
+  }
+  return p.Dist(a.Add(ab.Mult(t)))
+}
+
+// PolylineRemoveCollinear drops the points that are at most tolerance away
+// from the line between their neighbours, and repeated points. The ends of
+// open polylines stay.
+func PolylineRemoveCollinear(points []Vect, tolerance Float) ([]Vect) {
+  closed := PolylineIsClosed(points)
+  result := make([]Vect, len(points))
+  copy(result, points)
+  if closed { result = result[0:len(result) - 1] }
+  for removed := true; removed; {
+    removed = false
+    n      := len(result)
+    for i:=0; i < n && n > 2; i++ {
+      if !closed && (i == 0 || i == n - 1) { continue }
+      a, b, c := result[(i + n - 1) % n], result[i], result[(i + 1) % n]
+      if c.Sub(a).Cross(b.Sub(a)).Abs() <= tolerance * c.Dist(a) {
+        result  = append(result[0:i], result[i + 1:]...)
+        removed = true
+        n--
+        i--
+      }
+    }
+  }
+  if closed && len(result) > 0 { result = append(result, result[0]) }
+  return result
+}
+
+// Marks the points between first and last to keep, with Douglas-Peucker.
+func douglasPeucker(points []Vect, tolerance Float, keep []bool,
+  first, last int) {
+  for last - first > 1 {
+    far, farDist := -1, tolerance
+    for i := first + 1; i < last; i++ {
+      if d := segmentDistance(points[i], points[first], points[last]);
+         d > farDist {
+        far, farDist = i, d
+      }
+    }
+    if far < 0 { return }
+    keep[far] = true
+    douglasPeucker(points, tolerance, keep, first, far)
+    first = far
+  }
+}
+
+// PolylineSimplify drops points with the Douglas-Peucker algorithm, so no
+// dropped point is further than tolerance from the result. The ends of
+// open polylines stay. Loops are split at the point that is furthest from
+// their start, and both halves are simplified.
+func PolylineSimplify(points []Vect, tolerance Float) ([]Vect) {
+  n    := len(points)
+  keep := make([]bool, n)
+  if n > 0 {
+    keep[0], keep[n - 1] = true, true
+  }
+  if PolylineIsClosed(points) {
+    far := 0
+    for i, p := range points {
+      if p.Dist(points[0]) > points[far].Dist(points[0]) { far = i }
+    }
+    keep[far] = true
+    douglasPeucker(points, tolerance, keep, 0, far)
+    douglasPeucker(points, tolerance, keep, far, n - 1)
+  } else if n > 2 {
+    douglasPeucker(points, tolerance, keep, 0, n - 1)
+  }
+  result := make([]Vect, 0, n)
+  for i, p := range points {
+    if keep[i] { result = append(result, p) }
+  }
+  return result
+}
+
+// PolylineSmooth rounds the corners of the polyline with Chaikin's
+// algorithm, which cuts every corner at a quarter of its edges, as many
+// times as iterations says. The ends of open polylines stay.
+func PolylineSmooth(points []Vect, iterations int) ([]Vect) {
+  result := make([]Vect, len(points))
+  copy(result, points)
+  closed := PolylineIsClosed(points)
+  for k:=0; k < iterations && len(result) > 2; k++ {
+    n      := len(result)
+    smooth := make([]Vect, 0, 2 * n)
+    if !closed { smooth = append(smooth, result[0]) }
+    for i:=0; i + 1 < n; i++ {
+      a, b  := result[i], result[i + 1]
+      smooth = append(smooth, a.Lerp(b, 0.25), a.Lerp(b, 0.75))
+    }
+    if closed {
+      smooth = append(smooth, smooth[0])
+    } else {
+      smooth = append(smooth, result[n - 1])
+    }
+    result = smooth
+  }
+  return result
+}
+
+// PolylineSnap moves the points of the polyline to the nearest points of
+// a grid with cells of the given size, and drops the points that end up
+// on the point before them.
+func PolylineSnap(points []Vect, grid Float) ([]Vect) {
+  Assert(grid > 0, "The cells of a grid must have a size.")
+  snap := func(x Float) (Float) { return (x / grid + 0.5).Floor() * grid }
+  result := make([]Vect, 0, len(points))
+  for _, p := range points {
+    p = V(snap(p.X), snap(p.Y))
+    if len(result) > 0 && result[len(result) - 1] == p { continue }
+    result = append(result, p)
+  }
+  return result
+}
+
+// PolylineLoopVerts returns the vertexes of a loop without the repeated
+// end, with the winding that PolyShapeNew needs. They make a valid polygon
+// if the loop is convex, or else ConvexDecompose can split them.
+func PolylineLoopVerts(points []Vect) ([]Vect) {
+  n := len(points)
+  if PolylineIsClosed(points) { n-- }
+  verts := make([]Vect, n)
+  copy(verts, points[0:n])
+  if AreaForPoly(verts) > 0 { ReverseVerts(verts) }
+  return verts
+}
+
+// SegmentShapesNew makes a chain of segment shapes on the body along the
+// polyline. Points that repeat the point before them are skipped. The
+// segments know their neighbours, and the ends of a loop are joined, so
+// shapes slide over the chain without catching on its vertexes. The shapes
+// still have to be added to a space.
+func SegmentShapesNew(body *Body, points []Vect, radius Float) (
+  []*SegmentShape) {
+  shapes := make([]*SegmentShape, 0, len(points))
+  for i:=1; i < len(points); i++ {
+    if points[i - 1] == points[i] { continue }
+    shapes = append(shapes, SegmentShapeNew(body, points[i - 1], points[i],
+      radius))
+  }
+  n := len(shapes)
+  for i, seg := range shapes {
+    prev, next := seg.a, seg.b
+    if i > 0 { prev = shapes[i - 1].a }
+    if i < n - 1 { next = shapes[i + 1].b }
+    if n > 2 && PolylineIsClosed(points) {
+      prev, next = shapes[(i + n - 1) % n].a, shapes[(i + 1) % n].b
+    }
+    seg.SetNeighbors(prev, next)
+  }
+  return shapes
+}
@@ -20,6 +20,9 @@ type PolyShape struct {
   // Transformed vertex and axis lists.
   tVerts  []Vect;
   tAxes   []PolyShapeAxis;
+  
+  // Radius that rounds the polygon, like the radius of a segment.
+  r Float
 }
 
 
@@ -99,7 +102,7 @@ func (poly * PolyShape) CacheBB(p, rot Vect) (BB) {
     bb = bb.Expand(v)
   }  
   
-  return bb 
+  return bb.Grow(poly.r) 
 }
 
 func (poly * PolyShape) Destroy() {
@@ -110,7 +113,16 @@ func (poly * PolyShape) Destroy() {
 }  
 
 func (poly * PolyShape) PointQuery(p Vect) (bool) {
-  return poly.Shape.BB.ContainsVect(p) && poly.ContainsVert(p)
+  if !poly.Shape.BB.ContainsVect(p) { return false }
+  if poly.ContainsVert(p) { return true }
+  // Rounded polygons reach r out of the edges.
+  verts := poly.tVerts
+  for i:=0; i < poly.numVerts && poly.r > 0; i++ {
+    if segmentDistance(p, verts[i], verts[(i + 1) % poly.numVerts]) <= poly.r {
+      return true
+    }
+  }
+  return false
 }    
 
 func (poly * PolyShape) SegmentQuery (a, b Vect) (info * SegmentQueryInfo) {
@@ -122,33 +134,39 @@ func (poly * PolyShape) SegmentQuery (a, b Vect) (info * SegmentQueryInfo) {
   
   var axis PolyShapeAxis  
   var n, point Vect
-  var an, bn, t, dt, dtMin, dtMax Float  
+  var an, bn, d, t, dt, dtMin, dtMax Float  
     
+  // The faces of a rounded polygon are r out.
   for i:=0 ; i < numVerts; i++ {
     axis = axes[i]
-    n   = axis.n;
+    n   = axis.n
+    d   = axis.d + poly.r
     an  = a.Dot(n) 
-    if axis.d > an { continue; }
+    if d > an { continue; }
      
     bn  = b.Dot(n)
-    t   = axis.d - an / (bn - an)     
+    t   = (d - an) / (bn - an)     
     if t < Float(0.0) || Float(1.0) < t { continue; }
     
     point = a.Lerp(b, t) 
     dt    = - n.Cross(point)
     dtMin = - n.Cross(verts[i])
     dtMax = - n.Cross(verts[(i+1) % numVerts])
-    if dtMin <= dt && dt <= dtMax {
+    if dtMin <= dt && dt <= dtMax && (!info.Hit() || t < info.t) {
       info.shape = poly.Shape;
       info.t = t;
       info.n = n;
-      // ??? do we not need return info here ???
     }
   }
+  
+  // And the corners are circles.
+  for i:=0; i < numVerts && poly.r > 0; i++ {
+    corner := CircleSegmentQuery(poly.Shape, verts[i], poly.r, a, b)
+    if corner.Hit() && (!info.Hit() || corner.t < info.t) { info = corner }
+  }
   return info
 }
 
-
 var PolyClass = &ShapeClass{ POLY_SHAPE };
 
 // Validate checks if the polygon is winding correcty.
@@ -202,6 +220,7 @@ func (poly * PolyShape) Init(body * Body, verts []Vect,
     "Polygon is concave or has a reversed winding.")  
   poly.setUpVerts(verts, offset);  
   poly.Shape = ShapeNew(PolyClass, body) 
+  poly.Shape.geometry = poly
   bb        := poly.CacheBB(body.p, body.rot)  
   poly.BB    = &bb 
   return poly;
@@ -225,9 +244,39 @@ func BoxShapeNew(body * Body, width, height Float) (poly * PolyShape) {
   return PolyShapeAlloc().BoxInit(body, width, height) 
 }  
 
+// PolyShapeNewRounded makes a polygon that is rounded by the radius, so
+// it is radius bigger than its vertexes on every side.
+func PolyShapeNewRounded(body * Body, verts []Vect, offset Vect, 
+  radius Float) (* PolyShape) {
+  poly := PolyShapeNew(body, verts, offset)
+  poly.SetRadius(radius)
+  return poly
+}
+
+// RoundedBoxShapeNew makes a box of width by height, rounded by the
+// radius, so it is radius bigger on every side.
+func RoundedBoxShapeNew(body * Body, width, height, radius Float) (
+  * PolyShape) {
+  poly := BoxShapeNew(body, width, height)
+  poly.SetRadius(radius)
+  return poly
+}
+
+func (poly * PolyShape) Radius() (Float) {
+  return poly.r
+}
+
 // Unsafe API (chipmunk_unsafe.h)
 func (poly * PolyShape) SetVerts(numVerts int, verts []Vect, offset Vect) {
   poly.Destroy()
   poly.setUpVerts(verts, offset);
 }
 
+func (poly * PolyShape) SetRadius(r Float) (Float) {
+  Assert(r >= 0, "The radius of a polygon can't be negative.")
+  poly.r   = r
+  bb      := poly.CacheBB(poly.Body.p, poly.Body.rot)
+  poly.BB  = &bb
+  return poly.r
+}
+
@@ -0,0 +1,848 @@
+package tamias
+
+// A Scene is a plain description of the contents of a space, used to save
+// spaces to files and load them back. Bodies, shapes and constraints refer
+// to each other by their index in the scene, which is their stable id.
+// Collision handlers and mixing rules are functions, so they can't be saved
+// in a scene.
+//
+// Since version 5, a scene also keeps the state of the solver: the
+// arbiters with the impulses of their contacts, the impulses of the
+// constraints and the hash ids of the shapes. A loaded space then steps
+// on exactly like the saved one, as long as it gets the same handlers and
+// mixing rules.
+
+import "fmt"
+import "os"
+import "sort"
+
+// Version of the scene format. Increase it when the format changes.
+const SCENE_VERSION = 5
+
+type SceneBody struct {
+  Id int
+  // Static bodies are used by shapes, but are not added to the space.
+  Static bool
+  Mass, Moment Float
+  Pos, Vel, Force Vect
+  Angle, AngVel, Torque Float
+  VelLimit, AngVelLimit Float
+  // The velocities that push the body out of overlaps, which the next
+  // step still uses. Since version 5.
+  VelBias Vect
+  AngVelBias Float
+}
+
+type SceneShape struct {
+  Id int
+  Body int
+  // One of "circle", "segment", "poly" or "heightfield".
+  Kind string
+  // Static shapes are added with AddStaticShape.
+  Static bool
+  Sensor bool
+  Elasticity, Friction Float
+  SurfaceV Vect
+  CollisionType CollisionType
+  Group GroupType
+  Layers LayerType
+  // Circle: radius and offset. Segment: radius and endpoints.
+  // Poly: radius, and vertexes with the offset already applied.
+  // Heightfield: the points of the surface, as vertexes.
+  Radius Float
+  Offset Vect
+  A, B Vect
+  Verts []Vect
+  // Segment: the directions from the endpoints to the neighbouring
+  // vertexes of its chain, zero where it has none, and whether it only
+  // collides on one side. Since version 2.
+  ATangent, BTangent Vect
+  OneSided bool
+  // The direction a one-way shape collides from, zero if it collides from
+  // all directions. Since version 3.
+  OneWay Vect
+  // Id of the material of the shape, or 0 if it has none. The surface
+  // above is the shape's own, which it uses without a material. Since
+  // version 4.
+  Material int
+  // The hash id of the shape, which keys its arbiters and orders them in
+  // deterministic spaces. Since version 5.
+  HashId HashValue
+}
+
+// Materials are numbered from 1, so that 0 is no material. Since version 4.
+type SceneMaterial struct {
+  Id int
+  Friction, Elasticity Float
+  SurfaceV Vect
+  Density Float
+}
+
+// The material of a pair of collision types. Since version 4.
+type ScenePairMaterial struct {
+  A, B CollisionType
+  Material int
+}
+
+type SceneConstraint struct {
+  Id int
+  // One of "damped_rotary_spring", "damped_spring", "gear", "groove",
+  // "pin", "pivot", "ratchet", "rotary_limit", "simple_motor" or "slide".
+  Kind string
+  A, B int
+  MaxForce, BiasCoef, MaxBias Float
+  // Which of the parameters below are used depends on the kind.
+  Anchr1, Anchr2 Vect
+  GrooveA, GrooveB Vect
+  Min, Max, Dist Float
+  RestLength, RestAngle, Stiffness, Damping Float
+  Phase, Ratio, Ratchet, Angle, Rate Float
+  // The impulses the solver accumulated, which it starts from in the next
+  // step. How many there are depends on the kind. Since version 5.
+  Impulses []Float
+}
+
+// A contact of an arbiter, with the impulses the solver applied to it.
+// Since version 5.
+type SceneContact struct {
+  Pos, Normal Vect
+  Dist Float
+  NormalImpulse, TangentImpulse Float
+  Hash HashValue
+}
+
+// A pair of shapes that touched recently. When they touch again, the
+// solver starts from the impulses of the contacts. A and B are the ids of
+// the shapes, in the order the collision functions take them. Since
+// version 5.
+type SceneArbiter struct {
+  A, B int
+  // The step the shapes last touched in, or -1 for a new pair.
+  Stamp int
+  State ArbiterState
+  Swapped bool
+  Elasticity, Friction Float
+  SurfaceV Vect
+  Contacts []SceneContact
+}
+
+type Scene struct {
+  Version int
+  Gravity Vect
+  Damping Float
+  Iterations, ElasticIterations int
+  // The number of steps taken, and the setting of the same name of the
+  // space. Since version 5.
+  Stamp int
+  Deterministic bool
+  Materials []SceneMaterial
+  PairMaterials []ScenePairMaterial
+  Bodies []SceneBody
+  Shapes []SceneShape
+  Constraints []SceneConstraint
+  Arbiters []SceneArbiter
+}
+
+// Sorts shapes on their hash id, which is the order they were created in.
+type shapeOrder []*Shape
+
+func (order shapeOrder) Len() (int) {
+  return len(order)
+}
+
+func (order shapeOrder) Less(i, j int) (bool) {
+  return order[i].hashid < order[j].hashid
+}
+
+func (order shapeOrder) Swap(i, j int) {
+  order[i], order[j] = order[j], order[i]
+}
+
+// Returns the shapes in the spatial hash, in the order they were created.
+func sortedShapes(hash *SpaceHash) ([]*Shape) {
+  shapes := make([]*Shape, 0)
+  hash.Each(func(obj, data HashElement) {
+    shapes = append(shapes, obj.(*Shape))
+  }, nil)
+  sort.Sort(shapeOrder(shapes))
+  return shapes
+}
+
+// Sorts pair materials on their collision types.
+type pairMaterialOrder []pairMaterial
+
+func (order pairMaterialOrder) Len() (int) {
+  return len(order)
+}
+
+func (order pairMaterialOrder) Less(i, j int) (bool) {
+  if order[i].a != order[j].a { return order[i].a < order[j].a }
+  return order[i].b < order[j].b
+}
+
+func (order pairMaterialOrder) Swap(i, j int) {
+  order[i], order[j] = order[j], order[i]
+}
+
+// Returns the kind of the shape in scenes, or "" if it can't be saved.
+func sceneShapeKind(shape *Shape) (string) {
+  switch shape.geometry.(type) {
+    case *CircleShape:
+      return "circle"
+    case *SegmentShape:
+      return "segment"
+    case *PolyShape:
+      return "poly"
+    case *HeightfieldShape:
+      return "heightfield"
+  }
+  return ""
+}
+
+// Helps describing a space as a scene, one part at a time. It gives ids
+// to the bodies, the shapes and the materials, and lists the shapes,
+// constraints and arbiters that can be saved.
+type sceneBuilder struct {
+  materials []*Material
+  materialIds map[*Material] int
+  pairs []pairMaterial
+  bodies []*Body
+  static []bool
+  bodyIds map[*Body] int
+  // The active shapes come first, then numStatic static shapes.
+  shapes []*Shape
+  shapeIds map[*Shape] int
+  numStatic int
+  constraints []Constraint
+  arbiters []*Arbiter
+}
+
+func (builder *sceneBuilder) bodyId(body *Body, static bool) (int) {
+  id, ok := builder.bodyIds[body]
+  if ok { return id }
+  id                    = len(builder.bodies)
+  builder.bodyIds[body] = id
+  builder.bodies        = append(builder.bodies, body)
+  builder.static        = append(builder.static, static)
+  return id
+}
+
+// Returns the id of the material, or 0 for nil.
+func (builder *sceneBuilder) materialId(material *Material) (int) {
+  if material == nil { return 0 }
+  id, ok := builder.materialIds[material]
+  if ok { return id }
+  builder.materials             = append(builder.materials, material)
+  builder.materialIds[material] = len(builder.materials)
+  return len(builder.materials)
+}
+
+func (builder *sceneBuilder) addShape(shape *Shape) {
+  if sceneShapeKind(shape) == "" { return }
+  builder.bodyId(shape.Body, true)
+  builder.materialId(shape.material)
+  builder.shapeIds[shape] = len(builder.shapes)
+  builder.shapes          = append(builder.shapes, shape)
+}
+
+func (builder *sceneBuilder) addConstraint(con Constraint) {
+  base := constraintBase(con)
+  if base == nil { return }
+  builder.bodyId(base.a, true)
+  builder.bodyId(base.b, true)
+  builder.constraints = append(builder.constraints, con)
+}
+
+// Lists the arbiter if both of its shapes are in the scene.
+func (builder *sceneBuilder) addArbiter(arb *Arbiter) {
+  _, a := builder.shapeIds[arb.private_a]
+  _, b := builder.shapeIds[arb.private_b]
+  if a && b {
+    builder.arbiters = append(builder.arbiters, arb)
+  }
+}
+
+// Lists the parts of the space. The bodies of the space get the lowest
+// ids, then come the other bodies of the shapes and the constraints.
+func newSceneBuilder(space *Space) (*sceneBuilder) {
+  builder := &sceneBuilder{bodyIds: make(map[*Body] int),
+    shapeIds: make(map[*Shape] int), materialIds: make(map[*Material] int)}
+  for i:=0; i < space.bodies.Size(); i++ {
+    builder.bodyId(space.bodies.Index(i).(*Body), false)
+  }
+  for _, shape := range sortedShapes(space.activeShapes) {
+    builder.addShape(shape)
+  }
+  active := len(builder.shapes)
+  for _, shape := range sortedShapes(space.staticShapes) {
+    builder.addShape(shape)
+  }
+  builder.numStatic = len(builder.shapes) - active
+  for i:=0; i < space.constraints.Size(); i++ {
+    builder.addConstraint(space.constraints.Index(i).(Constraint))
+  }
+  for _, pair := range space.pairMaterials {
+    builder.pairs = append(builder.pairs, pair)
+  }
+  sort.Sort(pairMaterialOrder(builder.pairs))
+  for _, pair := range builder.pairs {
+    builder.materialId(pair.material)
+  }
+  for _, key := range space.sortedContactSetKeys() {
+    builder.addArbiter(space.contactSet[key])
+  }
+  return builder
+}
+
+// Returns a scene with only the settings of the space.
+func sceneSettings(space *Space) (*Scene) {
+  scene                  := &Scene{Version: SCENE_VERSION}
+  scene.Gravity           = space.Gravity
+  scene.Damping           = space.Damping
+  scene.Iterations        = space.Iterations
+  scene.ElasticIterations = space.ElasticIterations
+  scene.Stamp             = space.stamp
+  scene.Deterministic     = space.Deterministic
+  return scene
+}
+
+// Describes the material with the given id.
+func (builder *sceneBuilder) material(id int) (SceneMaterial) {
+  m := builder.materials[id - 1]
+  return SceneMaterial{id, m.Friction, m.Elasticity, m.SurfaceV, m.Density}
+}
+
+// Describes the pair material with the given index.
+func (builder *sceneBuilder) pairMaterial(i int) (ScenePairMaterial) {
+  pair := builder.pairs[i]
+  return ScenePairMaterial{pair.a, pair.b, builder.materialIds[pair.material]}
+}
+
+// Describes the body with the given id.
+func (builder *sceneBuilder) body(id int) (SceneBody) {
+  body := builder.bodies[id]
+  return SceneBody{id, builder.static[id], body.m, body.i, body.p, body.v,
+    body.f, body.a, body.w, body.t, body.v_limit, body.w_limit, body.v_bias,
+    body.w_bias}
+}
+
+// Describes the shape with the given id.
+func (builder *sceneBuilder) shape(id int) (SceneShape) {
+  shape   := builder.shapes[id]
+  s       := SceneShape{}
+  s.Id     = id
+  s.Kind   = sceneShapeKind(shape)
+  s.Body   = builder.bodyIds[shape.Body]
+  s.Static = id >= len(builder.shapes) - builder.numStatic
+  s.Sensor = shape.sensor
+  s.Elasticity    = shape.e
+  s.Friction      = shape.u
+  s.SurfaceV      = shape.surface_v
+  s.Material      = builder.materialIds[shape.material]
+  s.CollisionType = shape.collision_type
+  s.Group         = shape.group
+  s.Layers        = shape.layers
+  s.OneWay        = shape.oneWay
+  s.HashId        = shape.hashid
+  switch geometry := shape.geometry.(type) {
+    case *CircleShape:
+      s.Radius = geometry.r
+      s.Offset = geometry.c
+    case *SegmentShape:
+      s.Radius   = geometry.r
+      s.A        = geometry.a
+      s.B        = geometry.b
+      s.ATangent = geometry.aTangent
+      s.BTangent = geometry.bTangent
+      s.OneSided = geometry.oneSided
+    case *PolyShape:
+      s.Radius = geometry.r
+      s.Verts  = make([]Vect, geometry.numVerts)
+      copy(s.Verts, geometry.verts)
+    case *HeightfieldShape:
+      s.Verts = make([]Vect, len(geometry.heights))
+      for i := range s.Verts { s.Verts[i] = geometry.point(i) }
+  }
+  return s
+}
+
+// Describes the constraint with the given id.
+func (builder *sceneBuilder) constraint(id int) (SceneConstraint) {
+  con := builder.constraints[id]
+  c   := SceneConstraint{}
+  switch joint := con.(type) {
+    case *DampedRotarySpring:
+      c.Kind      = "damped_rotary_spring"
+      c.RestAngle = joint.restAngle
+      c.Stiffness = joint.stiffness
+      c.Damping   = joint.damping
+    case *DampedSpring:
+      c.Kind       = "damped_spring"
+      c.Anchr1     = joint.anchr1
+      c.Anchr2     = joint.anchr2
+      c.RestLength = joint.restLength
+      c.Stiffness  = joint.stiffness
+      c.Damping    = joint.damping
+    case *GearJoint:
+      c.Kind  = "gear"
+      c.Phase = joint.phase
+      c.Ratio = joint.ratio
+    case *GrooveJoint:
+      c.Kind    = "groove"
+      c.GrooveA = joint.grv_a
+      c.GrooveB = joint.grv_b
+      c.Anchr2  = joint.anchr2
+    case *PinJoint:
+      c.Kind   = "pin"
+      c.Anchr1 = joint.anchr1
+      c.Anchr2 = joint.anchr2
+      c.Dist   = joint.dist
+    case *PivotJoint:
+      c.Kind   = "pivot"
+      c.Anchr1 = joint.anchr1
+      c.Anchr2 = joint.anchr2
+    case *RatchetJoint:
+      c.Kind    = "ratchet"
+      c.Phase   = joint.phase
+      c.Ratchet = joint.ratchet
+      c.Angle   = joint.angle
+    case *RotaryLimitJoint:
+      c.Kind = "rotary_limit"
+      c.Min  = joint.min
+      c.Max  = joint.max
+    case *SimpleMotor:
+      c.Kind = "simple_motor"
+      c.Rate = joint.rate
+    case *SlideJoint:
+      c.Kind   = "slide"
+      c.Anchr1 = joint.anchr1
+      c.Anchr2 = joint.anchr2
+      c.Min    = joint.min
+      c.Max    = joint.max
+  }
+  base      := constraintBase(con)
+  c.Id       = id
+  c.A        = builder.bodyIds[base.a]
+  c.B        = builder.bodyIds[base.b]
+  c.MaxForce = base.maxForce
+  c.BiasCoef = base.biasCoef
+  c.MaxBias  = base.maxBias
+  c.Impulses = saveConstraintState(con, nil)
+  return c
+}
+
+// Describes the arbiter with the given index.
+func (builder *sceneBuilder) arbiter(i int) (SceneArbiter) {
+  arb         := builder.arbiters[i]
+  a           := SceneArbiter{}
+  a.A          = builder.shapeIds[arb.private_a]
+  a.B          = builder.shapeIds[arb.private_b]
+  a.Stamp      = arb.stamp
+  a.State      = arb.state
+  a.Swapped    = arb.swappedColl
+  a.Elasticity = arb.e
+  a.Friction   = arb.u
+  a.SurfaceV   = arb.surface_vr
+  a.Contacts   = make([]SceneContact, arb.numContacts)
+  for j, con := range arb.contacts[0:arb.numContacts] {
+    a.Contacts[j] = SceneContact{con.P, con.N, con.Dist, con.jnAcc,
+      con.jtAcc, con.Hash}
+  }
+  return a
+}
+
+// SceneFromSpace describes the contents of the space as a scene.
+func SceneFromSpace(space *Space) (*Scene) {
+  scene   := sceneSettings(space)
+  builder := newSceneBuilder(space)
+  scene.Materials = make([]SceneMaterial, len(builder.materials))
+  for i := range scene.Materials {
+    scene.Materials[i] = builder.material(i + 1)
+  }
+  scene.PairMaterials = make([]ScenePairMaterial, len(builder.pairs))
+  for i := range scene.PairMaterials {
+    scene.PairMaterials[i] = builder.pairMaterial(i)
+  }
+  scene.Bodies = make([]SceneBody, len(builder.bodies))
+  for i := range scene.Bodies {
+    scene.Bodies[i] = builder.body(i)
+  }
+  scene.Shapes = make([]SceneShape, len(builder.shapes))
+  for i := range scene.Shapes {
+    scene.Shapes[i] = builder.shape(i)
+  }
+  scene.Constraints = make([]SceneConstraint, len(builder.constraints))
+  for i := range scene.Constraints {
+    scene.Constraints[i] = builder.constraint(i)
+  }
+  scene.Arbiters = make([]SceneArbiter, len(builder.arbiters))
+  for i := range scene.Arbiters {
+    scene.Arbiters[i] = builder.arbiter(i)
+  }
+  return scene
+}
+
+// Takes the parts of a scene one at a time, in the order of the scene:
+// the settings first, then the materials, the pair materials, the bodies,
+// the shapes, the constraints and the arbiters. Scene collects them,
+// sceneLoader adds them to a new space.
+type sceneReader interface {
+  readSettings(settings *Scene) (os.Error)
+  readMaterial(m *SceneMaterial) (os.Error)
+  readPairMaterial(p *ScenePairMaterial) (os.Error)
+  readBody(b *SceneBody) (os.Error)
+  readShape(s *SceneShape) (os.Error)
+  readConstraint(c *SceneConstraint) (os.Error)
+  readArbiter(a *SceneArbiter) (os.Error)
+}
+
+func (scene *Scene) readSettings(settings *Scene) (os.Error) {
+  scene.Version           = settings.Version
+  scene.Gravity           = settings.Gravity
+  scene.Damping           = settings.Damping
+  scene.Iterations        = settings.Iterations
+  scene.ElasticIterations = settings.ElasticIterations
+  scene.Stamp             = settings.Stamp
+  scene.Deterministic     = settings.Deterministic
+  return nil
+}
+
+func (scene *Scene) readMaterial(m *SceneMaterial) (os.Error) {
+  scene.Materials = append(scene.Materials, *m)
+  return nil
+}
+
+func (scene *Scene) readPairMaterial(p *ScenePairMaterial) (os.Error) {
+  scene.PairMaterials = append(scene.PairMaterials, *p)
+  return nil
+}
+
+func (scene *Scene) readBody(b *SceneBody) (os.Error) {
+  scene.Bodies = append(scene.Bodies, *b)
+  return nil
+}
+
+func (scene *Scene) readShape(s *SceneShape) (os.Error) {
+  scene.Shapes = append(scene.Shapes, *s)
+  return nil
+}
+
+func (scene *Scene) readConstraint(c *SceneConstraint) (os.Error) {
+  scene.Constraints = append(scene.Constraints, *c)
+  return nil
+}
+
+func (scene *Scene) readArbiter(a *SceneArbiter) (os.Error) {
+  scene.Arbiters = append(scene.Arbiters, *a)
+  return nil
+}
+
+func sceneError(message string, id int) (os.Error) {
+  return os.NewError(fmt.Sprintf("scene: %s %d", message, id))
+}
+
+// Returns the body with the given id, or nil if there is no such body.
+func sceneBody(bodies []*Body, id int) (*Body) {
+  if id < 0 || id >= len(bodies) { return nil }
+  return bodies[id]
+}
+
+// Returns the shape with the given id, or nil if there is no such shape.
+func sceneShape(shapes []*Shape, id int) (*Shape) {
+  if id < 0 || id >= len(shapes) { return nil }
+  return shapes[id]
+}
+
+// Returns the material with the given id, or nil if there is no such
+// material.
+func sceneMaterial(materials []*Material, id int) (*Material) {
+  if id < 1 || id > len(materials) { return nil }
+  return materials[id - 1]
+}
+
+// Returns true if the surface points of a heightfield are at least 2, at
+// regular X intervals.
+func sceneHeightfieldValid(verts []Vect) (bool) {
+  if len(verts) < 2 || verts[1].X <= verts[0].X { return false }
+  spacing := verts[1].X - verts[0].X
+  for i, v := range verts {
+    x := verts[0].X + Float(i) * spacing
+    if (v.X - x).Abs() > spacing * 0.001 { return false }
+  }
+  return true
+}
+
+func (s *SceneShape) newShape(body *Body) (*Shape, os.Error) {
+  var shape *Shape
+  switch s.Kind {
+    case "circle":
+      shape = CircleShapeNew(body, s.Radius, s.Offset).Shape
+    case "segment":
+      seg := SegmentShapeNew(body, s.A, s.B, s.Radius)
+      seg.SetNeighbors(s.A.Add(s.ATangent), s.B.Add(s.BTangent))
+      seg.SetOneSided(s.OneSided)
+      shape = seg.Shape
+    case "poly":
+      if len(s.Verts) < 3 || !PolyShapeValidate(s.Verts) {
+        return nil, sceneError("invalid polygon for shape", s.Id)
+      }
+      shape = PolyShapeNewRounded(body, s.Verts, VZERO, s.Radius).Shape
+    case "heightfield":
+      if !sceneHeightfieldValid(s.Verts) {
+        return nil, sceneError("invalid heightfield for shape", s.Id)
+      }
+      heights := make([]Float, len(s.Verts))
+      for i, v := range s.Verts { heights[i] = v.Y }
+      shape = HeightfieldShapeNew(body, V(s.Verts[0].X, 0.0),
+        s.Verts[1].X - s.Verts[0].X, heights).Shape
+    default:
+      return nil, sceneError("unknown kind " + s.Kind + " of shape", s.Id)
+  }
+  shape.sensor         = s.Sensor
+  shape.e              = s.Elasticity
+  shape.u              = s.Friction
+  shape.surface_v      = s.SurfaceV
+  shape.collision_type = s.CollisionType
+  shape.group          = s.Group
+  shape.layers         = s.Layers
+  shape.SetOneWay(s.OneWay)
+  return shape, nil
+}
+
+func (c *SceneConstraint) newConstraint(a, b *Body) (Constraint, os.Error) {
+  var result Constraint
+  var base *constraint
+  switch c.Kind {
+    case "damped_rotary_spring":
+      joint := DampedRotarySpringNew(a, b, c.RestAngle, c.Stiffness,
+        c.Damping)
+      result, base = joint, &joint.constraint
+    case "damped_spring":
+      joint := &DampedSpring{anchr1: c.Anchr1, anchr2: c.Anchr2,
+        restLength: c.RestLength, stiffness: c.Stiffness, damping: c.Damping}
+      result, base = joint, &joint.constraint
+    case "gear":
+      joint := &GearJoint{phase: c.Phase, ratio: c.Ratio,
+        ratio_inv: Float(1.0) / c.Ratio}
+      result, base = joint, &joint.constraint
+    case "groove":
+      joint := &GrooveJoint{grv_a: c.GrooveA, grv_b: c.GrooveB,
+        grv_n: c.GrooveB.Sub(c.GrooveA).Normalize().Perp(), anchr2: c.Anchr2}
+      result, base = joint, &joint.constraint
+    case "pin":
+      joint := &PinJoint{anchr1: c.Anchr1, anchr2: c.Anchr2, dist: c.Dist}
+      result, base = joint, &joint.constraint
+    case "pivot":
+      joint := &PivotJoint{anchr1: c.Anchr1, anchr2: c.Anchr2}
+      result, base = joint, &joint.constraint
+    case "ratchet":
+      joint := &RatchetJoint{phase: c.Phase, ratchet: c.Ratchet,
+        angle: c.Angle}
+      result, base = joint, &joint.constraint
+    case "rotary_limit":
+      joint := &RotaryLimitJoint{min: c.Min, max: c.Max}
+      result, base = joint, &joint.constraint
+    case "simple_motor":
+      joint := &SimpleMotor{rate: c.Rate}
+      result, base = joint, &joint.constraint
+    case "slide":
+      joint := &SlideJoint{anchr1: c.Anchr1, anchr2: c.Anchr2,
+        min: c.Min, max: c.Max}
+      result, base = joint, &joint.constraint
+    default:
+      return nil, sceneError("unknown kind " + c.Kind + " of constraint",
+        c.Id)
+  }
+  base.Init(a, b)
+  base.maxForce = c.MaxForce
+  base.biasCoef = c.BiasCoef
+  base.maxBias  = c.MaxBias
+  if len(c.Impulses) > 0 {
+    if len(saveConstraintState(result, nil)) != len(c.Impulses) {
+      return nil, sceneError("bad impulses for constraint", c.Id)
+    }
+    loadConstraintState(result, c.Impulses)
+  }
+  return result, nil
+}
+
+// Builds a new space from the parts of a scene.
+type sceneLoader struct {
+  space *Space
+  materials []*Material
+  bodies []*Body
+  shapes []*Shape
+  // Whether the shapes keep the hash ids of the scene, which older
+  // scenes don't have.
+  keepHashIds bool
+  hashIds map[HashValue] bool
+}
+
+func newSceneLoader() (*sceneLoader) {
+  return &sceneLoader{space: SpaceNew(), hashIds: make(map[HashValue] bool)}
+}
+
+func (loader *sceneLoader) readSettings(settings *Scene) (os.Error) {
+  space                  := loader.space
+  space.Gravity           = settings.Gravity
+  space.Damping           = settings.Damping
+  space.Iterations        = settings.Iterations
+  space.ElasticIterations = settings.ElasticIterations
+  space.Deterministic     = settings.Deterministic
+  space.stamp             = settings.Stamp
+  space.resetContactBuffers()
+  loader.keepHashIds      = settings.Version >= 5
+  return nil
+}
+
+func (loader *sceneLoader) readMaterial(m *SceneMaterial) (os.Error) {
+  if m.Id != len(loader.materials) + 1 {
+    return sceneError("bad id for material", m.Id)
+  }
+  material         := MaterialNew(m.Friction, m.Elasticity, m.Density)
+  material.SurfaceV = m.SurfaceV
+  loader.materials  = append(loader.materials, material)
+  return nil
+}
+
+func (loader *sceneLoader) readPairMaterial(p *ScenePairMaterial) (os.Error) {
+  material := sceneMaterial(loader.materials, p.Material)
+  if material == nil {
+    return sceneError("unknown pair material", p.Material)
+  }
+  loader.space.SetPairMaterial(p.A, p.B, material)
+  return nil
+}
+
+func (loader *sceneLoader) readBody(b *SceneBody) (os.Error) {
+  if b.Id != len(loader.bodies) { return sceneError("bad id for body", b.Id) }
+  body         := BodyNew(b.Mass, b.Moment)
+  body.p        = b.Pos
+  body.v        = b.Vel
+  body.f        = b.Force
+  body.SetAngle(b.Angle)
+  body.w        = b.AngVel
+  body.t        = b.Torque
+  body.v_limit  = b.VelLimit
+  body.w_limit  = b.AngVelLimit
+  body.v_bias   = b.VelBias
+  body.w_bias   = b.AngVelBias
+  loader.bodies = append(loader.bodies, body)
+  if !b.Static {
+    loader.space.AddBody(body)
+  }
+  return nil
+}
+
+func (loader *sceneLoader) readShape(s *SceneShape) (os.Error) {
+  body := sceneBody(loader.bodies, s.Body)
+  if body == nil { return sceneError("unknown body for shape", s.Id) }
+  shape, err := s.newShape(body)
+  if err != nil { return err }
+  if s.Material != 0 {
+    material := sceneMaterial(loader.materials, s.Material)
+    if material == nil {
+      return sceneError("unknown material for shape", s.Id)
+    }
+    shape.SetMaterial(material)
+  }
+  // The hash id keys the shape in the spatial hashes, so it must be set
+  // before adding the shape. Later shapes get higher ids.
+  if loader.keepHashIds {
+    if loader.hashIds[s.HashId] {
+      return sceneError("duplicate hash id for shape", s.Id)
+    }
+    loader.hashIds[s.HashId] = true
+    shape.hashid             = s.HashId
+    if SHAPE_ID_COUNTER <= s.HashId {
+      SHAPE_ID_COUNTER = s.HashId + 1
+    }
+  }
+  loader.shapes = append(loader.shapes, shape)
+  if s.Static {
+    loader.space.AddStaticShape(shape)
+  } else {
+    loader.space.AddShape(shape)
+  }
+  return nil
+}
+
+func (loader *sceneLoader) readConstraint(c *SceneConstraint) (os.Error) {
+  a := sceneBody(loader.bodies, c.A)
+  b := sceneBody(loader.bodies, c.B)
+  if a == nil || b == nil {
+    return sceneError("unknown body for constraint", c.Id)
+  }
+  con, err := c.newConstraint(a, b)
+  if err != nil { return err }
+  loader.space.AddConstraint(con)
+  return nil
+}
+
+// Puts the arbiter in the contact set of the space. The space only solves
+// it once its shapes touch again, in the next step.
+func (loader *sceneLoader) readArbiter(a *SceneArbiter) (os.Error) {
+  space  := loader.space
+  sa, sb := sceneShape(loader.shapes, a.A), sceneShape(loader.shapes, a.B)
+  if sa == nil { return sceneError("unknown shape for arbiter", a.A) }
+  if sb == nil { return sceneError("unknown shape for arbiter", a.B) }
+  key := pairKeyNew(sa.hashid, sb.hashid)
+  if space.contactSet[key] != nil {
+    return sceneError("duplicate arbiter for shape", a.A)
+  }
+  arb := space.getArbiter(sa, sb)
+  arb.contacts    = make([]Contact, len(a.Contacts))
+  arb.numContacts = len(a.Contacts)
+  for i, c := range a.Contacts {
+    con      := &arb.contacts[i]
+    con.P     = c.Pos
+    con.N     = c.Normal
+    con.Dist  = c.Dist
+    con.jnAcc = c.NormalImpulse
+    con.jtAcc = c.TangentImpulse
+    con.Hash  = c.Hash
+  }
+  arb.handler     = space.lookupHandler(sa.collision_type, sb.collision_type)
+  arb.e           = a.Elasticity
+  arb.u           = a.Friction
+  arb.surface_vr  = a.SurfaceV
+  arb.stamp       = a.Stamp
+  arb.state       = a.State
+  arb.swappedColl = a.Swapped
+  space.contactSet[key] = arb
+  return nil
+}
+
+// Space creates a new space with the contents of the scene.
+func (scene *Scene) Space() (*Space, os.Error) {
+  if scene.Version < 1 || scene.Version > SCENE_VERSION {
+    return nil, sceneError("unsupported version", scene.Version)
+  }
+  loader := newSceneLoader()
+  loader.readSettings(scene)
+  for i:=0; i < len(scene.Materials); i++ {
+    err := loader.readMaterial(&scene.Materials[i])
+    if err != nil { return nil, err }
+  }
+  for i:=0; i < len(scene.PairMaterials); i++ {
+    err := loader.readPairMaterial(&scene.PairMaterials[i])
+    if err != nil { return nil, err }
+  }
+  for i:=0; i < len(scene.Bodies); i++ {
+    err := loader.readBody(&scene.Bodies[i])
+    if err != nil { return nil, err }
+  }
+  for i:=0; i < len(scene.Shapes); i++ {
+    err := loader.readShape(&scene.Shapes[i])
+    if err != nil { return nil, err }
+  }
+  for i:=0; i < len(scene.Constraints); i++ {
+    err := loader.readConstraint(&scene.Constraints[i])
+    if err != nil { return nil, err }
+  }
+  for i:=0; i < len(scene.Arbiters); i++ {
+    err := loader.readArbiter(&scene.Arbiters[i])
+    if err != nil { return nil, err }
+  }
+  return loader.space, nil
+}
@@ -0,0 +1,802 @@
+package tamias
+
+// A compact binary encoding of scenes, for large saves.
+//
+// All numbers are little endian. The file starts with the magic "TMSB",
+// the version of the writer, the oldest version of the reader that can
+// read the file and the number of bits of the floats in it, 32 or 64.
+// The versions are those of the scene format.
+// Then come sections, each with a tag of four bytes and the number of
+// records in it. Every record starts with its length in bytes. Readers
+// skip sections with an unknown tag, and skip fields at the end of a
+// record that they don't know, so newer writers can add both without
+// breaking older readers. Fields missing at the end of a record read as
+// zero. The last section is "END ", without records, followed by the
+// CRC-32 (IEEE) of everything written before it.
+//
+// Shapes and constraints start with their kind, and only store the fields
+// of the scene that their kind uses. Floats are stored with the size of
+// Float of the writer, and read into the size of Float of the reader.
+//
+// Spaces are written and read a record at a time, without a Scene of the
+// whole space in memory.
+
+import "bytes"
+import "encoding/binary"
+import "hash"
+import "hash/crc32"
+import "io"
+import "math"
+import "os"
+
+const (
+  // Version written in the header.
+  BINARY_VERSION        = 5
+  // Oldest version of the reader that can read what this version writes.
+  BINARY_COMPAT_VERSION = 1
+)
+
+const binaryMagic = "TMSB"
+
+// Records longer than this are certainly corrupt.
+const binaryMaxRecord = 1 << 24
+
+const (
+  binarySpace       = "SPCE"
+  // Since version 4.
+  binaryMaterials   = "MATL"
+  binaryPairs       = "PAIR"
+  binaryBodies      = "BODY"
+  binaryShapes      = "SHAP"
+  binaryConstraints = "CONS"
+  // Since version 5.
+  binaryArbiters    = "ARBS"
+  binaryEnd         = "END "
+)
+
+// Codes of the kinds of shapes and constraints in the binary format.
+// Don't renumber them, only add new ones.
+var binaryShapeKinds = []string{"", "circle", "segment", "poly",
+  "heightfield"}
+
+var binaryConstraintKinds = []string{"", "damped_rotary_spring",
+  "damped_spring", "gear", "groove", "pin", "pivot", "ratchet",
+  "rotary_limit", "simple_motor", "slide"}
+
+func binaryKindCode(kinds []string, kind string) (uint32) {
+  for i, k := range kinds {
+    if k == kind { return uint32(i) }
+  }
+  return 0
+}
+
+func binaryKind(kinds []string, code uint32) (string) {
+  if code >= uint32(len(kinds)) { return "" }
+  return kinds[code]
+}
+
+// Writes a scene record by record. Errors are remembered, and stop
+// any further writing.
+type binaryEncoder struct {
+  w io.Writer
+  crc hash.Hash32
+  record bytes.Buffer
+  scratch [8]byte
+  err os.Error
+}
+
+func newBinaryEncoder(w io.Writer) (*binaryEncoder) {
+  return &binaryEncoder{w: w, crc: crc32.NewIEEE()}
+}
+
+func (enc *binaryEncoder) write(data []byte) {
+  if enc.err != nil { return }
+  enc.crc.Write(data)
+  _, enc.err = enc.w.Write(data)
+}
+
+func (enc *binaryEncoder) writeUint32(v uint32) {
+  binary.LittleEndian.PutUint32(enc.scratch[0:4], v)
+  enc.write(enc.scratch[0:4])
+}
+
+func (enc *binaryEncoder) section(tag string, count int) {
+  enc.write([]byte(tag))
+  enc.writeUint32(uint32(count))
+}
+
+// Writes the fields put since the last call as one record.
+func (enc *binaryEncoder) endRecord() {
+  enc.writeUint32(uint32(enc.record.Len()))
+  enc.write(enc.record.Bytes())
+  enc.record.Reset()
+}
+
+func (enc *binaryEncoder) putUint32(v uint32) {
+  binary.LittleEndian.PutUint32(enc.scratch[0:4], v)
+  enc.record.Write(enc.scratch[0:4])
+}
+
+func (enc *binaryEncoder) putInt(v int) {
+  enc.putUint32(uint32(int32(v)))
+}
+
+func (enc *binaryEncoder) putHash(v HashValue) {
+  binary.LittleEndian.PutUint64(enc.scratch[0:8], uint64(v))
+  enc.record.Write(enc.scratch[0:8])
+}
+
+func (enc *binaryEncoder) putByte(v byte) {
+  enc.record.WriteByte(v)
+}
+
+func (enc *binaryEncoder) putFloat(f Float) {
+  if FLOAT_BITS == 32 {
+    binary.LittleEndian.PutUint32(enc.scratch[0:4],
+      math.Float32bits(float32(f)))
+    enc.record.Write(enc.scratch[0:4])
+    return
+  }
+  binary.LittleEndian.PutUint64(enc.scratch[0:8],
+    math.Float64bits(f.Float64()))
+  enc.record.Write(enc.scratch[0:8])
+}
+
+func (enc *binaryEncoder) putVect(v Vect) {
+  enc.putFloat(v.X)
+  enc.putFloat(v.Y)
+}
+
+// Reads a scene record by record, the opposite of binaryEncoder.
+type binaryDecoder struct {
+  r io.Reader
+  crc hash.Hash32
+  record []byte
+  pos int
+  scratch [8]byte
+  err os.Error
+  // Size of the floats in the file.
+  floatBits uint32
+}
+
+func (dec *binaryDecoder) read(data []byte) {
+  if dec.err != nil { return }
+  _, dec.err = io.ReadFull(dec.r, data)
+  if dec.err == nil {
+    dec.crc.Write(data)
+  }
+}
+
+func (dec *binaryDecoder) readUint32() (uint32) {
+  dec.read(dec.scratch[0:4])
+  if dec.err != nil { return 0 }
+  return binary.LittleEndian.Uint32(dec.scratch[0:4])
+}
+
+func (dec *binaryDecoder) section() (string, int) {
+  tag := make([]byte, 4)
+  dec.read(tag)
+  count := dec.readUint32()
+  if count > binaryMaxRecord {
+    dec.fail("too many records in section " + string(tag))
+  }
+  return string(tag), int(count)
+}
+
+func (dec *binaryDecoder) fail(message string) {
+  if dec.err == nil {
+    dec.err = os.NewError("scene: " + message)
+  }
+}
+
+// Reads the next record, which the get methods then take fields from.
+func (dec *binaryDecoder) nextRecord() {
+  size := dec.readUint32()
+  if size > binaryMaxRecord {
+    dec.fail("record too long")
+    return
+  }
+  if uint32(cap(dec.record)) < size {
+    dec.record = make([]byte, size)
+  }
+  dec.record = dec.record[0:size]
+  dec.pos    = 0
+  dec.read(dec.record)
+}
+
+// Returns the next n bytes of the record, or nil past its end.
+func (dec *binaryDecoder) field(n int) ([]byte) {
+  if dec.err != nil || dec.pos + n > len(dec.record) { return nil }
+  data   := dec.record[dec.pos:dec.pos + n]
+  dec.pos += n
+  return data
+}
+
+func (dec *binaryDecoder) getUint32() (uint32) {
+  data := dec.field(4)
+  if data == nil { return 0 }
+  return binary.LittleEndian.Uint32(data)
+}
+
+func (dec *binaryDecoder) getInt() (int) {
+  return int(int32(dec.getUint32()))
+}
+
+func (dec *binaryDecoder) getHash() (HashValue) {
+  data := dec.field(8)
+  if data == nil { return 0 }
+  return HashValue(binary.LittleEndian.Uint64(data))
+}
+
+func (dec *binaryDecoder) getByte() (byte) {
+  data := dec.field(1)
+  if data == nil { return 0 }
+  return data[0]
+}
+
+func (dec *binaryDecoder) getFloat() (Float) {
+  if dec.floatBits == 32 {
+    data := dec.field(4)
+    if data == nil { return 0 }
+    return Float(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+  }
+  data := dec.field(8)
+  if data == nil { return 0 }
+  return F64Float(math.Float64frombits(binary.LittleEndian.Uint64(data)))
+}
+
+func (dec *binaryDecoder) getVect() (Vect) {
+  x := dec.getFloat()
+  y := dec.getFloat()
+  return V(x, y)
+}
+
+// Bits of the flags of the space, bodies, shapes and arbiters.
+const (
+  binaryStatic = 1 << iota
+  binarySensor
+  // Since version 2.
+  binaryOneSided
+  // Since version 5.
+  binaryDeterministic
+  binarySwapped
+)
+
+func (enc *binaryEncoder) header() {
+  enc.write([]byte(binaryMagic))
+  enc.writeUint32(BINARY_VERSION)
+  enc.writeUint32(BINARY_COMPAT_VERSION)
+  enc.writeUint32(FLOAT_BITS)
+}
+
+func (enc *binaryEncoder) settings(scene *Scene) {
+  enc.section(binarySpace, 1)
+  enc.putVect(scene.Gravity)
+  enc.putFloat(scene.Damping)
+  enc.putInt(scene.Iterations)
+  enc.putInt(scene.ElasticIterations)
+  // Since version 5.
+  flags := byte(0)
+  if scene.Deterministic { flags |= binaryDeterministic }
+  enc.putInt(scene.Stamp)
+  enc.putByte(flags)
+  enc.endRecord()
+}
+
+func (enc *binaryEncoder) material(m *SceneMaterial) {
+  enc.putFloat(m.Friction)
+  enc.putFloat(m.Elasticity)
+  enc.putVect(m.SurfaceV)
+  enc.putFloat(m.Density)
+  enc.endRecord()
+}
+
+func (enc *binaryEncoder) pairMaterial(p *ScenePairMaterial) {
+  enc.putInt(int(p.A))
+  enc.putInt(int(p.B))
+  enc.putInt(p.Material)
+  enc.endRecord()
+}
+
+func (enc *binaryEncoder) body(b *SceneBody) {
+  flags := byte(0)
+  if b.Static { flags |= binaryStatic }
+  enc.putByte(flags)
+  enc.putFloat(b.Mass)
+  enc.putFloat(b.Moment)
+  enc.putVect(b.Pos)
+  enc.putVect(b.Vel)
+  enc.putVect(b.Force)
+  enc.putFloat(b.Angle)
+  enc.putFloat(b.AngVel)
+  enc.putFloat(b.Torque)
+  enc.putFloat(b.VelLimit)
+  enc.putFloat(b.AngVelLimit)
+  // Since version 5.
+  enc.putVect(b.VelBias)
+  enc.putFloat(b.AngVelBias)
+  enc.endRecord()
+}
+
+func (enc *binaryEncoder) verts(verts []Vect) {
+  enc.putInt(len(verts))
+  for _, v := range verts {
+    enc.putVect(v)
+  }
+}
+
+func (enc *binaryEncoder) floats(floats []Float) {
+  enc.putInt(len(floats))
+  for _, f := range floats {
+    enc.putFloat(f)
+  }
+}
+
+func (enc *binaryEncoder) shape(s *SceneShape) {
+  flags := byte(0)
+  if s.Static { flags |= binaryStatic }
+  if s.Sensor { flags |= binarySensor }
+  if s.OneSided { flags |= binaryOneSided }
+  enc.putByte(byte(binaryKindCode(binaryShapeKinds, s.Kind)))
+  enc.putByte(flags)
+  enc.putInt(s.Body)
+  enc.putFloat(s.Elasticity)
+  enc.putFloat(s.Friction)
+  enc.putVect(s.SurfaceV)
+  enc.putInt(int(s.CollisionType))
+  enc.putInt(int(s.Group))
+  enc.putInt(int(s.Layers))
+  switch s.Kind {
+    case "circle":
+      enc.putFloat(s.Radius)
+      enc.putVect(s.Offset)
+    case "segment":
+      enc.putFloat(s.Radius)
+      enc.putVect(s.A)
+      enc.putVect(s.B)
+      enc.putVect(s.ATangent)
+      enc.putVect(s.BTangent)
+    case "poly":
+      enc.putFloat(s.Radius)
+      enc.verts(s.Verts)
+    case "heightfield":
+      enc.verts(s.Verts)
+  }
+  // Since version 3.
+  enc.putVect(s.OneWay)
+  // Since version 4.
+  enc.putInt(s.Material)
+  // Since version 5.
+  enc.putHash(s.HashId)
+  enc.endRecord()
+}
+
+func (enc *binaryEncoder) constraint(c *SceneConstraint) {
+  enc.putByte(byte(binaryKindCode(binaryConstraintKinds, c.Kind)))
+  enc.putInt(c.A)
+  enc.putInt(c.B)
+  enc.putFloat(c.MaxForce)
+  enc.putFloat(c.BiasCoef)
+  enc.putFloat(c.MaxBias)
+  switch c.Kind {
+    case "damped_rotary_spring":
+      enc.putFloat(c.RestAngle)
+      enc.putFloat(c.Stiffness)
+      enc.putFloat(c.Damping)
+    case "damped_spring":
+      enc.putVect(c.Anchr1)
+      enc.putVect(c.Anchr2)
+      enc.putFloat(c.RestLength)
+      enc.putFloat(c.Stiffness)
+      enc.putFloat(c.Damping)
+    case "gear":
+      enc.putFloat(c.Phase)
+      enc.putFloat(c.Ratio)
+    case "groove":
+      enc.putVect(c.GrooveA)
+      enc.putVect(c.GrooveB)
+      enc.putVect(c.Anchr2)
+    case "pin":
+      enc.putVect(c.Anchr1)
+      enc.putVect(c.Anchr2)
+      enc.putFloat(c.Dist)
+    case "pivot":
+      enc.putVect(c.Anchr1)
+      enc.putVect(c.Anchr2)
+    case "ratchet":
+      enc.putFloat(c.Phase)
+      enc.putFloat(c.Ratchet)
+      enc.putFloat(c.Angle)
+    case "rotary_limit":
+      enc.putFloat(c.Min)
+      enc.putFloat(c.Max)
+    case "simple_motor":
+      enc.putFloat(c.Rate)
+    case "slide":
+      enc.putVect(c.Anchr1)
+      enc.putVect(c.Anchr2)
+      enc.putFloat(c.Min)
+      enc.putFloat(c.Max)
+  }
+  // Since version 5.
+  enc.floats(c.Impulses)
+  enc.endRecord()
+}
+
+func (enc *binaryEncoder) arbiter(a *SceneArbiter) {
+  flags := byte(0)
+  if a.Swapped { flags |= binarySwapped }
+  enc.putInt(a.A)
+  enc.putInt(a.B)
+  enc.putInt(a.Stamp)
+  enc.putByte(byte(a.State))
+  enc.putByte(flags)
+  enc.putFloat(a.Elasticity)
+  enc.putFloat(a.Friction)
+  enc.putVect(a.SurfaceV)
+  enc.putInt(len(a.Contacts))
+  for i:=0; i < len(a.Contacts); i++ {
+    c := &a.Contacts[i]
+    enc.putVect(c.Pos)
+    enc.putVect(c.Normal)
+    enc.putFloat(c.Dist)
+    enc.putFloat(c.NormalImpulse)
+    enc.putFloat(c.TangentImpulse)
+    enc.putHash(c.Hash)
+  }
+  enc.endRecord()
+}
+
+func (enc *binaryEncoder) end() (os.Error) {
+  enc.section(binaryEnd, 0)
+  sum := enc.crc.Sum32()
+  enc.writeUint32(sum)
+  return enc.err
+}
+
+// WriteBinary writes the scene in the binary format to w.
+func (scene *Scene) WriteBinary(w io.Writer) (os.Error) {
+  enc := newBinaryEncoder(w)
+  enc.header()
+  enc.settings(scene)
+  enc.section(binaryMaterials, len(scene.Materials))
+  for i:=0; i < len(scene.Materials); i++ {
+    enc.material(&scene.Materials[i])
+  }
+  enc.section(binaryPairs, len(scene.PairMaterials))
+  for i:=0; i < len(scene.PairMaterials); i++ {
+    enc.pairMaterial(&scene.PairMaterials[i])
+  }
+  enc.section(binaryBodies, len(scene.Bodies))
+  for i:=0; i < len(scene.Bodies); i++ {
+    enc.body(&scene.Bodies[i])
+  }
+  enc.section(binaryShapes, len(scene.Shapes))
+  for i:=0; i < len(scene.Shapes); i++ {
+    enc.shape(&scene.Shapes[i])
+  }
+  enc.section(binaryConstraints, len(scene.Constraints))
+  for i:=0; i < len(scene.Constraints); i++ {
+    enc.constraint(&scene.Constraints[i])
+  }
+  enc.section(binaryArbiters, len(scene.Arbiters))
+  for i:=0; i < len(scene.Arbiters); i++ {
+    enc.arbiter(&scene.Arbiters[i])
+  }
+  return enc.end()
+}
+
+// WriteBinary saves the contents of the space in the binary format to w.
+// Each part of the space is described and written in turn.
+func (space *Space) WriteBinary(w io.Writer) (os.Error) {
+  builder := newSceneBuilder(space)
+  enc     := newBinaryEncoder(w)
+  enc.header()
+  enc.settings(sceneSettings(space))
+  enc.section(binaryMaterials, len(builder.materials))
+  for i:=1; i <= len(builder.materials) && enc.err == nil; i++ {
+    m := builder.material(i)
+    enc.material(&m)
+  }
+  enc.section(binaryPairs, len(builder.pairs))
+  for i:=0; i < len(builder.pairs) && enc.err == nil; i++ {
+    p := builder.pairMaterial(i)
+    enc.pairMaterial(&p)
+  }
+  enc.section(binaryBodies, len(builder.bodies))
+  for i:=0; i < len(builder.bodies) && enc.err == nil; i++ {
+    b := builder.body(i)
+    enc.body(&b)
+  }
+  enc.section(binaryShapes, len(builder.shapes))
+  for i:=0; i < len(builder.shapes) && enc.err == nil; i++ {
+    s := builder.shape(i)
+    enc.shape(&s)
+  }
+  enc.section(binaryConstraints, len(builder.constraints))
+  for i:=0; i < len(builder.constraints) && enc.err == nil; i++ {
+    c := builder.constraint(i)
+    enc.constraint(&c)
+  }
+  enc.section(binaryArbiters, len(builder.arbiters))
+  for i:=0; i < len(builder.arbiters) && enc.err == nil; i++ {
+    a := builder.arbiter(i)
+    enc.arbiter(&a)
+  }
+  return enc.end()
+}
+
+// Reads a binary scene from r, and hands each part to reader as soon as
+// it is read.
+func readBinary(r io.Reader, reader sceneReader) (os.Error) {
+  dec   := &binaryDecoder{r: r, crc: crc32.NewIEEE()}
+  magic := make([]byte, 4)
+  dec.read(magic)
+  if dec.err == nil && string(magic) != binaryMagic {
+    dec.fail("not a binary scene")
+  }
+  version      := dec.readUint32()
+  compat       := dec.readUint32()
+  dec.floatBits = dec.readUint32()
+  if dec.err != nil { return dec.err }
+  if compat > BINARY_VERSION {
+    return sceneError("unsupported binary version", int(version))
+  }
+  if dec.floatBits != 32 && dec.floatBits != 64 {
+    return sceneError("unsupported float size", int(dec.floatBits))
+  }
+
+  for dec.err == nil {
+    tag, count := dec.section()
+    if dec.err != nil || tag == binaryEnd { break }
+    for i:=0; i < count && dec.err == nil; i++ {
+      dec.nextRecord()
+      var err os.Error
+      switch tag {
+        case binarySpace:
+          settings                  := &Scene{}
+          settings.Version           = int(version)
+          if version > SCENE_VERSION {
+            settings.Version = SCENE_VERSION
+          }
+          settings.Gravity           = dec.getVect()
+          settings.Damping           = dec.getFloat()
+          settings.Iterations        = dec.getInt()
+          settings.ElasticIterations = dec.getInt()
+          settings.Stamp             = dec.getInt()
+          settings.Deterministic     = dec.getByte() & binaryDeterministic != 0
+          err = reader.readSettings(settings)
+        case binaryMaterials:
+          m  := dec.material(i + 1)
+          err = reader.readMaterial(&m)
+        case binaryPairs:
+          p  := dec.pairMaterial()
+          err = reader.readPairMaterial(&p)
+        case binaryBodies:
+          b  := dec.body(i)
+          err = reader.readBody(&b)
+        case binaryShapes:
+          s  := dec.shape(i)
+          err = reader.readShape(&s)
+        case binaryConstraints:
+          c  := dec.constraint(i)
+          err = reader.readConstraint(&c)
+        case binaryArbiters:
+          a  := dec.arbiter()
+          err = reader.readArbiter(&a)
+        default:
+          // Section of a newer version, skip it.
+      }
+      // Check the record before using what was read from it.
+      if dec.err != nil { return dec.err }
+      if err != nil { return err }
+    }
+  }
+  if dec.err != nil { return dec.err }
+
+  expected := dec.crc.Sum32()
+  sum      := dec.readUint32()
+  if dec.err != nil { return dec.err }
+  if sum != expected { return os.NewError("scene: checksum mismatch") }
+  return nil
+}
+
+// ReadSceneBinary reads a scene in the binary format from r.
+func ReadSceneBinary(r io.Reader) (*Scene, os.Error) {
+  scene := &Scene{Version: SCENE_VERSION}
+  err   := readBinary(r, scene)
+  if err != nil { return nil, err }
+  return scene, nil
+}
+
+func (dec *binaryDecoder) material(id int) (SceneMaterial) {
+  m           := SceneMaterial{Id: id}
+  m.Friction   = dec.getFloat()
+  m.Elasticity = dec.getFloat()
+  m.SurfaceV   = dec.getVect()
+  m.Density    = dec.getFloat()
+  return m
+}
+
+func (dec *binaryDecoder) pairMaterial() (ScenePairMaterial) {
+  p         := ScenePairMaterial{}
+  p.A        = CollisionType(dec.getInt())
+  p.B        = CollisionType(dec.getInt())
+  p.Material = dec.getInt()
+  return p
+}
+
+func (dec *binaryDecoder) body(id int) (SceneBody) {
+  b            := SceneBody{Id: id}
+  b.Static      = dec.getByte() & binaryStatic != 0
+  b.Mass        = dec.getFloat()
+  b.Moment      = dec.getFloat()
+  b.Pos         = dec.getVect()
+  b.Vel         = dec.getVect()
+  b.Force       = dec.getVect()
+  b.Angle       = dec.getFloat()
+  b.AngVel      = dec.getFloat()
+  b.Torque      = dec.getFloat()
+  b.VelLimit    = dec.getFloat()
+  b.AngVelLimit = dec.getFloat()
+  b.VelBias     = dec.getVect()
+  b.AngVelBias  = dec.getFloat()
+  return b
+}
+
+func (dec *binaryDecoder) verts() ([]Vect) {
+  numVerts := dec.getInt()
+  // Each vertex takes two floats, which bounds the count for corrupt files.
+  size := int(dec.floatBits / 4)
+  if numVerts < 0 || numVerts * size > len(dec.record) - dec.pos {
+    dec.fail("bad vertex count")
+    return nil
+  }
+  verts := make([]Vect, numVerts)
+  for i:=0; i < numVerts; i++ {
+    verts[i] = dec.getVect()
+  }
+  return verts
+}
+
+func (dec *binaryDecoder) floats() ([]Float) {
+  numFloats := dec.getInt()
+  if numFloats == 0 { return nil }
+  size := int(dec.floatBits / 8)
+  if numFloats < 0 || numFloats * size > len(dec.record) - dec.pos {
+    dec.fail("bad float count")
+    return nil
+  }
+  floats := make([]Float, numFloats)
+  for i:=0; i < numFloats; i++ {
+    floats[i] = dec.getFloat()
+  }
+  return floats
+}
+
+func (dec *binaryDecoder) shape(id int) (SceneShape) {
+  s              := SceneShape{Id: id}
+  s.Kind          = binaryKind(binaryShapeKinds, uint32(dec.getByte()))
+  flags          := dec.getByte()
+  s.Static        = flags & binaryStatic != 0
+  s.Sensor        = flags & binarySensor != 0
+  s.OneSided      = flags & binaryOneSided != 0
+  s.Body          = dec.getInt()
+  s.Elasticity    = dec.getFloat()
+  s.Friction      = dec.getFloat()
+  s.SurfaceV      = dec.getVect()
+  s.CollisionType = CollisionType(dec.getInt())
+  s.Group         = GroupType(dec.getInt())
+  s.Layers        = LayerType(dec.getInt())
+  switch s.Kind {
+    case "circle":
+      s.Radius = dec.getFloat()
+      s.Offset = dec.getVect()
+    case "segment":
+      s.Radius   = dec.getFloat()
+      s.A        = dec.getVect()
+      s.B        = dec.getVect()
+      s.ATangent = dec.getVect()
+      s.BTangent = dec.getVect()
+    case "poly":
+      s.Radius = dec.getFloat()
+      s.Verts  = dec.verts()
+    case "heightfield":
+      s.Verts  = dec.verts()
+  }
+  s.OneWay   = dec.getVect()
+  s.Material = dec.getInt()
+  s.HashId   = dec.getHash()
+  return s
+}
+
+func (dec *binaryDecoder) constraint(id int) (SceneConstraint) {
+  c         := SceneConstraint{Id: id}
+  c.Kind     = binaryKind(binaryConstraintKinds, uint32(dec.getByte()))
+  c.A        = dec.getInt()
+  c.B        = dec.getInt()
+  c.MaxForce = dec.getFloat()
+  c.BiasCoef = dec.getFloat()
+  c.MaxBias  = dec.getFloat()
+  switch c.Kind {
+    case "damped_rotary_spring":
+      c.RestAngle  = dec.getFloat()
+      c.Stiffness  = dec.getFloat()
+      c.Damping    = dec.getFloat()
+    case "damped_spring":
+      c.Anchr1     = dec.getVect()
+      c.Anchr2     = dec.getVect()
+      c.RestLength = dec.getFloat()
+      c.Stiffness  = dec.getFloat()
+      c.Damping    = dec.getFloat()
+    case "gear":
+      c.Phase      = dec.getFloat()
+      c.Ratio      = dec.getFloat()
+    case "groove":
+      c.GrooveA    = dec.getVect()
+      c.GrooveB    = dec.getVect()
+      c.Anchr2     = dec.getVect()
+    case "pin":
+      c.Anchr1     = dec.getVect()
+      c.Anchr2     = dec.getVect()
+      c.Dist       = dec.getFloat()
+    case "pivot":
+      c.Anchr1     = dec.getVect()
+      c.Anchr2     = dec.getVect()
+    case "ratchet":
+      c.Phase      = dec.getFloat()
+      c.Ratchet    = dec.getFloat()
+      c.Angle      = dec.getFloat()
+    case "rotary_limit":
+      c.Min        = dec.getFloat()
+      c.Max        = dec.getFloat()
+    case "simple_motor":
+      c.Rate       = dec.getFloat()
+    case "slide":
+      c.Anchr1     = dec.getVect()
+      c.Anchr2     = dec.getVect()
+      c.Min        = dec.getFloat()
+      c.Max        = dec.getFloat()
+  }
+  c.Impulses = dec.floats()
+  return c
+}
+
+func (dec *binaryDecoder) arbiter() (SceneArbiter) {
+  a           := SceneArbiter{}
+  a.A          = dec.getInt()
+  a.B          = dec.getInt()
+  a.Stamp      = dec.getInt()
+  a.State      = ArbiterState(dec.getByte())
+  a.Swapped    = dec.getByte() & binarySwapped != 0
+  a.Elasticity = dec.getFloat()
+  a.Friction   = dec.getFloat()
+  a.SurfaceV   = dec.getVect()
+  numContacts := dec.getInt()
+  // Each contact takes 7 floats and a hash, which bounds the count.
+  size := int(dec.floatBits / 8) * 7 + 8
+  if numContacts < 0 || numContacts * size > len(dec.record) - dec.pos {
+    dec.fail("bad contact count")
+    return a
+  }
+  a.Contacts = make([]SceneContact, numContacts)
+  for i:=0; i < numContacts; i++ {
+    c               := &a.Contacts[i]
+    c.Pos            = dec.getVect()
+    c.Normal         = dec.getVect()
+    c.Dist           = dec.getFloat()
+    c.NormalImpulse  = dec.getFloat()
+    c.TangentImpulse = dec.getFloat()
+    c.Hash           = dec.getHash()
+  }
+  return a
+}
+
+// ReadBinary loads a space that WriteBinary saved from r. Each part is
+// added to the space as soon as it is read.
+func ReadBinary(r io.Reader) (*Space, os.Error) {
+  loader := newSceneLoader()
+  err    := readBinary(r, loader)
+  if err != nil { return nil, err }
+  return loader.space, nil
+}
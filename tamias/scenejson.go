@@ -0,0 +1,56 @@
+package tamias
+
+// Saving and loading spaces as JSON documents.
+
+import "io"
+import "io/ioutil"
+import "json"
+import "math"
+import "os"
+
+// JSON has no infinity, but many limits of bodies and constraints default
+// to INFINITY. Floats are encoded as numbers, and infinities as the strings
+// "inf" and "-inf".
+func (f Float) MarshalJSON() ([]byte, os.Error) {
+  if math.IsInf(f.Float64(), 1) {
+    return []byte(`"inf"`), nil
+  } else if math.IsInf(f.Float64(), -1) {
+    return []byte(`"-inf"`), nil
+  }
+  return json.Marshal(f.Float64())
+}
+
+func (f *Float) UnmarshalJSON(data []byte) (os.Error) {
+  switch string(data) {
+    case `"inf"`:
+      *f = INFINITY
+      return nil
+    case `"-inf"`:
+      *f = -INFINITY
+      return nil
+  }
+  var value float64
+  err := json.Unmarshal(data, &value)
+  if err != nil { return err }
+  *f = F64Float(value)
+  return nil
+}
+
+// WriteJSON saves the contents of the space as a JSON document to w.
+func (space *Space) WriteJSON(w io.Writer) (os.Error) {
+  data, err := json.Marshal(SceneFromSpace(space))
+  if err != nil { return err }
+  _, err = w.Write(data)
+  return err
+}
+
+// ReadJSON loads a JSON document that WriteJSON saved from r, and returns
+// a new space with its contents.
+func ReadJSON(r io.Reader) (*Space, os.Error) {
+  data, err := ioutil.ReadAll(r)
+  if err != nil { return nil, err }
+  scene := &Scene{}
+  err    = json.Unmarshal(data, scene)
+  if err != nil { return nil, err }
+  return scene.Space()
+}
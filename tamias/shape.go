@@ -21,7 +21,8 @@ const (
   CIRCLE_SHAPE	= ShapeType(0)
   SEGMENT_SHAPE	= ShapeType(1)
   POLY_SHAPE		= ShapeType(2)
-  NUM_SHAPES		= ShapeType(3)
+  HEIGHTFIELD_SHAPE = ShapeType(3)
+  NUM_SHAPES		= ShapeType(4)
   NO_GROUP		  = GroupType(0)  
 )  
 
@@ -53,6 +54,14 @@ type ShapeClass struct {
   Type ShapeType
 }
 
+// ShapeGeometry is implemented by the concrete shapes that embed a Shape. 
+// A Shape uses it to call the right implementation, like the function 
+// pointers in the shape class of Chipmunk.
+type ShapeGeometry interface {
+  CacheBB(p, rot Vect) (BB)
+  PointQuery(p Vect) (bool)
+}
+
 // Basic shape struct that the others inherit from.
 type Shape struct {
   // The "class" of a shape as defined above 
@@ -70,6 +79,11 @@ type Shape struct {
   u Float;
   // Surface velocity used when solving for friction.
   surface_v Vect;
+  // Material that overrides the surface properties, or nil.
+  material *Material
+  // Direction that a one-way shape collides in, or zero if it collides in
+  // every direction. (body space coordinates)
+  oneWay Vect
   // *** User Definable Fields
   // User defined data pointer for the shape.
   data DataPointer
@@ -82,6 +96,8 @@ type Shape struct {
   // *** Internally Used Fields
   // Unique id used as the hash value.
   hashid HashValue
+  // The concrete shape that embeds this shape.
+  geometry ShapeGeometry
 }
 
 // Circle shape structure.
@@ -104,6 +120,13 @@ type SegmentShape struct {
   r Float
   // Transformed endpoints and normal. (world space coordinates)
   ta, tb, tn Vect
+  // Directions from the endpoints to the neighbouring vertexes of a chain,
+  // or zero if there are none. (body space coordinates)
+  aTangent, bTangent Vect
+  // Transformed directions to the neighbouring vertexes.
+  taTangent, tbTangent Vect
+  // Only collides on the side that the normal points to.
+  oneSided bool
 } 
 
 // Returns true if a shape was hit, false if not
@@ -147,14 +170,60 @@ func (shape * Shape) Init(klass *ShapeClass, body *Body) (*Shape){
 	return shape;
 }
 
+// SetOneWay makes the shape collide only with shapes that touch it from
+// the direction n, in body space, like a platform that can be jumped
+// through from below. Shapes that first touch it from any other direction
+// pass through until they are apart again. A zero n makes the shape
+// collide in every direction.
+func (shape * Shape) SetOneWay(n Vect) {
+  shape.oneWay = n
+  if n != VZERO { shape.oneWay = n.Normalize() }
+}
+
+func (shape * Shape) OneWay() (Vect) {
+  return shape.oneWay
+}
+
+// SetCollisionType sets the type that collision handlers and pair
+// materials are looked up by.
+func (shape * Shape) SetCollisionType(t CollisionType) {
+  shape.collision_type = t
+}
+
+func (shape * Shape) CollisionType() (CollisionType) {
+  return shape.collision_type
+}
+
 func ShapeNew(klass *ShapeClass, body *Body) (*Shape) {
   return new(Shape).Init(klass, body)
 }
 
 func (shape * Shape) CacheBB(p Vect, rot Vect) (BB) {
+  if shape.geometry != nil { 
+    return shape.geometry.CacheBB(p, rot)
+  }
   return BBMake(p.X, p.Y, p.X, p.Y)
 }
 
+func (shape * Shape) PointQuery(p Vect) (bool) {
+  if shape.geometry != nil { 
+    return shape.geometry.PointQuery(p)
+  }
+  return false
+}
+
+// Equality function, for the spatial hash.
+func (shape * Shape) Equals(el interface {}) (bool) {
+  other, ok := el.(*Shape)
+  if !ok { return false; }
+  return shape == other
+}
+
+// Returns the concrete shape, a *CircleShape, *SegmentShape or *PolyShape.
+func (shape * Shape) Geometry() (ShapeGeometry) {
+  return shape.geometry
+}
+
 func (shape * Shape) GetBB() (*BB) {
   return shape.BB
 }
@@ -211,7 +280,7 @@ func CircleShapeAlloc() (* CircleShape) {
 
  
 func bbFromCircle(c Vect, r Float) (BB) {
-	return BBMake(c.X-r, c.Y-r, c.X+r, c.Y+r);
+	return BBMake(c.X-r, c.Y+r, c.X+r, c.Y-r);
 }
 
 func (circle * CircleShape) CacheBB(p Vect, rot Vect) (BB) {
@@ -241,7 +310,7 @@ func CircleSegmentQuery(shape *Shape, center Vect, r Float, a, b Vect) (info * S
 		if Float(0.0) <= t && t <= Float(1.0) { 
 			info.shape = shape
 			info.t = t
-			info.n = a.Lerp(b, t).Normalize()
+			info.n = a.Lerp(b, t).Sub(center).Normalize()
 		}
 	}
   return info 
@@ -258,7 +327,8 @@ var CircleShapeClass *ShapeClass = &ShapeClass{ CIRCLE_SHAPE }
 func (circle * CircleShape) Init(body * Body, radius Float, offset Vect) (* CircleShape) {
 	circle.c = offset;
 	circle.r = radius;	
-	circle.Shape.Init(CircleShapeClass, body);	
+	circle.Shape = ShapeNew(CircleShapeClass, body)
+	circle.Shape.geometry = circle
 	return circle;
 }
 
@@ -284,7 +354,9 @@ func (seg * SegmentShape) CacheBB(p, rot Vect) (BB) {
   
   seg.ta = p.Add(seg.a.Rotate(rot));
   seg.tb = p.Add(seg.b.Rotate(rot));
-  seg.tn = p.Add(seg.n.Rotate(rot));
+  seg.tn = seg.n.Rotate(rot);
+  seg.taTangent = seg.aTangent.Rotate(rot)
+  seg.tbTangent = seg.bTangent.Rotate(rot)
     
   if(seg.ta.X < seg.tb.X){
     l = seg.ta.X
@@ -303,7 +375,7 @@ func (seg * SegmentShape) CacheBB(p, rot Vect) (BB) {
   }
   
   rad := seg.r
-  return BBMake(l - rad, s - rad, r + rad, t + rad)
+  return BBMake(l - rad, t + rad, r + rad, s - rad)
 }
 
 func (seg * SegmentShape) PointQuery(p Vect) (bool) {
@@ -402,7 +474,8 @@ func (seg *SegmentShape) Init(body * Body, a, b Vect, r Float) (*SegmentShape) {
   seg.b = b
   seg.n = b.Sub(a).Normalize().Perp()
   seg.r = r;  
-  seg.Shape.Init(SegmentShapeClass, body)  
+  seg.Shape = ShapeNew(SegmentShapeClass, body)
+  seg.Shape.geometry = seg
   return seg;
 }
 
@@ -0,0 +1,212 @@
+package tamias
+
+// Snapshots of the state of a space, for rollback networking.
+// A snapshot only stores the state that changes while stepping, and
+// refers to the bodies, shapes and constraints of the space. It can only
+// be restored into the space it was taken from, and only while the same
+// bodies and constraints are in it.
+
+import "sort"
+
+// State of a body. Bodies can't sleep yet, so there is no sleep state.
+type BodySnapshot struct {
+  body *Body
+  p, v, f, rot, v_bias Vect
+  a, w, t, w_bias Float
+}
+
+// State of an arbiter, with copies of its contacts.
+type ArbiterSnapshot struct {
+  key pairKey
+  a, b *Shape
+  handler *CollisionHandler
+  contacts []Contact
+  e, u Float
+  surface_vr Vect
+  stamp int
+  swappedColl bool
+  state ArbiterState
+}
+
+type Snapshot struct {
+  stamp int
+  bodies []BodySnapshot
+  arbiters []ArbiterSnapshot
+  // The arbiters that were active during the last step, in order.
+  active []pairKey
+  constraints []Float
+}
+
+func (body *Body) snapshot() (BodySnapshot) {
+  return BodySnapshot{body, body.p, body.v, body.f, body.rot, body.v_bias,
+    body.a, body.w, body.t, body.w_bias}
+}
+
+func (snap *BodySnapshot) restore() {
+  body       := snap.body
+  body.p      = snap.p
+  body.v      = snap.v
+  body.f      = snap.f
+  body.rot    = snap.rot
+  body.v_bias = snap.v_bias
+  body.a      = snap.a
+  body.w      = snap.w
+  body.t      = snap.t
+  body.w_bias = snap.w_bias
+}
+
+func (arb *Arbiter) snapshot(key pairKey) (ArbiterSnapshot) {
+  contacts := make([]Contact, arb.numContacts)
+  copy(contacts, arb.contacts[0:arb.numContacts])
+  return ArbiterSnapshot{key, arb.private_a, arb.private_b, arb.handler,
+    contacts, arb.e, arb.u, arb.surface_vr, arb.stamp, arb.swappedColl,
+    arb.state}
+}
+
+// Puts the state of the snapshot in the arbiter. The arbiter gets its own
+// copy of the contacts, since the snapshot may be restored again later.
+func (snap *ArbiterSnapshot) restore(arb *Arbiter) {
+  arb.Init(snap.a, snap.b)
+  arb.contacts    = make([]Contact, len(snap.contacts))
+  copy(arb.contacts, snap.contacts)
+  arb.numContacts = len(snap.contacts)
+  arb.handler     = snap.handler
+  arb.e           = snap.e
+  arb.u           = snap.u
+  arb.surface_vr  = snap.surface_vr
+  arb.stamp       = snap.stamp
+  arb.swappedColl = snap.swappedColl
+  arb.state       = snap.state
+}
+
+func (snap *ArbiterSnapshot) Equals(other *ArbiterSnapshot) (bool) {
+  if snap.key != other.key || snap.a != other.a || snap.b != other.b ||
+     snap.handler != other.handler || snap.e != other.e ||
+     snap.u != other.u || snap.surface_vr != other.surface_vr ||
+     snap.stamp != other.stamp || snap.swappedColl != other.swappedColl ||
+     snap.state != other.state || len(snap.contacts) != len(other.contacts) {
+    return false
+  }
+  for i, con := range snap.contacts {
+    if con != other.contacts[i] { return false }
+  }
+  return true
+}
+
+// Snapshot returns the state of all bodies in the space, the arbiters
+// with their cached impulses, and the accumulated impulses of the
+// constraints. Should not be called during Step.
+func (space *Space) Snapshot() (*Snapshot) {
+  Assert(space.locked == 0, "Cannot take a snapshot during Step().")
+  snap       := &Snapshot{}
+  snap.stamp  = space.stamp
+
+  snap.bodies = make([]BodySnapshot, space.bodies.Size())
+  for i:=0; i < space.bodies.Size(); i++ {
+    snap.bodies[i] = space.bodies.Index(i).(*Body).snapshot()
+  }
+
+  // Sorted, so snapshots of identical spaces are equal.
+  keys         := space.contactSetKeys()
+  sort.Sort(pairKeyOrder(keys))
+  snap.arbiters = make([]ArbiterSnapshot, len(keys))
+  for i, key := range keys {
+    snap.arbiters[i] = space.contactSet[key].snapshot(key)
+  }
+  snap.active = make([]pairKey, space.arbiters.Size())
+  for i:=0; i < space.arbiters.Size(); i++ {
+    arb           := space.arbiters.Index(i).(*Arbiter)
+    snap.active[i] = pairKeyNew(arb.private_a.hashid, arb.private_b.hashid)
+  }
+
+  snap.constraints = make([]Float, 0, space.constraints.Size())
+  for i:=0; i < space.constraints.Size(); i++ {
+    constraint      := space.constraints.Index(i).(Constraint)
+    snap.constraints = saveConstraintState(constraint, snap.constraints)
+  }
+  return snap
+}
+
+// Restore puts the space back in the state it was in when the snapshot
+// was taken. Stepping the space afterwards with the same input gives
+// exactly the same results as the first time.
+func (space *Space) Restore(snap *Snapshot) {
+  Assert(space.locked == 0, "Cannot restore a snapshot during Step().")
+  Assert(len(snap.bodies) == space.bodies.Size(),
+    "The bodies of the space changed since the snapshot was taken.")
+  space.stamp = snap.stamp
+
+  for i:=0; i < len(snap.bodies); i++ {
+    snap.bodies[i].restore()
+  }
+
+  // Recycle the current arbiters, and recreate the ones of the snapshot.
+  for _, key := range space.contactSetKeys() {
+    space.pooledArbiters.Push(space.contactSet[key])
+    space.contactSet[key] = nil, false
+  }
+  for i:=0; i < len(snap.arbiters); i++ {
+    arbsnap := &snap.arbiters[i]
+    arb     := space.getArbiter(arbsnap.a, arbsnap.b)
+    arbsnap.restore(arb)
+    space.contactSet[arbsnap.key] = arb
+  }
+  // The active arbiters go back in the lists of the space and the bodies.
+  space.clearBodyArbiters()
+  space.arbiters.num = 0
+  for _, key := range snap.active {
+    space.arbiters.Push(space.contactSet[key])
+  }
+  space.fillBodyArbiters()
+
+  space.resetContactBuffers()
+
+  state := snap.constraints
+  for i:=0; i < space.constraints.Size(); i++ {
+    state = loadConstraintState(space.constraints.Index(i).(Constraint),
+      state)
+  }
+}
+
+// Copy returns a copy of the snapshot that shares no memory with it.
+func (snap *Snapshot) Copy() (*Snapshot) {
+  result       := &Snapshot{}
+  result.stamp  = snap.stamp
+  result.bodies = make([]BodySnapshot, len(snap.bodies))
+  copy(result.bodies, snap.bodies)
+  result.arbiters = make([]ArbiterSnapshot, len(snap.arbiters))
+  for i, arbsnap := range snap.arbiters {
+    result.arbiters[i]          = arbsnap
+    result.arbiters[i].contacts = make([]Contact, len(arbsnap.contacts))
+    copy(result.arbiters[i].contacts, arbsnap.contacts)
+  }
+  result.active = make([]pairKey, len(snap.active))
+  copy(result.active, snap.active)
+  result.constraints = make([]Float, len(snap.constraints))
+  copy(result.constraints, snap.constraints)
+  return result
+}
+
+// Equals returns true if both snapshots describe exactly the same state.
+func (snap *Snapshot) Equals(other *Snapshot) (bool) {
+  if snap.stamp != other.stamp ||
+     len(snap.bodies) != len(other.bodies) ||
+     len(snap.arbiters) != len(other.arbiters) ||
+     len(snap.active) != len(other.active) ||
+     len(snap.constraints) != len(other.constraints) {
+    return false
+  }
+  for i, body := range snap.bodies {
+    if body != other.bodies[i] { return false }
+  }
+  for i:=0; i < len(snap.arbiters); i++ {
+    if !snap.arbiters[i].Equals(&other.arbiters[i]) { return false }
+  }
+  for i, key := range snap.active {
+    if key != other.active[i] { return false }
+  }
+  for i, f := range snap.constraints {
+    if f != other.constraints[i] { return false }
+  }
+  return true
+}
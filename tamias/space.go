@@ -17,15 +17,19 @@ type CollisionHandler struct {
 
 const CP_MAX_CONTACTS_PER_ARBITER = 6
 
+const CONTACTS_BUFFER_SIZE = 100
+
 type ContactBufferHeader struct {
   stamp       int
   next        * ContactBufferHeader
-  numContacts uint
+  numContacts int
+  // In Chipmunk the contacts follow the header in memory.
+  contacts    [CONTACTS_BUFFER_SIZE]Contact
 } 
 
-type CollisionFuncMap map[HashValue] CollisionFunc
+type CollisionFuncMap map[pairKey] *CollisionHandler
 
-type ContactMap map[HashValue] Contact
+type ContactMap map[pairKey] *Arbiter
 
 type Space struct {
   // *** User definable fields  
@@ -41,6 +45,25 @@ type Space struct {
   // Default damping to supply when integrating rigid body motions.
   Damping Float
   
+  // When true, Step processes the arbiters and the contact set in a stable
+  // order, so two spaces that get the same input produce bit-identical 
+  // results. Needed for lockstep networking. 
+  // The float math itself isn't ordered any more strictly than Go does it.
+  // The compiler may fuse multiplications and additions on some
+  // architectures and not on others, so only builds for the same
+  // architecture are sure to agree.
+  Deterministic bool
+  
+  // When true, Step measures how long each of its phases takes. See Stats.
+  Profile bool
+  
+  // Called after every step with its statistics, to export them.
+  StatsHook StatsFunc
+  
+  // Mix the elasticities and the frictions of two shapes that touch. nil
+  // multiplies them. Pair materials go first, see SetPairMaterial.
+  ElasticityMix, FrictionMix MixFunc
+  
   // *** Internally Used Fields  
   // When the space is locked, you should not add or remove objects;  
   locked int
@@ -49,8 +72,11 @@ type Space struct {
   stamp int
 
   // The static and active shape spatial hashes.
-  staticShapes SpaceHash
-  activeShapes SpaceHash
+  staticShapes *SpaceHash
+  activeShapes *SpaceHash
+  
+  // Materials of pairs of collision types.
+  pairMaterials map[pairKey]pairMaterial
   
   // List of bodies in the system.
   bodies *Array
@@ -78,10 +104,31 @@ type Space struct {
   // Default collision handler.
   defaultHandler CollisionHandler;
     
-  postStepCallbacks *HashSet;  
-  
+  // Callbacks to run when the current step is done, in the order they
+  // were added.
+  postStepCallbacks []postStepCallback
+  
+  // Statistics of the current or last step, and of the steps before it.
+  stats StepStats
+  statsHistory []StepStats
+  statsNext, statsCount int
 } 
 
+func AllocContactBufferHeader() (*ContactBufferHeader) {
+  return &ContactBufferHeader{}
+}
+
+func (header *ContactBufferHeader) Init(space * Space) (*ContactBufferHeader) {
+  header.stamp        = space.stamp
+  header.next         = space.contactBuffersTail
+  header.numContacts  = 0
+  return header
+}
+
+func ContactBufferHeaderNew(space * Space) (*ContactBufferHeader) {
+  return AllocContactBufferHeader().Init(space) 
+}  
+
 /*
 
 type contactSet [2]*Shape;
@@ -107,20 +154,7 @@ func (check * CollisionHandler) Equals(pairi interface {}) bool {
   if pair.b == check.a && pair.a == check.b { return true }
   return false  
 }
-
-
-type PostStepFunc func(obj, data interface{})
-
-type postStepCallback struct {
-  fun PostStepFunc
-  obj, data interface{}
-}
-
-func (a * postStepCallback) Equals(bi interface{}) bool { 
-  b, ok := arbi.(*CollisionHandler)
-  if !ok { return false; }
-  return a.obj == b.obj   
-}
+*/
 
 // Default collision functions.
 func alwaysCollide(arb * Arbiter, space * Space, data interface{}) (int) { 
@@ -131,33 +165,6 @@ func nothing(arb * Arbiter, space * Space, data interface{}) (int) {
   return 0;
 }
 
-func (shape * Shape) GetBB() (BB) {
-  return shape.BB
-}  
-
-const CONTACTS_BUFFER_SIZE = 100
-
-type ContactBuffer struct {
-  header ContactBufferHeader;
-  contacts [CONTACTS_BUFFER_SIZE]Contact;
-}
-
-func AllocContactBufferHeader() (*ContactBufferHeader) {
-  return &ContactBufferHeader{}
-}
-
-func (header *ContactBufferHeader) Init(space * Space) (*ContactBufferHeader) {
-  header.stamp        = space.stamp
-  header.next         = space.contactBuffersTail
-  header.numContacts  = 0
-  return header
-}
-
-func (header *ContactBufferHeader) ContactBufferHeaderNew(space * Space) (
-      *ContactBufferHeader) {
-  return AllocContactBufferHeader().Init(space) 
-}  
-
 func SpaceAlloc() (*Space) {
   return &Space{}  
 }
@@ -171,8 +178,8 @@ var DEFAULT_ELASTIC_ITERATIONS  = 0
 var defaultHandler = CollisionHandler{ 0, 0, alwaysCollide, alwaysCollide, nothing, nothing, nil};
 
 func (space *Space) Init() (*Space) {
-  space.iterations        = DEFAULT_ITERATIONS
-  space.elasticIterations = DEFAULT_ELASTIC_ITERATIONS
+  space.Iterations        = DEFAULT_ITERATIONS
+  space.ElasticIterations = DEFAULT_ELASTIC_ITERATIONS
   space.Gravity           = VZERO
   space.Damping           = Float(1.0)
   space.locked            = 0
@@ -187,16 +194,16 @@ func (space *Space) Init() (*Space) {
   space.contactBuffersHead= header
   header.next             = header 
   // set up ring buffer in cyclical way
-  space.contactSet        = make(ContactSet)  
+  space.contactSet        = make(ContactMap)  
   space.constraints       = ArrayNew(0)
   space.defaultHandler    = defaultHandler
   space.collFuncSet       = make(CollisionFuncMap)
-  space.postStepCallbacks = HashSetNew(0)
+  space.pairMaterials     = make(map[pairKey]pairMaterial)
   
   return space
 }  
 
-func (space *Space) New() (*Space) {
+func SpaceNew() (*Space) {
   return SpaceAlloc().Init()
 }
 
@@ -204,134 +211,201 @@ func (space *Space) New() (*Space) {
 func (space * Space) AddCollisionHandler(a, b CollisionType,
   begin, preSolve, postSolve, separate CollisionFunc, data interface{}) {
   // Remove any old function so the new one will get added.
-  space.RemoveCollisionHandler(space, a, b)
-  handler = CollisionHandler { a , b, begin, 
-            presolve, postSolve, separate , data } 
-  space.collFuncSet[HASH_PAIR(a, b)] = &handler
+  space.RemoveCollisionHandler(a, b)
+  handler := &CollisionHandler { a , b, begin, 
+            preSolve, postSolve, separate , data } 
+  space.collFuncSet[pairKeyNew(HashValue(a), HashValue(b))] = handler
 }
 
 func (space * Space)RemoveCollisionHandler(a, b CollisionType) {
-  space.collFuncSet[HASH_PAIR(a, b)] = nil, false
+  space.collFuncSet[pairKeyNew(HashValue(a), HashValue(b))] = nil, false
 }
 
 func (space * Space) SetDefaultHandler( a, b CollisionType, 
   begin, preSolve, postSolve, separate CollisionFunc, data interface{}) {
-  // Remove any old function so the new one will get added.
-  space.RemoveCollisionHandler(space, a, b)
-  handler = CollisionHandler { a , b, begin, 
-            presolve, postSolve, separate , data }             
-  space.defaultHandler = &handler
+  space.defaultHandler = CollisionHandler { a , b, begin, 
+            preSolve, postSolve, separate , data }             
 }
 
 
 func (space * Space) AssertUnlocked() {
-  Assert(!space.locked,  "This addition/removal cannot be done safely during a call to cpSpaceStep(). Put these calls into a Post Step Callback.")
+  Assert(space.locked == 0,  "This addition/removal cannot be done safely during a call to cpSpaceStep(). Put these calls into a Post Step Callback.")
 }
   
 func (space * Space) AddShape(shape * Shape) (* Shape) {
-  Assert(shape.body != nil, "Cannot add a shape with a nil body.")
-  old := space.activeShapes.Find(shape, shape.hashid)
-  Assert(old == nil, "Cannot add the same shape more than once")
+  Assert(shape.Body != nil, "Cannot add a shape with a nil body.")
+  Assert(!space.activeShapes.Contains(shape, shape.hashid), 
+    "Cannot add the same shape more than once")
   space.AssertUnlocked()
-  shape.CacheBB()
-  space.activeShapes.Insert(shape, shape.hashid, shape.bb)
+  updateBBCache(shape, nil)
+  space.activeShapes.Insert(shape, shape.hashid)
+  space.attachShape(shape)
   return shape
 }
   
 func (space * Space) AddStaticShape(shape * Shape) (* Shape) {
-  Assert(shape.body != nil, "Cannot add a static shape with a nil body.")
-  old := space.staticShapes.Find(shape, shape.hashid)
-  Assert(old == nil, "Cannot add the same static shape more than once")
+  Assert(shape.Body != nil, "Cannot add a static shape with a nil body.")
+  Assert(!space.staticShapes.Contains(shape, shape.hashid), 
+    "Cannot add the same static shape more than once")
   space.AssertUnlocked()
-  shape.CacheBB()
-  space.staticShapes.Insert(shape, shape.hashid, shape.bb)
+  updateBBCache(shape, nil)
+  space.staticShapes.Insert(shape, shape.hashid)
+  space.attachShape(shape)
   return shape
 }
 
+// RehashStaticShape updates the bounding box of a static shape that
+// changed, like a heightfield that deformed, and its place in the static
+// spatial hash.
+func (space * Space) RehashStaticShape(shape * Shape) {
+  space.AssertUnlocked()
+  updateBBCache(shape, nil)
+  space.staticShapes.RehashObject(shape, shape.hashid)
+}
 
 func (space * Space) AddBody(body * Body) (* Body) {
   Assert(!space.bodies.Contains(body), 
           "Cannot add the same body more than once.")
-  space.Bodies.Push(body)
+  space.bodies.Push(body)
+  body.space = space
   return body
 }
 
+// Adds a shape that was added to the space to the shapes of its body.
+func (space * Space) attachShape(shape * Shape) {
+  shape.Body.space  = space
+  shape.Body.shapes = append(shape.Body.shapes, shape)
+}
 
-func (space * Space) AddConstraint(constraint * Constraint) {
+// Removes a shape that was removed from the space from the shapes of its
+// body. A body that is not in the space leaves it with its last shape.
+func (space * Space) detachShape(shape * Shape) {
+  body := shape.Body
+  body.removeShape(shape)
+  if len(body.shapes) == 0 && !space.bodies.Contains(body) {
+    body.space = nil
+  }
+}
+
+
+func (space * Space) AddConstraint(constraint Constraint) (Constraint) {
   Assert(!space.constraints.Contains(constraint), "Cannot add the same constraint more than once.")  
   space.constraints.Push(constraint);  
+  a, b := constraint.A(), constraint.B()
+  if a != nil { a.constraints = append(a.constraints, constraint) }
+  if b != nil && b != a { b.constraints = append(b.constraints, constraint) }
   return constraint;
 }
 
-typedef struct removalContext {
-  cpSpace *space;
-  cpShape *shape;
-} removalContext;
-
-// Hashset filter func to throw away old arbiters.
-static int
-contactSetFilterRemovedShape(cpArbiter *arb, removalContext *context)
-{
-  if(context.shape == arb.private_a || context.shape == arb.private_b){
-    arb.handler.separate(arb, context.space, arb.handler.data);
-    cpArrayPush(context.space.pooledArbiters, arb);
-    return 0;
-  }
-  
-  return 1;
+// Throws away the arbiters of a shape that is removed from the space. The
+// keys are sorted so the separate callbacks run in the same order each time.
+func (space * Space) filterRemovedShape(shape * Shape) {
+  for _, k := range space.sortedContactSetKeys() { 
+    arb := space.contactSet[k]
+    if shape == arb.private_a || shape == arb.private_b {
+      arb.handler.separate(arb, space, arb.handler.data)
+      arb.private_a.Body.removeArbiter(arb)
+      arb.private_b.Body.removeArbiter(arb)
+      space.arbiters.DeleteObj(arb)
+      space.pooledArbiters.Push(arb)
+      space.contactSet[k] = nil, false
+    }
+  }  
 }
 
-
 func (space * Space) RemoveShape(shape * Shape) {
   space.AssertUnlocked()    
-  for k, v := range space.contactSet { 
-    if shape == v.private_a || shape == v.private_b {
-      v.handler.separate(v, space, v.handler.data)
-      space.contactSet[k] = nil, false
-      // remove all contacts of this shape 
-    }
-  }  
+  space.filterRemovedShape(shape)
   space.activeShapes.Remove(shape, shape.hashid)
+  space.detachShape(shape)
 }
 
 func (space * Space) RemoveStaticShape(shape * Shape) {
   space.AssertUnlocked()
-  for k, v := range space.contactSet { 
-    if shape == v.private_a || shape == v.private_b {
-      v.handler.separate(v, space, v.handler.data)
-      space.contactSet[k] = nil, false
-      // remove all contacts of this shape 
-    }
-  }  
+  space.filterRemovedShape(shape)
   space.staticShapes.Remove(shape, shape.hashid)
+  space.detachShape(shape)
 }
 
 
+// RemoveBody removes the body from the space. Its shapes and constraints
+// stay in the space.
 func (space * Space) RemoveBody(body * Body) {
   space.AssertUnlocked()
   space.bodies.DeleteObj(body)  
+  body.arbiters = body.arbiters[0:0]
+  body.space    = nil
 }
 
-func (space * Space) RemoveConstraint(constraint * Constraint) {
+// RemoveBodyCascade removes the body from the space together with its
+// shapes and constraints.
+func (space * Space) RemoveBodyCascade(body * Body) {
+  space.AssertUnlocked()
+  body.EachShape(func(shape * Shape) {
+    if space.staticShapes.Contains(shape, shape.hashid) {
+      space.RemoveStaticShape(shape)
+    } else {
+      space.RemoveShape(shape)
+    }
+  })
+  body.EachConstraint(func(constraint Constraint) {
+    space.RemoveConstraint(constraint)
+  })
+  space.RemoveBody(body)
+}
+
+func (space * Space) RemoveConstraint(constraint Constraint) {
   space.AssertUnlocked()
   space.constraints.DeleteObj(constraint)  
+  a, b := constraint.A(), constraint.B()
+  if a != nil { a.removeConstraint(constraint) }
+  if b != nil { b.removeConstraint(constraint) }
 }
 
-func (space * Space) AddPostStepCallback(fun * PostStepFunc, 
-      obj, data interface{}) {
-  callback :=  &postStepCallback{fun, obj, data};
-  space.postStepCallbacks.Insert(callback, HashValue(obj))
-  // BDM: This also sucks
+// Called when a step is done, with the object and the data that it was
+// added with.
+type PostStepFunc func(space * Space, obj, data interface{})
+
+type postStepCallback struct {
+  fun PostStepFunc
+  obj, data interface{}
 }
 
-func removeShape(shape * Shape, space * space) {
-   space.RemoveShape(shape)
-}   
+// AddPostStepCallback calls fun with obj and data when the current step
+// is done, or after the next step if the space isn't stepping. The
+// callbacks of a step can't add or remove objects, but post step
+// callbacks can. Only the first callback added for obj is kept, so an
+// object isn't removed twice. obj must be comparable, like a pointer.
+func (space * Space) AddPostStepCallback(fun PostStepFunc,
+  obj, data interface{}) {
+  for _, callback := range space.postStepCallbacks {
+    if callback.obj == obj { return }
+  }
+  space.postStepCallbacks = append(space.postStepCallbacks,
+    postStepCallback{fun, obj, data})
+}
 
-func (space * Space) PostStepRemoveShape(shape * shape) {
-  shape.AddPostStepCallback(removeShape, shape, space)
-} 
+// Runs the post step callbacks, also the ones that they add, and forgets
+// them.
+func (space * Space) runPostStepCallbacks() {
+  for i:=0; i < len(space.postStepCallbacks); i++ {
+    callback := space.postStepCallbacks[i]
+    callback.fun(space, callback.obj, callback.data)
+  }
+  space.postStepCallbacks = nil
+}
 
+func postStepRemoveShape(space * Space, obj, data interface{}) {
+  space.RemoveShape(obj.(*Shape))
+}
+
+// PostStepRemoveShape removes the shape from the space when the current
+// step is done, so collision callbacks can remove the shapes they see.
+func (space * Space) PostStepRemoveShape(shape * Shape) {
+  space.AddPostStepCallback(postStepRemoveShape, shape, nil)
+}
+
+/*
 type pointQueryContext struct {
   layers  Layers;
   group   Group;
@@ -388,6 +462,34 @@ func (space * Space) EachBody() (chan *Body) {
   return out
 } 
 
+// Calls iter for every active shape in the space.
+func (space * Space) EachShape(iter func(shape * Shape)) {
+  space.activeShapes.Each(func(obj, data HashElement) {
+    iter(obj.(*Shape))
+  }, nil)
+}
+
+// Calls iter for every static shape in the space.
+func (space * Space) EachStaticShape(iter func(shape * Shape)) {
+  space.staticShapes.Each(func(obj, data HashElement) {
+    iter(obj.(*Shape))
+  }, nil)
+}
+
+// Calls iter for every constraint in the space.
+func (space * Space) EachConstraint(iter func(constraint Constraint)) {
+  for i:=0; i < space.constraints.Size(); i++ {
+    iter(space.constraints.Index(i).(Constraint))
+  }
+}
+
+// Calls iter for every arbiter that was active during the last step.
+func (space * Space) EachArbiter(iter func(arb * Arbiter)) {
+  for i:=0; i < space.arbiters.Size(); i++ {
+    iter(space.arbiters.Index(i).(*Arbiter))
+  }
+}
+
 /*
 typedef struct segQueryContext {
   cpVect start, end;
@@ -537,253 +639,374 @@ cpSpaceRehashStatic(cpSpace *space)
   cpSpaceHashEach(space.staticShapes, (cpSpaceHashIterator)&updateBBCache, NULL);
   cpSpaceHashRehash(space.staticShapes);
 }
+*/
 
-#pragma mark Collision Detection Functions
+// Hashes a pair of values. The result doesn't depend on the order of a and b.
+func HASH_PAIR(a, b HashValue) (HashValue) {
+  return a*HASH_COEF ^ b*HASH_COEF
+}
 
-static cpContactBufferHeader *
-cpSpaceGetFreeContactBuffer(cpSpace *space)
-{
-  if(space.stamp - space.contactBuffersTail.stamp > cp_contact_persistence){
-    cpContactBufferHeader *header = space.contactBuffersTail;
-    space.contactBuffersTail = header.next;
-    
-    return cpContactBufferHeaderInit(header, space);
-  } else {
-    cpContactBufferHeader *header = cpSpaceAllocContactBuffer(space);
-    return cpContactBufferHeaderInit(header, space);
+// Key of a pair of values in the maps of the space. Different pairs may
+// have the same HASH_PAIR, but never the same key.
+type pairKey struct {
+  a, b HashValue
+}
+
+// Returns the key of the pair. The smaller value comes first, so the key
+// doesn't depend on the order of a and b.
+func pairKeyNew(a, b HashValue) (pairKey) {
+  if a > b { a, b = b, a }
+  return pairKey{a, b}
+}
+
+// Equality function, so the space can be passed as data to the spatial hash.
+func (space *Space) Equals(el interface {}) (bool) {
+  other, ok := el.(*Space)
+  if !ok { return false; }
+  return space == other
+}
+
+// Iterator function used for updating shape BBoxes.
+func updateBBCache(obj, unused HashElement) {
+  shape    := obj.(*Shape)
+  bb       := shape.CacheBB(shape.Body.p, shape.Body.rot)
+  shape.BB  = &bb
+}
+
+// Collision Detection Functions
+
+func (space *Space) getFreeContactBuffer() (*ContactBufferHeader) {
+  if space.stamp - space.contactBuffersTail.stamp > CONTACT_PERSISTENCE {
+    header                  := space.contactBuffersTail
+    space.contactBuffersTail = header.next
+    return header.Init(space)
   }
+  return ContactBufferHeaderNew(space)
 }
 
-static void
-cpSpacePushNewContactBuffer(cpSpace *space)
-{
-//  for(cpContactBuffer *buffer = space.contactBuffersTail; buffer != space.contactBuffersHead; buffer = buffer.next){
-//    printf("%p . ", buffer);
-//  }
-//  printf("%p (head)\n", space.contactBuffersHead);
-  
-  cpContactBufferHeader *buffer = cpSpaceGetFreeContactBuffer(space);
-  space.contactBuffersHead.next = buffer;
-  space.contactBuffersHead = buffer;
+// Starts a fresh ring of contact buffers, stamped with the current stamp.
+// Needed when the stamp changes other than by stepping, since the buffers
+// may be in the future then.
+func (space *Space) resetContactBuffers() {
+  header                  := ContactBufferHeaderNew(space)
+  header.next              = header
+  space.contactBuffersHead = header
+  space.contactBuffersTail = header
 }
 
-static inline int
-queryReject(cpShape *a, cpShape *b)
-{
-  return
-    // BBoxes must overlap
-    !cpBBintersects(a.bb, b.bb)
-    // Don't collide shapes attached to the same body.
-    || a.body == b.body
-    // Don't collide objects in the same non-zero group
-    || (a.group && b.group && a.group == b.group)
-    // Don't collide objects that don't share at least on layer.
-    || !(a.layers & b.layers);
+func (space *Space) pushNewContactBuffer() {
+  space.stats.ContactBufferPushes++
+  buffer                        := space.getFreeContactBuffer()
+  space.contactBuffersHead.next  = buffer
+  space.contactBuffersHead       = buffer
+}
+
+// Returns an arbiter for the shapes a and b, recycling a pooled one if 
+// possible.
+func (space *Space) getArbiter(a, b *Shape) (*Arbiter) {
+  if space.pooledArbiters.Size() > 0 {
+    return space.pooledArbiters.Pop().(*Arbiter).Init(a, b)
+  }
+  return ArbiterNew(a, b)
+}
+
+// Returns the collision handler for the given collision types.
+func (space *Space) lookupHandler(a, b CollisionType) (*CollisionHandler) {
+  handler, ok := space.collFuncSet[pairKeyNew(HashValue(a), HashValue(b))]
+  if !ok { return &space.defaultHandler }
+  return handler
+}
+
+func queryReject(a, b *Shape) (bool) {
+  // BBoxes must overlap
+  if !a.BB.Intersects(*b.BB) { return true }
+  // Don't collide shapes attached to the same body.
+  if a.Body == b.Body { return true }
+  // Don't collide objects in the same non-zero group
+  if a.group != NO_GROUP && a.group == b.group { return true }
+  // Don't collide objects that don't share at least on layer.
+  return (a.layers & b.layers) == 0
+}
+
+// Returns true if a or b is one-way, and the normal from a to b of any of
+// their first contacts doesn't come from the direction the one-way shape
+// allows. A shape that is already partly inside the one-way shape has
+// contacts from the side or from behind, so it passes through.
+func oneWayReject(a, b *Shape, contacts []Contact) (bool) {
+  if a.oneWay == VZERO && b.oneWay == VZERO { return false }
+  for i := range contacts {
+    n := contacts[i].N
+    if a.oneWay != VZERO && n.Dot(a.oneWay.Rotate(a.Body.rot)) <= 0.0 {
+      return true
+    }
+    if b.oneWay != VZERO && n.Dot(b.oneWay.Rotate(b.Body.rot)) >= 0.0 {
+      return true
+    }
+  }
+  return false
 }
 
 // Callback from the spatial hash.
-static void
-queryFunc(cpShape *a, cpShape *b, cpSpace *space)
-{
+func queryFunc(obja, objb, data HashElement) (bool) {
+  a     := obja.(*Shape)
+  b     := objb.(*Shape)
+  space := data.(*Space)
+  space.stats.CandidatePairs++
   // Reject any of the simple cases
-  if(queryReject(a,b)) return;
+  if queryReject(a, b) { return false }
   
   // Find the collision pair function for the shapes.
-  struct{cpCollisionType a, b;} ids = {a.collision_type, b.collision_type};
-  cpHashValue collHashID = CP_HASH_PAIR(a.collision_type, b.collision_type);
-  cpCollisionHandler *handler = (cpCollisionHandler *)cpHashSetFind(space.collFuncSet, collHashID, &ids);
-  
-  int sensor = a.sensor || b.sensor;
-  if(sensor && handler == &space.defaultHandler) return;
-  
-  // Shape 'a' should have the lower shape type. (required by cpCollideShapes() )
-  if(a.klass.type > b.klass.type){
-    cpShape *temp = a;
-    a = b;
-    b = temp;
+  handler := space.lookupHandler(a.collision_type, b.collision_type)
+  sensor  := a.sensor || b.sensor
+  if sensor && handler == &space.defaultHandler { return false }
+  
+  // Shape 'a' should have the lower shape type. (required by CollideShapes())
+  if a.ShapeClass.Type > b.ShapeClass.Type {
+    a, b = b, a
   }
   
-  if(space.contactBuffersHead.numContacts + CP_MAX_CONTACTS_PER_ARBITER > CP_CONTACTS_BUFFER_SIZE){
+  if space.contactBuffersHead.numContacts + CP_MAX_CONTACTS_PER_ARBITER > 
+     CONTACTS_BUFFER_SIZE {
     // contact buffer could overflow on the next collision, push a fresh one.
-    cpSpacePushNewContactBuffer(space);
+    space.pushNewContactBuffer()
   }
   
   // Narrow-phase collision detection.
-  cpContact *contacts = ((cpContactBuffer *)(space.contactBuffersHead)).contacts + space.contactBuffersHead.numContacts;
-  int numContacts = cpCollideShapes(a, b, contacts);
-  if(!numContacts) return; // Shapes are not colliding.
-  space.contactBuffersHead.numContacts += numContacts;
+  start       := space.clock()
+  head        := space.contactBuffersHead
+  contacts    := head.contacts[head.numContacts:]
+  numContacts := CollideShapes(a, b, contacts)
+  if numContacts == 0 { 
+    space.lap(&space.stats.Narrowphase, start)
+    return false // Shapes are not colliding.
+  }
+  head.numContacts += numContacts
   
   // Get an arbiter from space.contactSet for the two shapes.
   // This is where the persistant contact magic comes from.
-  cpShape *shape_pair[] = {a, b};
-  cpHashValue arbHashID = CP_HASH_PAIR((size_t)a, (size_t)b);
-  cpArbiter *arb = (cpArbiter *)cpHashSetInsert(space.contactSet, arbHashID, shape_pair, space);
-  cpArbiterUpdate(arb, contacts, numContacts, handler, a, b); // retains the contacts array
-  
-  // Call the begin function first if it's the first step
-  if(arb.stamp == -1 && !handler.begin(arb, space, handler.data)){
-    cpArbiterIgnore(arb); // permanently ignore the collision until separation
+  // The shape ids are used in stead of the addresses that Chipmunk uses, 
+  // so the keys are the same from one run to the next.
+  key        := pairKeyNew(a.hashid, b.hashid)
+  arb, found := space.contactSet[key]
+  if !found {
+    arb = space.getArbiter(a, b)
+    space.contactSet[key] = arb
+  }
+  arb.Update(contacts[0:numContacts], numContacts, handler, a, b)
+  space.mixSurfaces(arb, a, b)
+  start = space.lap(&space.stats.Narrowphase, start)
+  
+  // Call the begin function first if it's the first step. Shapes that 
+  // first touch a one-way shape from the wrong side pass through it.
+  if arb.stamp == -1 && (oneWayReject(a, b, contacts[0:numContacts]) ||
+     handler.begin(arb, space, handler.data) == 0) {
+    arb.Ignore() // permanently ignore the collision until separation
   }
   
-  if(
-    // Ignore the arbiter if it has been flagged
-    (arb.state != cpArbiterStateIgnore) && 
-    // Call preSolve
-    handler.preSolve(arb, space, handler.data) &&
-    // Process, but don't add collisions for sensors.
-    !sensor
-  ){
-    cpArrayPush(space.arbiters, arb);
+  // Ignore the arbiter if it has been flagged, call preSolve,  
+  // and process, but don't add collisions for sensors.
+  keep := arb.state != ArbiterStateIgnore && 
+    handler.preSolve(arb, space, handler.data) != 0 && !sensor
+  space.lap(&space.stats.Callbacks, start)
+  if keep {
+    space.arbiters.Push(arb)
   } else {
-//    cpfree(arb.contacts);
-    space.contactBuffersHead.numContacts -= numContacts;
-    arb.contacts = NULL;
-    arb.numContacts = 0;
+    head.numContacts -= numContacts
+    arb.contacts      = nil
+    arb.numContacts   = 0
   }
   
   // Time stamp the arbiter so we know it was used recently.
-  arb.stamp = space.stamp;
+  arb.stamp = space.stamp
+  return true
 }
 
 // Iterator for active/static hash collisions.
-static void
-active2staticIter(cpShape *shape, cpSpace *space)
-{
-  cpSpaceHashQuery(space.staticShapes, shape, shape.bb, (cpSpaceHashQueryFunc)queryFunc, space);
+func active2staticIter(obj, data HashElement) {
+  shape := obj.(*Shape)
+  space := data.(*Space)
+  space.staticShapes.SpaceQuery(shape, *shape.BB, queryFunc, space)
+}
+
+// Throws away old arbiters, and calls the separate callbacks of the ones 
+// that were in contact last frame, but not this one.
+func (space *Space) filterContactSet() {
+  for _, key := range space.contactSetKeys() {
+    arb   := space.contactSet[key]
+    ticks := space.stamp - arb.stamp
+    
+    // was used last frame, but not this one
+    if ticks == 1 {
+      start := space.clock()
+      arb.handler.separate(arb, space, arb.handler.data)
+      space.lap(&space.stats.Callbacks, start)
+      arb.stamp = -1 // mark it as a new pair again.
+      arb.state = ArbiterStateFirstColl // and stop ignoring it.
+    }
+    
+    if ticks >= CONTACT_PERSISTENCE {
+      space.pooledArbiters.Push(arb)
+      space.contactSet[key] = nil, false
+    }
+  }
 }
 
-// Hashset filter func to throw away old arbiters.
-static int
-contactSetFilter(cpArbiter *arb, cpSpace *space)
-{
-  int ticks = space.stamp - arb.stamp;
-  
-  // was used last frame, but not this one
-  if(ticks == 1){
-    arb.handler.separate(arb, space, arb.handler.data);
-    arb.stamp = -1; // mark it as a new pair again.
-  }
-  
-  if(ticks >= cp_contact_persistence){
-    cpArrayPush(space.pooledArbiters, arb);
-    return 0;
+// Empties the arbiter list of a body, for SpaceHash.Each.
+func clearShapeBodyArbiters(obj, data HashElement) {
+  body         := obj.(*Shape).Body
+  body.arbiters = body.arbiters[0:0]
+}
+
+// Empties the arbiter lists of all bodies in the space, and of the bodies
+// of all its shapes, so static bodies and bodies whose arbiters were thrown
+// away since the last step don't keep them.
+func (space *Space) clearBodyArbiters() {
+  for i:=0; i < space.bodies.Size(); i++ {
+    body         := space.bodies.Index(i).(*Body)
+    body.arbiters = body.arbiters[0:0]
   }
-  
-  return 1;
+  space.activeShapes.Each(clearShapeBodyArbiters, nil)
+  space.staticShapes.Each(clearShapeBodyArbiters, nil)
 }
 
-// Hashset filter func to call and throw away post step callbacks.
-static int
-postStepCallbackSetFilter(postStepCallback *callback, cpSpace *space)
-{
-  callback.func(space, callback.obj, callback.data);
-  cpfree(callback);
-  
-  return 0;
+// Adds the arbiters of this step to the arbiter lists of their bodies.
+func (space *Space) fillBodyArbiters() {
+  for i:=0; i < space.arbiters.Size(); i++ {
+    arb  := space.arbiters.Index(i).(*Arbiter)
+    a, b := arb.private_a.Body, arb.private_b.Body
+    a.arbiters = append(a.arbiters, arb)
+    if b != a { b.arbiters = append(b.arbiters, arb) }
+  }
 }
 
-#pragma mark All Important cpSpaceStep() Function
+// All Important Step() Function
 
-void
-cpSpaceStep(cpSpace *space, cpFloat dt)
-{
-  if(!dt) return; // don't step if the timestep is 0!
+// Step advances the simulation of the space by the time step dt.
+func (space *Space) Step(dt Float) {
+  if dt == 0.0 { return } // don't step if the timestep is 0!
   
-  cpFloat dt_inv = 1.0f/dt;
-
-  cpArray *bodies = space.bodies;
-  cpArray *constraints = space.constraints;
+  dt_inv      := Float(1.0) / dt
+  bodies      := space.bodies
+  constraints := space.constraints
+  stats       := &space.stats
   
-  space.locked = 1;
+  space.stats  = StepStats{}
+  stats.ActiveBodies = bodies.Size()
+  begin       := space.clock()
+  now         := begin
+  
+  space.locked = 1
+  
+  // Empty the arbiter lists.
+  space.clearBodyArbiters()
+  space.arbiters.num = 0
   
-  // Empty the arbiter list.
-  space.arbiters.num = 0;
-
   // Integrate positions.
-  for(int i=0; i<bodies.num; i++){
-    cpBody *body = (cpBody *)bodies.arr[i];
-    body.position_func(body, dt);
+  for i:=0; i < bodies.Size(); i++ {
+    body := bodies.Index(i).(*Body)
+    body.UpdatePosition(dt)
   }
+  now = space.lap(&stats.Integrate, now)
   
   // Pre-cache BBoxes and shape data.
-  cpSpaceHashEach(space.activeShapes, (cpSpaceHashIterator)updateBBCache, NULL);
+  space.activeShapes.Each(updateBBCache, nil)
+  now = space.lap(&stats.CacheBB, now)
+  
+  // Collide! The narrowphase and the callbacks are timed in queryFunc, so 
+  // the broadphase is the rest of the time.
+  space.pushNewContactBuffer()
+  space.activeShapes.Each(active2staticIter, space)
+  space.activeShapes.hashRehash(queryFunc, space)
+  now = space.lap(&stats.Broadphase, now)
+  stats.Broadphase -= stats.Narrowphase + stats.Callbacks
+  
+  // Clear out old cached arbiters and dispatch untouch functions. The
+  // separate callbacks are timed in filterContactSet.
+  callbacks := stats.Callbacks
+  space.filterContactSet()
+  
+  // The order in which the spatial hash reports the pairs depends on the 
+  // layout of its table. Sort the arbiters to make it depend on the shapes.
+  if space.Deterministic {
+    space.sortArbiters()
+  }
   
-  // Collide!
-  cpSpacePushNewContactBuffer(space);
-  cpSpaceHashEach(space.activeShapes, (cpSpaceHashIterator)active2staticIter, space);
-  cpSpaceHashQueryRehash(space.activeShapes, (cpSpaceHashQueryFunc)queryFunc, space);
+  space.fillBodyArbiters()
+  now = space.lap(&stats.ContactSet, now)
+  stats.ContactSet -= stats.Callbacks - callbacks
   
-  // Clear out old cached arbiters and dispatch untouch functions
-  cpHashSetFilter(space.contactSet, (cpHashSetFilterFunc)contactSetFilter, space);
-
   // Prestep the arbiters.
-  cpArray *arbiters = space.arbiters;
-  for(int i=0; i<arbiters.num; i++)
-    cpArbiterPreStep((cpArbiter *)arbiters.arr[i], dt_inv);
-
+  arbiters := space.arbiters
+  stats.Arbiters = arbiters.Size()
+  for i:=0; i < arbiters.Size(); i++ {
+    arb := arbiters.Index(i).(*Arbiter)
+    arb.PreStep(dt_inv)
+    stats.Contacts += arb.numContacts
+  }
+  now = space.lap(&stats.ArbiterPreStep, now)
+  
   // Prestep the constraints.
-  for(int i=0; i<constraints.num; i++){
-    cpConstraint *constraint = (cpConstraint *)constraints.arr[i];
-    constraint.klass.preStep(constraint, dt, dt_inv);
+  for i:=0; i < constraints.Size(); i++ {
+    constraints.Index(i).(Constraint).PreStep(dt, dt_inv)
   }
-
-  for(int i=0; i<space.elasticIterations; i++){
-    for(int j=0; j<arbiters.num; j++)
-      cpArbiterApplyImpulse((cpArbiter *)arbiters.arr[j], 1.0f);
-      
-    for(int j=0; j<constraints.num; j++){
-      cpConstraint *constraint = (cpConstraint *)constraints.arr[j];
-      constraint.klass.applyImpulse(constraint);
+  now = space.lap(&stats.ConstraintPreStep, now)
+  
+  for i:=0; i < space.ElasticIterations; i++ {
+    for j:=0; j < arbiters.Size(); j++ {
+      arbiters.Index(j).(*Arbiter).ApplyImpulse(Float(1.0))
+    }
+    for j:=0; j < constraints.Size(); j++ {
+      constraints.Index(j).(Constraint).ApplyImpulse()
     }
   }
-
+  
+  now = space.lap(&stats.Solver, now)
+  
   // Integrate velocities.
-  cpFloat damping = cpfpow(1.0f/space.damping, -dt);
-  for(int i=0; i<bodies.num; i++){
-    cpBody *body = (cpBody *)bodies.arr[i];
-    body.velocity_func(body, space.gravity, damping, dt);
+  damping := (Float(1.0) / space.Damping).Pow(-dt)
+  for i:=0; i < bodies.Size(); i++ {
+    body := bodies.Index(i).(*Body)
+    body.UpdateVelocity(space.Gravity, damping, dt)
+  }
+  now = space.lap(&stats.Integrate, now)
+  
+  for i:=0; i < arbiters.Size(); i++ {
+    arbiters.Index(i).(*Arbiter).ApplyCachedImpulse()
   }
-
-  for(int i=0; i<arbiters.num; i++)
-    cpArbiterApplyCachedImpulse((cpArbiter *)arbiters.arr[i]);
   
   // run the old-style elastic solver if elastic iterations are disabled
-  cpFloat elasticCoef = (space.elasticIterations ? 0.0f : 1.0f);
+  elasticCoef := Float(1.0)
+  if space.ElasticIterations > 0 { 
+    elasticCoef = Float(0.0)
+  }
   
   // Run the impulse solver.
-  for(int i=0; i<space.iterations; i++){
-    for(int j=0; j<arbiters.num; j++)
-      cpArbiterApplyImpulse((cpArbiter *)arbiters.arr[j], elasticCoef);
-      
-    for(int j=0; j<constraints.num; j++){
-      cpConstraint *constraint = (cpConstraint *)constraints.arr[j];
-      constraint.klass.applyImpulse(constraint);
+  for i:=0; i < space.Iterations; i++ {
+    for j:=0; j < arbiters.Size(); j++ {
+      arbiters.Index(j).(*Arbiter).ApplyImpulse(elasticCoef)
+    }
+    for j:=0; j < constraints.Size(); j++ {
+      constraints.Index(j).(Constraint).ApplyImpulse()
     }
   }
   
-  space.locked = 0;
+  now = space.lap(&stats.Solver, now)
+  
+  space.locked = 0
   
   // run the post solve callbacks
-  for(int i=0; i<arbiters.num; i++){
-    cpArbiter *arb = arbiters.arr[i];
-    
-    cpCollisionHandler *handler = arb.handler;
-    handler.postSolve(arb, space, handler.data);
-    
-    arb.state = cpArbiterStateNormal;
+  for i:=0; i < arbiters.Size(); i++ {
+    arb     := arbiters.Index(i).(*Arbiter)
+    handler := arb.handler
+    handler.postSolve(arb, space, handler.data)
+    arb.state = ArbiterStateNormal
   }
   
-  // Run the post step callbacks
-  // Use filter as an easy way to clear out the queue as it runs
-  cpHashSetFilter(space.postStepCallbacks, (cpHashSetFilterFunc)postStepCallbackSetFilter, space);
-  
-//  cpFloat dvsq = cpvdot(space.gravity, space.gravity);
-//  dvsq *= dt*dt * space.damping*space.damping;
-//  for(int i=0; i<bodies.num; i++)
-//    cpBodyMarkLowEnergy(bodies.arr[i], dvsq, space.sleepTicks);
+  space.runPostStepCallbacks()
+  now = space.lap(&stats.Callbacks, now)
+  stats.Total = now - begin
   
   // Increment the stamp.
-  space.stamp++;
+  space.stamp++
+  space.finishStats()
 }
-*/
@@ -8,7 +8,7 @@ package tamias
 
 type SpaceHashElement interface  {
   HashElement
-  GetBB()(BB)
+  GetBB()(*BB)
 }
 
 
@@ -57,11 +57,13 @@ func (hand *Handle) Init(obj SpaceHashElement) (*Handle) {
   return hand
 }
 
-// Equality function for the handleset.
+// Equality function for the handleset. Two handles are equal if they are
+// the same handle or if they wrap the same object.
 func (hand *Handle) Equals(el interface {}) (bool) {
   other, ok := el.(*Handle)
   if !ok { return false; }
-  return hand == other
+  if hand == other { return true }
+  return hand.obj != nil && hand.obj == other.obj
 }
 
 // Equality function for the SpaceHash
@@ -177,7 +179,7 @@ func (hash * SpaceHash) Resize(celldim Float, numcells int) {
 
 // Return true if the chain contains the handle.
 func (bin *SpaceHashBin) containsHandle(hand *Handle) (bool) {
-  if bin != nil {
+  for bin != nil {
     if (bin.handle == hand) { return true }
     bin = bin.next
   }  
@@ -206,7 +208,9 @@ func (hash * SpaceHash) getEmptyBin() (* SpaceHashBin) {
 
 // The hash function itself.
 func hash_func(x, y, n HashValue) (HashValue) {
-  return (x*1640531513 ^ y*2654435789) % n
+  // Negative cell coordinates give negative products, so take the modulo
+  // unsigned to keep the index inside the table.
+  return HashValue(uint64(x*1640531513 ^ y*2654435789) % uint64(n))
 }
 
 // Much faster than (int)floor(f)
@@ -254,26 +258,46 @@ func (hash * SpaceHash) hashHandle(hand * Handle, bb BB) {
   }
 }
 
-func (hash * SpaceHash) InsertHandle(hand * Handle, obj HashElement, hashid HashValue, bb BB) {
-  hand = hash.handleSet.Insert(hashid, obj).(*Handle)
+// Finds the handle of an object, or nil if the object is not in the hash.
+func (hash * SpaceHash) findHandle(obj SpaceHashElement, hashid HashValue) (*Handle) {
+  key      := &Handle{obj: obj}
+  hand, _  := hash.handleSet.Find(hashid, key).(*Handle)
+  return hand
+}
+
+// Returns true if the object was inserted in the hash.
+func (hash * SpaceHash) Contains(obj SpaceHashElement, hashid HashValue) (bool) {
+  return hash.findHandle(obj, hashid) != nil
+}
+
+func (hash * SpaceHash) InsertHandle(hand * Handle, obj SpaceHashElement, hashid HashValue, bb BB) {
+  hand.Init(obj)
+  hand.Retain()
+  hand = hash.handleSet.Insert(hashid, hand).(*Handle)
   hash.hashHandle(hand, bb)
 }
 
 func (hash * SpaceHash) Insert(obj SpaceHashElement,  hashid HashValue) {
-  hand := hash.handleSet.Find(hashid, obj).(*Handle)
-  hash.hashHandle(hand, obj.GetBB())
+  hand := hash.findHandle(obj, hashid)
+  if hand == nil {
+    hand = new(Handle).Init(obj)
+    hand.Retain()
+    hash.handleSet.Insert(hashid, hand)
+  }
+  hash.hashHandle(hand, *obj.GetBB())
 }
 
 func (hash * SpaceHash) RehashObject(obj SpaceHashElement,  hashid HashValue) {
-  hand := hash.handleSet.Find(hashid, obj).(*Handle)
-  hash.hashHandle(hand, obj.GetBB())
+  hand := hash.findHandle(obj, hashid)
+  if hand == nil { return }
+  hash.hashHandle(hand, *obj.GetBB())
 } 
 
 // Hashset iterator function for rehashing the spatial hash. (hash hash hash hash?)
 func handleRehashHelper(bin, data HashElement) {  
   var hand * Handle     = bin.(*Handle) 
   var hash * SpaceHash  = data.(*SpaceHash)   
-  hash.hashHandle(hand, hand.obj.GetBB())
+  hash.hashHandle(hand, *hand.obj.GetBB())
 }
 
 func (hash * SpaceHash) Rehash() {
@@ -282,8 +306,9 @@ func (hash * SpaceHash) Rehash() {
   hash.handleSet.Each(handleRehashHelper, hash)
 }
 
-func (hash * SpaceHash) Remove(obj HashElement,  hashid HashValue) {
-  hand := hash.handleSet.Remove(hashid, obj).(*Handle)  
+func (hash * SpaceHash) Remove(obj SpaceHashElement,  hashid HashValue) {
+  key     := &Handle{obj: obj}
+  hand, _ := hash.handleSet.Remove(hashid, key).(*Handle)
   if hand != nil {
     hand.obj = nil
     hand.Release(hash.pooledHandles)
@@ -397,7 +422,7 @@ func handleQueryRehashHelper(p1, p2 HashElement) {
   n  	:= hash.numcells
 
   obj 	:= hand.obj
-  bb 	  := obj.GetBB()
+  bb 	  := *obj.GetBB()
   var l, r, b, t int
   l, r, b , t = hash.cellDimensions(bb)
 
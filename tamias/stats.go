@@ -0,0 +1,131 @@
+package tamias
+
+// Statistics of the steps of a space, to find out where Step spends its
+// time.
+
+import "time"
+
+// Statistics of one step. Times are in nanoseconds, and are only measured
+// when Space.Profile is true. The phases are timed back to back, so they
+// add up to Total. The counts are always kept.
+type StepStats struct {
+  // Time spent integrating positions and velocities.
+  Integrate int64
+  // Time spent caching the bounding boxes of the shapes.
+  CacheBB int64
+  // Time spent finding pairs of shapes that may touch, in the spatial hash.
+  Broadphase int64
+  // Time spent colliding the pairs of shapes, and updating their arbiters.
+  Narrowphase int64
+  // Time spent dropping the arbiters of shapes that stopped touching, and
+  // sorting and listing the arbiters of the step.
+  ContactSet int64
+  ArbiterPreStep int64
+  ConstraintPreStep int64
+  // Time spent in the elastic and the impulse solver iterations.
+  Solver int64
+  // Time spent in the collision handler callbacks.
+  Callbacks int64
+  // Time of the whole step.
+  Total int64
+
+  ActiveBodies int
+  // Pairs of shapes that the broadphase found.
+  CandidatePairs int
+  // Arbiters and contacts handed to the solver.
+  Arbiters, Contacts int
+  // New contact buffers that the step needed.
+  ContactBufferPushes int
+}
+
+// Called after every step with its statistics.
+type StatsFunc func(space *Space, stats *StepStats)
+
+// Returns the time if the space is profiling, or else 0.
+func (space *Space) clock() (int64) {
+  if !space.Profile { return 0 }
+  return time.Nanoseconds()
+}
+
+// Adds the time since start to the field, and returns the current time.
+func (space *Space) lap(field *int64, start int64) (int64) {
+  if !space.Profile { return 0 }
+  now    := time.Nanoseconds()
+  *field += now - start
+  return now
+}
+
+func (stats *StepStats) add(other *StepStats) {
+  stats.Integrate           += other.Integrate
+  stats.CacheBB             += other.CacheBB
+  stats.Broadphase          += other.Broadphase
+  stats.Narrowphase         += other.Narrowphase
+  stats.ContactSet          += other.ContactSet
+  stats.ArbiterPreStep      += other.ArbiterPreStep
+  stats.ConstraintPreStep   += other.ConstraintPreStep
+  stats.Solver              += other.Solver
+  stats.Callbacks           += other.Callbacks
+  stats.Total               += other.Total
+  stats.ActiveBodies        += other.ActiveBodies
+  stats.CandidatePairs      += other.CandidatePairs
+  stats.Arbiters            += other.Arbiters
+  stats.Contacts            += other.Contacts
+  stats.ContactBufferPushes += other.ContactBufferPushes
+}
+
+func (stats *StepStats) divide(n int) {
+  n64 := int64(n)
+  stats.Integrate           /= n64
+  stats.CacheBB             /= n64
+  stats.Broadphase          /= n64
+  stats.Narrowphase         /= n64
+  stats.ContactSet          /= n64
+  stats.ArbiterPreStep      /= n64
+  stats.ConstraintPreStep   /= n64
+  stats.Solver              /= n64
+  stats.Callbacks           /= n64
+  stats.Total               /= n64
+  stats.ActiveBodies        /= n
+  stats.CandidatePairs      /= n
+  stats.Arbiters            /= n
+  stats.Contacts            /= n
+  stats.ContactBufferPushes /= n
+}
+
+// Called at the end of Step, to keep the history and call the hook.
+func (space *Space) finishStats() {
+  stats := &space.stats
+  if len(space.statsHistory) > 0 {
+    space.statsHistory[space.statsNext] = *stats
+    space.statsNext = (space.statsNext + 1) % len(space.statsHistory)
+    if space.statsCount < len(space.statsHistory) { space.statsCount++ }
+  }
+  if space.StatsHook != nil { space.StatsHook(space, stats) }
+}
+
+// Stats returns the statistics of the last step.
+func (space *Space) Stats() (StepStats) {
+  return space.stats
+}
+
+// SetStatsWindow keeps the statistics of the last n steps, for
+// StatsAverage. 0 stops keeping them.
+func (space *Space) SetStatsWindow(n int) {
+  Assert(n >= 0, "The window of the statistics can't be negative.")
+  space.statsHistory = make([]StepStats, n)
+  space.statsNext    = 0
+  space.statsCount   = 0
+}
+
+// StatsAverage returns the average statistics of the steps in the window
+// that SetStatsWindow set. Returns the statistics of the last step if
+// there is no window.
+func (space *Space) StatsAverage() (StepStats) {
+  if space.statsCount == 0 { return space.stats }
+  average := StepStats{}
+  for i:=0; i < space.statsCount; i++ {
+    average.add(&space.statsHistory[i])
+  }
+  average.divide(space.statsCount)
+  return average
+}
@@ -0,0 +1,565 @@
+package tamias
+
+// Loading of collision outlines from SVG drawings.
+//
+// Closed paths, polygons and rectangles become polygons, split into convex
+// pieces if they are concave. Open paths and polylines become chains of
+// segments, lines become segments, and circles and ellipses become
+// circles. Béziers and arcs are flattened into straight segments. Other
+// elements, and the contents of defs, are ignored.
+//
+// Shapes belong to SVGOptions.Body, unless they are in an element whose
+// data-body attribute, or else id, names a body in SVGOptions.Bodies.
+// Shapes of bodies with an infinite mass are added as static shapes.
+// These attributes of an element or its parents set the fields of the
+// shapes: data-friction, data-elasticity, data-collision-type, data-group,
+// data-layers, data-sensor, and data-radius for segments.
+//
+// The Y axis of SVG points down, so it is flipped to point up, with the
+// bottom of the drawing at 0. Transforms are applied; circles under a
+// stretching transform keep their average radius.
+
+import "io"
+import "os"
+import "strconv"
+import "strings"
+import "xml"
+
+type SVGOptions struct {
+  // Largest distance between a curve and the segments that replace it.
+  // 0.5 if 0.
+  Tolerance Float
+  // Body of the shapes that are not in a named element.
+  Body *Body
+  // Bodies of named elements, by data-body attribute or id.
+  Bodies map[string] *Body
+  // The most vertexes of the convex pieces of a polygon, or 0 for any
+  // number.
+  MaxVerts int
+}
+
+// An affine transform: x' = a*x + c*y + e, y' = b*x + d*y + f
+type svgMatrix struct {
+  a, b, c, d, e, f Float
+}
+
+var svgIdentity = svgMatrix{1, 0, 0, 1, 0, 0}
+
+func (m svgMatrix) apply(v Vect) (Vect) {
+  return V(m.a * v.X + m.c * v.Y + m.e, m.b * v.X + m.d * v.Y + m.f)
+}
+
+// Returns the transform that applies o first, and then m.
+func (m svgMatrix) mul(o svgMatrix) (svgMatrix) {
+  return svgMatrix{
+    m.a * o.a + m.c * o.b, m.b * o.a + m.d * o.b,
+    m.a * o.c + m.c * o.d, m.b * o.c + m.d * o.d,
+    m.a * o.e + m.c * o.f + m.e, m.b * o.e + m.d * o.f + m.f}
+}
+
+// Returns how much the transform scales lengths, on average.
+func (m svgMatrix) scale() (Float) {
+  return (m.a * m.d - m.b * m.c).Abs().Sqrt()
+}
+
+// Scans the numbers and letters of path data, points and transforms.
+type svgScanner struct {
+  s string
+  pos int
+}
+
+func (scan *svgScanner) skipSeparators() {
+  for scan.pos < len(scan.s) {
+    switch scan.s[scan.pos] {
+      case ' ', '\t', '\n', '\r', ',':
+        scan.pos++
+      default:
+        return
+    }
+  }
+}
+
+func svgIsDigit(c byte) (bool) {
+  return c >= '0' && c <= '9'
+}
+
+// Returns the next number, or false if the next thing is not a number.
+// Units after the number, like px, are skipped.
+func (scan *svgScanner) number() (Float, bool) {
+  scan.skipSeparators()
+  s     := scan.s
+  start := scan.pos
+  i     := start
+  if i < len(s) && (s[i] == '-' || s[i] == '+') { i++ }
+  digits := 0
+  for ; i < len(s) && svgIsDigit(s[i]); i++ { digits++ }
+  if i < len(s) && s[i] == '.' {
+    for i++; i < len(s) && svgIsDigit(s[i]); i++ { digits++ }
+  }
+  if digits == 0 { return 0, false }
+  if i + 1 < len(s) && (s[i] == 'e' || s[i] == 'E') &&
+     (svgIsDigit(s[i + 1]) || s[i + 1] == '-' || s[i + 1] == '+') {
+    for i += 2; i < len(s) && svgIsDigit(s[i]); i++ { }
+  }
+  f, err := strconv.Atof64(s[start:i])
+  if err != nil { return 0, false }
+  scan.pos = i
+  return F64Float(f), true
+}
+
+// Returns the next letter, or 0 if the next thing is not a letter.
+func (scan *svgScanner) letter() (byte) {
+  scan.skipSeparators()
+  if scan.pos >= len(scan.s) { return 0 }
+  c := scan.s[scan.pos]
+  if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+    scan.pos++
+    return c
+  }
+  return 0
+}
+
+func (scan *svgScanner) done() (bool) {
+  scan.skipSeparators()
+  return scan.pos >= len(scan.s)
+}
+
+// Parses a single number like "12.5px", or returns 0.
+func svgNumber(value string) (Float) {
+  scan := &svgScanner{value, 0}
+  f, _ := scan.number()
+  return f
+}
+
+func svgNumberAttr(start *xml.StartElement, name string) (Float) {
+  return svgNumber(xmlAttr(start, name))
+}
+
+// Parses the points of polygons and polylines.
+func svgPoints(value string) ([]Vect) {
+  scan   := &svgScanner{value, 0}
+  points := make([]Vect, 0)
+  for {
+    x, okx := scan.number()
+    y, oky := scan.number()
+    if !okx || !oky { break }
+    points = append(points, V(x, y))
+  }
+  return points
+}
+
+// Parses a transform attribute.
+func svgTransform(value string) (svgMatrix) {
+  m    := svgIdentity
+  scan := &svgScanner{value, 0}
+  for !scan.done() {
+    open := strings.Index(value[scan.pos:], "(")
+    if open < 0 { break }
+    name     := strings.TrimSpace(value[scan.pos:scan.pos + open])
+    scan.pos += open + 1
+    args     := make([]Float, 0, 6)
+    for f, ok := scan.number(); ok; f, ok = scan.number() {
+      args = append(args, f)
+    }
+    scan.skipSeparators()
+    if scan.pos < len(value) && value[scan.pos] == ')' { scan.pos++ }
+    count := len(args)
+    for len(args) < 3 {
+      args = append(args, 0)
+    }
+    t := svgIdentity
+    switch name {
+      case "matrix":
+        if count == 6 {
+          t = svgMatrix{args[0], args[1], args[2], args[3], args[4], args[5]}
+        }
+      case "translate":
+        t.e, t.f = args[0], args[1]
+      case "scale":
+        t.a, t.d = args[0], args[1]
+        if count == 1 { t.d = args[0] }
+      case "rotate":
+        rot := (args[0] * PI / 180.0).VectForAngle()
+        t    = svgMatrix{rot.X, rot.Y, -rot.Y, rot.X, 0, 0}
+        // Rotation around the point (args[1], args[2]).
+        around := svgMatrix{1, 0, 0, 1, args[1], args[2]}
+        back   := svgMatrix{1, 0, 0, 1, -args[1], -args[2]}
+        t       = around.mul(t).mul(back)
+      case "skewX":
+        t.c = (args[0] * PI / 180.0).Tan()
+      case "skewY":
+        t.b = (args[0] * PI / 180.0).Tan()
+    }
+    m = m.mul(t)
+  }
+  return m
+}
+
+// Flattens a cubic Bézier from p0 to p3 into points, which are appended to
+// out, without p0.
+func svgFlattenCubic(p0, p1, p2, p3 Vect, tol Float, out []Vect,
+  depth int) ([]Vect) {
+  chord  := p3.Sub(p0)
+  length := chord.Length()
+  var d1, d2 Float
+  if length > 0 {
+    d1 = p1.Sub(p0).Cross(chord).Abs() / length
+    d2 = p2.Sub(p0).Cross(chord).Abs() / length
+  } else {
+    d1 = p1.Dist(p0)
+    d2 = p2.Dist(p0)
+  }
+  if d1.Max(d2) <= tol || depth >= 16 {
+    return append(out, p3)
+  }
+  // Split in two halves, with de Casteljau.
+  p01   := p0.Lerp(p1, 0.5)
+  p12   := p1.Lerp(p2, 0.5)
+  p23   := p2.Lerp(p3, 0.5)
+  p012  := p01.Lerp(p12, 0.5)
+  p123  := p12.Lerp(p23, 0.5)
+  mid   := p012.Lerp(p123, 0.5)
+  out    = svgFlattenCubic(p0, p01, p012, mid, tol, out, depth + 1)
+  return svgFlattenCubic(mid, p123, p23, p3, tol, out, depth + 1)
+}
+
+// Flattens an elliptical arc from p0 to p1, as described by the arc
+// command of paths, into points appended to out, without p0.
+func svgFlattenArc(p0 Vect, rx, ry, angle Float, large, sweep bool,
+  p1 Vect, tol Float, out []Vect) ([]Vect) {
+  rx, ry = rx.Abs(), ry.Abs()
+  if rx == 0 || ry == 0 || p0.Equals(p1) { return append(out, p1) }
+  rot := (angle * PI / 180.0).VectForAngle()
+  // Endpoints in the frame of the ellipse, around the middle.
+  d   := p0.Sub(p1).Mult(0.5).Unrotate(rot)
+  lambda := (d.X * d.X) / (rx * rx) + (d.Y * d.Y) / (ry * ry)
+  if lambda > 1 {
+    rx, ry = rx * lambda.Sqrt(), ry * lambda.Sqrt()
+  }
+  num  := rx * rx * ry * ry - rx * rx * d.Y * d.Y - ry * ry * d.X * d.X
+  den  := rx * rx * d.Y * d.Y + ry * ry * d.X * d.X
+  coef := Float(0.0)
+  if den > 0 { coef = (num / den).Max(0).Sqrt() }
+  if large == sweep { coef = -coef }
+  c      := V(coef * rx * d.Y / ry, -coef * ry * d.X / rx)
+  center := c.Rotate(rot).Add(p0.Lerp(p1, 0.5))
+  start  := Float((d.X - c.X) / rx).Atan2((d.Y - c.Y) / ry)
+  end    := Float((-d.X - c.X) / rx).Atan2((-d.Y - c.Y) / ry)
+  delta  := end - start
+  if !sweep && delta > 0 {
+    delta -= 2 * PI
+  } else if sweep && delta < 0 {
+    delta += 2 * PI
+  }
+  // Steps small enough that the chords stay within the tolerance.
+  step := PI / 2
+  if r := rx.Max(ry); tol < r {
+    step = step.Min(2 * (1 - tol / r).Acos())
+  }
+  n := int(-(-delta.Abs() / step).Floor())
+  if n < 1 { n = 1 }
+  for i:=1; i < n; i++ {
+    t := start + delta * Float(i) / Float(n)
+    p := V(rx * t.Cos(), ry * t.Sin()).Rotate(rot).Add(center)
+    out = append(out, p)
+  }
+  return append(out, p1)
+}
+
+// The properties, transform and body of an element, which it passes on to
+// its children.
+type svgContext struct {
+  matrix svgMatrix
+  body *Body
+  props map[string] string
+}
+
+// Reads an SVG drawing, and adds its shapes to a space.
+type svgBuilder struct {
+  xmlReader
+  space *Space
+  options *SVGOptions
+  shapes []*Shape
+}
+
+func (builder *svgBuilder) context(start *xml.StartElement,
+  parent *svgContext) (*svgContext) {
+  ctx := &svgContext{parent.matrix, parent.body, parent.props}
+  if transform := xmlAttr(start, "transform"); transform != "" {
+    ctx.matrix = parent.matrix.mul(svgTransform(transform))
+  }
+  name := xmlAttr(start, "data-body")
+  if name == "" { name = xmlAttr(start, "id") }
+  if body, ok := builder.options.Bodies[name]; ok && name != "" {
+    ctx.body = body
+  }
+  copied := false
+  for _, attr := range start.Attr {
+    if !strings.HasPrefix(attr.Name.Local, "data-") { continue }
+    if !copied {
+      copied    = true
+      ctx.props = make(map[string] string)
+      for key, value := range parent.props {
+        ctx.props[key] = value
+      }
+    }
+    ctx.props[attr.Name.Local[len("data-"):]] = attr.Value
+  }
+  return ctx
+}
+
+func (builder *svgBuilder) tolerance(ctx *svgContext) (Float) {
+  tol := builder.options.Tolerance
+  if tol <= 0 { tol = 0.5 }
+  if scale := ctx.matrix.scale(); scale > 0 { tol /= scale }
+  return tol
+}
+
+// Converts points of the drawing to the frame of the body.
+func (builder *svgBuilder) local(ctx *svgContext, points []Vect) ([]Vect) {
+  result := make([]Vect, len(points))
+  for i, p := range points {
+    result[i] = ctx.body.World2Local(ctx.matrix.apply(p))
+  }
+  return result
+}
+
+func (builder *svgBuilder) add(ctx *svgContext, shape *Shape) {
+  props := ctx.props
+  if value, ok := props["friction"]; ok {
+    shape.u = svgNumber(value)
+  }
+  if value, ok := props["elasticity"]; ok {
+    shape.e = svgNumber(value)
+  }
+  if value, ok := props["collision-type"]; ok {
+    i, err := strconv.Btoi64(value, 0)
+    if err == nil { shape.collision_type = CollisionType(i) }
+  }
+  if value, ok := props["group"]; ok {
+    i, err := strconv.Btoi64(value, 0)
+    if err == nil { shape.group = GroupType(i) }
+  }
+  if value, ok := props["layers"]; ok {
+    i, err := strconv.Btoui64(value, 0)
+    if err == nil { shape.layers = LayerType(i) }
+  }
+  if value, ok := props["sensor"]; ok {
+    b, err := strconv.Atob(value)
+    if err == nil { shape.sensor = b }
+  }
+  if ctx.body.m == INFINITY {
+    builder.space.AddStaticShape(shape)
+  } else {
+    builder.space.AddShape(shape)
+  }
+  builder.shapes = append(builder.shapes, shape)
+}
+
+func (builder *svgBuilder) addPolygon(ctx *svgContext, points []Vect,
+  id string) {
+  if len(points) < 3 {
+    builder.addChain(ctx, points)
+    return
+  }
+  pieces, err := ConvexDecompose(builder.local(ctx, points),
+    builder.options.MaxVerts)
+  if err != nil {
+    builder.fail("polygon " + id + ": " + err.String())
+    return
+  }
+  for _, piece := range pieces {
+    builder.add(ctx, PolyShapeNew(ctx.body, piece, VZERO).Shape)
+  }
+}
+
+func (builder *svgBuilder) addChain(ctx *svgContext, points []Vect) {
+  radius := svgNumber(ctx.props["radius"])
+  local  := builder.local(ctx, points)
+  for _, segment := range SegmentShapesNew(ctx.body, local, radius) {
+    builder.add(ctx, segment.Shape)
+  }
+}
+
+func (builder *svgBuilder) addCircle(ctx *svgContext, center Vect,
+  radius Float) {
+  center  = builder.local(ctx, []Vect{center})[0]
+  radius *= ctx.matrix.scale()
+  builder.add(ctx, CircleShapeNew(ctx.body, radius, center).Shape)
+}
+
+// Adds the subpaths of path data.
+func (builder *svgBuilder) addPath(ctx *svgContext, d string, id string) {
+  tol  := builder.tolerance(ctx)
+  scan := &svgScanner{d, 0}
+  var cur, start, ctrl Vect
+  var command, last byte
+  points := make([]Vect, 0)
+  finish := func(closed bool) {
+    if closed {
+      builder.addPolygon(ctx, points, id)
+    } else if len(points) > 1 {
+      builder.addChain(ctx, points)
+    }
+    points = make([]Vect, 0)
+  }
+  for !scan.done() && builder.err == nil {
+    if c := scan.letter(); c != 0 {
+      command = c
+    } else if command == 0 {
+      builder.fail("bad path data in " + id)
+      return
+    }
+    relative := command >= 'a'
+    origin   := VZERO
+    if relative { origin = cur }
+    // Reads a point, relative to the current point for lower case commands.
+    point := func() (Vect) {
+      x, _ := scan.number()
+      y, _ := scan.number()
+      return origin.Add(V(x, y))
+    }
+    switch command {
+      case 'M', 'm':
+        if len(points) > 0 { finish(false) }
+        cur    = point()
+        start  = cur
+        points = append(points, cur)
+        // Further points are lines.
+        if relative { command = 'l' } else { command = 'L' }
+      case 'L', 'l':
+        cur    = point()
+        points = append(points, cur)
+      case 'H', 'h':
+        x, _  := scan.number()
+        cur    = V(origin.X + x, cur.Y)
+        points = append(points, cur)
+      case 'V', 'v':
+        y, _  := scan.number()
+        cur    = V(cur.X, origin.Y + y)
+        points = append(points, cur)
+      case 'C', 'c', 'S', 's':
+        var p1 Vect
+        if command == 'S' || command == 's' {
+          // The first control point mirrors the last one of the previous curve.
+          p1 = cur
+          if last == 'C' || last == 'c' || last == 'S' || last == 's' {
+            p1 = cur.Add(cur.Sub(ctrl))
+          }
+        } else {
+          p1 = point()
+        }
+        p2    := point()
+        p3    := point()
+        points = svgFlattenCubic(cur, p1, p2, p3, tol, points, 0)
+        cur, ctrl = p3, p2
+      case 'Q', 'q', 'T', 't':
+        var q Vect
+        if command == 'T' || command == 't' {
+          q = cur
+          if last == 'Q' || last == 'q' || last == 'T' || last == 't' {
+            q = cur.Add(cur.Sub(ctrl))
+          }
+        } else {
+          q = point()
+        }
+        p3 := point()
+        // A quadratic Bézier is a cubic one with these control points.
+        p1    := cur.Lerp(q, 2.0 / 3.0)
+        p2    := p3.Lerp(q, 2.0 / 3.0)
+        points = svgFlattenCubic(cur, p1, p2, p3, tol, points, 0)
+        cur, ctrl = p3, q
+      case 'A', 'a':
+        rx, _    := scan.number()
+        ry, _    := scan.number()
+        angle, _ := scan.number()
+        large, _ := scan.number()
+        sweep, _ := scan.number()
+        p1       := point()
+        points    = svgFlattenArc(cur, rx, ry, angle, large != 0, sweep != 0,
+          p1, tol, points)
+        cur       = p1
+      case 'Z', 'z':
+        // The last point is often the first one again.
+        if len(points) > 1 && points[len(points) - 1].Equals(start) {
+          points = points[0:len(points) - 1]
+        }
+        finish(true)
+        cur    = start
+        points = append(points, cur)
+      default:
+        builder.fail("unknown path command " + string(command) + " in " + id)
+        return
+    }
+    last = command
+    if command == 'Z' || command == 'z' {
+      // Z takes no numbers, so it doesn't repeat.
+      command = 0
+    }
+  }
+  finish(false)
+}
+
+func (builder *svgBuilder) element(start *xml.StartElement,
+  parent *svgContext) {
+  ctx := builder.context(start, parent)
+  id  := xmlAttr(start, "id")
+  switch start.Name.Local {
+    case "svg", "g", "a", "switch":
+      for child := builder.child(); child != nil; child = builder.child() {
+        builder.element(child, ctx)
+      }
+      return
+    case "path":
+      builder.addPath(ctx, xmlAttr(start, "d"), id)
+    case "polygon":
+      builder.addPolygon(ctx, svgPoints(xmlAttr(start, "points")), id)
+    case "polyline":
+      builder.addChain(ctx, svgPoints(xmlAttr(start, "points")))
+    case "rect":
+      x, y := svgNumberAttr(start, "x"), svgNumberAttr(start, "y")
+      w, h := svgNumberAttr(start, "width"), svgNumberAttr(start, "height")
+      if w > 0 && h > 0 {
+        builder.addPolygon(ctx, []Vect{V(x, y), V(x + w, y),
+          V(x + w, y + h), V(x, y + h)}, id)
+      }
+    case "circle":
+      builder.addCircle(ctx, V(svgNumberAttr(start, "cx"),
+        svgNumberAttr(start, "cy")), svgNumberAttr(start, "r"))
+    case "ellipse":
+      // Circles can't be stretched, so use the average radius.
+      radius := (svgNumberAttr(start, "rx") + svgNumberAttr(start, "ry")) / 2
+      builder.addCircle(ctx, V(svgNumberAttr(start, "cx"),
+        svgNumberAttr(start, "cy")), radius)
+    case "line":
+      builder.addChain(ctx, []Vect{
+        V(svgNumberAttr(start, "x1"), svgNumberAttr(start, "y1")),
+        V(svgNumberAttr(start, "x2"), svgNumberAttr(start, "y2"))})
+  }
+  builder.skip()
+}
+
+// LoadSVG reads an SVG drawing from r, and adds its shapes to the space.
+// It returns the shapes that were added.
+func LoadSVG(space *Space, r io.Reader, options *SVGOptions) ([]*Shape,
+  os.Error) {
+  Assert(options.Body != nil, "SVGOptions need a body.")
+  builder := &svgBuilder{xmlReader{parser: xml.NewParser(r), prefix: "svg"},
+    space, options, make([]*Shape, 0)}
+  start := builder.child()
+  if start == nil || start.Name.Local != "svg" {
+    if builder.err != nil { return nil, builder.err }
+    return nil, os.NewError("svg: not an SVG drawing")
+  }
+  // Flip the Y axis, so the bottom of the view box is at 0.
+  var minX, minY, height Float
+  if viewBox := svgPoints(xmlAttr(start, "viewBox")); len(viewBox) == 2 {
+    minX, minY, height = viewBox[0].X, viewBox[0].Y, viewBox[1].Y
+  } else {
+    height = svgNumberAttr(start, "height")
+  }
+  flip := svgMatrix{1, 0, 0, -1, -minX, minY + height}
+  root := &svgContext{flip, options.Body, make(map[string] string)}
+  builder.element(start, root)
+  return builder.shapes, builder.err
+}
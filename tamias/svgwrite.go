@@ -0,0 +1,226 @@
+package tamias
+
+// Export of the state of a space as an SVG image, for bug reports.
+//
+// Coordinates in the file are world coordinates; a transform on the root
+// group flips the Y axis. Every shape is a group with the id "shape-N",
+// where N is the hash id of the shape, and the attributes data-shape,
+// data-body, data-collision-type, data-group and data-layers, and
+// data-static and data-sensor when they are true. Bodies are numbered like
+// in SceneFromSpace. Constraints are groups with the id "constraint-N" and
+// the bodies in data-body-a and data-body-b. Arbiters are groups with the
+// shapes in data-shape-a and data-shape-b, and a circle for each contact
+// point with its depth in data-dist.
+
+import "fmt"
+import "io"
+import "os"
+
+type SVGWriteOptions struct {
+  // What to draw, and in which colors.
+  DebugDrawOptions
+  // Pixels per world unit in the width and height of the image. 1 if 0.
+  Scale Float
+  // Room around the contents, in world units.
+  Margin Float
+}
+
+var DefaultSVGWriteOptions = SVGWriteOptions{DefaultDebugDrawOptions, 1.0,
+  10.0}
+
+// Writes SVG text, and remembers the first error.
+type svgWriter struct {
+  w io.Writer
+  err os.Error
+  options *SVGWriteOptions
+  // Width of lines, in world units.
+  stroke Float
+}
+
+func (out *svgWriter) printf(format string, args ...interface{}) {
+  if out.err != nil { return }
+  _, out.err = fmt.Fprintf(out.w, format, args...)
+}
+
+func svgColor(color DebugColor) (string) {
+  channel := func(c Float) (int) {
+    return int((c.Max(0.0).Min(1.0) * 255.0 + 0.5).Floor())
+  }
+  return fmt.Sprintf("rgb(%d,%d,%d)", channel(color.R), channel(color.G),
+    channel(color.B))
+}
+
+// Returns the paint attributes of an element.
+func (out *svgWriter) paint(outline, fill DebugColor) (string) {
+  return fmt.Sprintf(`stroke="%s" stroke-opacity="%g" stroke-width="%g" ` +
+    `fill="%s" fill-opacity="%g"`, svgColor(outline), outline.A, out.stroke,
+    svgColor(fill), fill.A)
+}
+
+func (out *svgWriter) line(a, b Vect, color DebugColor) {
+  out.printf(`<line x1="%g" y1="%g" x2="%g" y2="%g" %s/>` + "\n",
+    a.X, a.Y, b.X, b.Y, out.paint(color, DebugColor{}))
+}
+
+func (out *svgWriter) dot(p Vect, radius Float, color DebugColor) {
+  out.printf(`<circle cx="%g" cy="%g" r="%g" %s/>` + "\n",
+    p.X, p.Y, radius, out.paint(color, color))
+}
+
+func (out *svgWriter) shape(shape *Shape, bodyId int, static bool) {
+  options := out.options
+  out.printf(`<g id="shape-%d" class="shape" data-shape="%d" ` +
+    `data-body="%d" data-collision-type="%d" data-group="%d" ` +
+    `data-layers="%d"`, shape.hashid, shape.hashid, bodyId,
+    shape.collision_type, shape.group, shape.layers)
+  if static { out.printf(` data-static="true"`) }
+  if shape.sensor { out.printf(` data-sensor="true"`) }
+  out.printf(">\n")
+
+  if options.Shapes {
+    body  := shape.Body
+    color := options.shapeColor(shape, static)
+    paint := out.paint(color, color.Translucent())
+    switch geometry := shape.geometry.(type) {
+      case *CircleShape:
+        c := body.Local2World(geometry.c)
+        out.printf(`<circle class="circle" cx="%g" cy="%g" r="%g" %s/>` + "\n",
+          c.X, c.Y, geometry.r, paint)
+        out.line(c, c.Add(body.rot.Mult(geometry.r)), color)
+      case *SegmentShape:
+        a := body.Local2World(geometry.a)
+        b := body.Local2World(geometry.b)
+        if geometry.r > 0 {
+          fill := color.Translucent()
+          out.printf(`<line class="segment" x1="%g" y1="%g" x2="%g" ` +
+            `y2="%g" stroke="%s" stroke-opacity="%g" stroke-width="%g" ` +
+            `stroke-linecap="round"/>` + "\n", a.X, a.Y, b.X, b.Y,
+            svgColor(fill), fill.A, 2 * geometry.r)
+        }
+        out.line(a, b, color)
+      case *PolyShape:
+        points := ""
+        for i:=0; i < geometry.numVerts; i++ {
+          v := body.Local2World(geometry.verts[i])
+          points += fmt.Sprintf("%g,%g ", v.X, v.Y)
+        }
+        if geometry.r > 0 {
+          // The rim of a rounded polygon is a wide stroke with round joins.
+          fill := color.Translucent()
+          out.printf(`<polygon class="poly-rim" points="%s" fill="none" ` +
+            `stroke="%s" stroke-opacity="%g" stroke-width="%g" ` +
+            `stroke-linejoin="round"/>` + "\n", points, svgColor(fill), fill.A,
+            2 * geometry.r)
+        }
+        out.printf(`<polygon class="poly" points="%s" %s/>` + "\n", points,
+          paint)
+      case *HeightfieldShape:
+        points := ""
+        for i := range geometry.heights {
+          v := body.Local2World(geometry.point(i))
+          points += fmt.Sprintf("%g,%g ", v.X, v.Y)
+        }
+        out.printf(`<polyline class="heightfield" points="%s" %s/>` + "\n",
+          points, out.paint(color, DebugColor{}))
+    }
+  }
+  if options.BBs && shape.BB != nil {
+    bb   := shape.BB
+    b, t := bb.B.Min(bb.T), bb.B.Max(bb.T)
+    out.printf(`<rect class="bb" x="%g" y="%g" width="%g" height="%g" %s/>` +
+      "\n", bb.L, b, bb.R - bb.L, t - b, out.paint(options.BBColor,
+      DebugColor{}))
+  }
+  out.printf("</g>\n")
+}
+
+// WriteSVG writes an SVG image of the shapes, constraint anchors, contact
+// points and bounding boxes of the space to w, as the options say. Uses
+// the default options if options is nil.
+func (space *Space) WriteSVG(w io.Writer, options *SVGWriteOptions) (
+  os.Error) {
+  if options == nil { options = &DefaultSVGWriteOptions }
+  scale := options.Scale
+  if scale <= 0 { scale = 1.0 }
+  out := &svgWriter{w: w, options: options, stroke: 1.0 / scale}
+
+  active := sortedShapes(space.activeShapes)
+  static := sortedShapes(space.staticShapes)
+  // Number the bodies like SceneFromSpace, so the ids match saved scenes.
+  builder := newSceneBuilder(space)
+
+  // The view box holds all shapes.
+  bounds := BB{}
+  first  := true
+  for _, list := range [][]*Shape{active, static} {
+    for _, shape := range list {
+      if shape.BB == nil { continue }
+      bb := *shape.BB
+      bb.B, bb.T = bb.B.Min(bb.T), bb.B.Max(bb.T)
+      if first {
+        bounds, first = bb, false
+      } else {
+        bounds = BB{L: bounds.L.Min(bb.L), B: bounds.B.Min(bb.B),
+          R: bounds.R.Max(bb.R), T: bounds.T.Max(bb.T)}
+      }
+    }
+  }
+  bounds.L -= options.Margin
+  bounds.B -= options.Margin
+  bounds.R += options.Margin
+  bounds.T += options.Margin
+  width, height := bounds.R - bounds.L, bounds.T - bounds.B
+
+  out.printf(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+  out.printf(`<svg xmlns="http://www.w3.org/2000/svg" width="%g" ` +
+    `height="%g" viewBox="%g %g %g %g" data-stamp="%d">` + "\n",
+    width * scale, height * scale, bounds.L, -bounds.T, width, height,
+    space.stamp)
+  out.printf(`<g transform="scale(1,-1)">` + "\n")
+
+  for _, shape := range active {
+    out.shape(shape, builder.bodyId(shape.Body, true), false)
+  }
+  for _, shape := range static {
+    out.shape(shape, builder.bodyId(shape.Body, true), true)
+  }
+
+  if options.Constraints {
+    color := options.ConstraintColor
+    for i:=0; i < space.constraints.Size(); i++ {
+      constraint := space.constraints.Index(i).(Constraint)
+      a, b       := ConstraintAnchors(constraint)
+      base       := constraintBase(constraint)
+      if base == nil { continue }
+      out.printf(`<g id="constraint-%d" class="constraint" ` +
+        `data-body-a="%d" data-body-b="%d">` + "\n", i,
+        builder.bodyId(base.a, true), builder.bodyId(base.b, true))
+      out.line(a, b, color)
+      out.dot(a, 2.0 * out.stroke, color)
+      out.dot(b, 2.0 * out.stroke, color)
+      out.printf("</g>\n")
+    }
+  }
+
+  if options.Contacts {
+    color := options.ContactColor
+    space.EachArbiter(func(arb *Arbiter) {
+      a, b := arb.GetShapes()
+      out.printf(`<g class="arbiter" data-shape-a="%d" data-shape-b="%d">` +
+        "\n", a.hashid, b.hashid)
+      for i:=0; i < arb.numContacts; i++ {
+        con := &arb.contacts[i]
+        p   := arb.GetPoint(i)
+        out.printf(`<circle class="contact" cx="%g" cy="%g" r="%g" ` +
+          `data-dist="%g" %s/>` + "\n", p.X, p.Y, 2.0 * out.stroke,
+          con.Dist, out.paint(color, color))
+        n := arb.GetNormal(i).Mult(10.0 * out.stroke)
+        out.line(p, p.Add(n), color)
+      }
+      out.printf("</g>\n")
+    })
+  }
+
+  out.printf("</g>\n</svg>\n")
+  return out.err
+}
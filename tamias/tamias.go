@@ -28,3 +28,13 @@ func Assert(cond bool, err string)  {
 
 
 
+
+func imin(a, b int) (int) {
+  if a < b { return a }
+  return b
+}
+
+func imax(a, b int) (int) {
+  if a > b { return a }
+  return b
+}
@@ -0,0 +1,295 @@
+package tamias
+
+// Tilemaps: static shapes for grids of solid and empty tiles, that change
+// one chunk at a time when tiles are dug out or built.
+
+// How a Tilemap makes shapes.
+type TilemapOptions struct {
+  // Makes chains of segments along the edges of the solid tiles if true,
+  // or else boxes of solid tiles merged with MergeTiles.
+  Chains bool
+  // Radius of the segments.
+  Radius Float
+  // Called for every new shape, to set its friction and the like.
+  Setup func(shape *Shape)
+}
+
+var DefaultTilemapOptions = TilemapOptions{}
+
+// Tilemap keeps the static shapes of a grid of tiles in a space. Tile
+// (x, y) covers (x * size.X, y * size.Y) to ((x + 1) * size.X,
+// (y + 1) * size.Y) in the space of the body, so rows go up. The grid is
+// split in square chunks of tiles with their own shapes, and changed tiles
+// only rebuild the chunks they touch.
+type Tilemap struct {
+  space *Space
+  body *Body
+  solid []bool
+  width, height int
+  size Vect
+  options TilemapOptions
+  chunkSize, chunksX, chunksY int
+  chunks [][]*Shape
+  dirty []bool
+}
+
+func TilemapAlloc() (*Tilemap) {
+  return &Tilemap{}
+}
+
+// Init makes the shapes of a grid of width by height tiles of the given
+// size, in chunks of chunkSize by chunkSize tiles, and adds them to the
+// space as static shapes on the body. solid holds the tiles row by row,
+// from the bottom row up, and is copied. Uses the default options if
+// options is nil.
+func (tiles *Tilemap) Init(space *Space, body *Body, solid []bool,
+  width, height int, size Vect, chunkSize int,
+  options *TilemapOptions) (*Tilemap) {
+  Assert(len(solid) >= width * height, "Grid is smaller than its size.")
+  Assert(chunkSize > 0, "Chunks need at least one tile.")
+  if options == nil { options = &DefaultTilemapOptions }
+  tiles.space     = space
+  tiles.body      = body
+  tiles.solid     = make([]bool, width * height)
+  copy(tiles.solid, solid)
+  tiles.width     = width
+  tiles.height    = height
+  tiles.size      = size
+  tiles.options   = *options
+  tiles.chunkSize = chunkSize
+  tiles.chunksX   = (width + chunkSize - 1) / chunkSize
+  tiles.chunksY   = (height + chunkSize - 1) / chunkSize
+  tiles.chunks    = make([][]*Shape, tiles.chunksX * tiles.chunksY)
+  tiles.dirty     = make([]bool, len(tiles.chunks))
+  for i := range tiles.chunks {
+    tiles.generate(i)
+  }
+  return tiles
+}
+
+func TilemapNew(space *Space, body *Body, solid []bool, width, height int,
+  size Vect, chunkSize int, options *TilemapOptions) (*Tilemap) {
+  return TilemapAlloc().Init(space, body, solid, width, height, size,
+    chunkSize, options)
+}
+
+// Solid returns true if tile (x, y) is solid. Tiles outside of the grid
+// are empty.
+func (tiles *Tilemap) Solid(x, y int) (bool) {
+  if x < 0 || y < 0 || x >= tiles.width || y >= tiles.height { return false }
+  return tiles.solid[y * tiles.width + x]
+}
+
+// SetTile makes tile (x, y) solid or empty. The shapes change on the next
+// Update, so many tiles can change at once.
+func (tiles *Tilemap) SetTile(x, y int, solid bool) {
+  Assert(x >= 0 && y >= 0 && x < tiles.width && y < tiles.height,
+    "The tile is outside of the grid.")
+  if tiles.solid[y * tiles.width + x] == solid { return }
+  tiles.solid[y * tiles.width + x] = solid
+  // The edges of the tiles around it change too, and so do the neighbours
+  // of the chains next to them.
+  size := tiles.chunkSize
+  for cy := imax(y - 1, 0) / size; cy <= imin(y + 1, tiles.height - 1) / size;
+    cy++ {
+    for cx := imax(x - 1, 0) / size; cx <= imin(x + 1, tiles.width - 1) / size;
+      cx++ {
+      tiles.dirty[cy * tiles.chunksX + cx] = true
+    }
+  }
+}
+
+// Update rebuilds the chunks with tiles that changed since the last update.
+func (tiles *Tilemap) Update() {
+  for i, dirty := range tiles.dirty {
+    if dirty { tiles.generate(i) }
+  }
+}
+
+// Returns the corner (x, y) of the tiles in body space.
+func (tiles *Tilemap) point(x, y int) (Vect) {
+  return V(Float(x) * tiles.size.X, Float(y) * tiles.size.Y)
+}
+
+func (tiles *Tilemap) add(shape *Shape) {
+  if tiles.options.Setup != nil { tiles.options.Setup(shape) }
+  tiles.space.AddStaticShape(shape)
+}
+
+// Replaces the shapes of a chunk.
+func (tiles *Tilemap) generate(chunk int) {
+  for _, shape := range tiles.chunks[chunk] {
+    tiles.space.RemoveStaticShape(shape)
+  }
+  size   := tiles.chunkSize
+  x0, y0 := (chunk % tiles.chunksX) * size, (chunk / tiles.chunksX) * size
+  x1, y1 := imin(x0 + size, tiles.width), imin(y0 + size, tiles.height)
+  if tiles.options.Chains {
+    tiles.chunks[chunk] = tiles.addChains(x0, y0, x1, y1)
+  } else {
+    tiles.chunks[chunk] = tiles.addBoxes(x0, y0, x1, y1)
+  }
+  tiles.dirty[chunk] = false
+}
+
+// Adds boxes for the solid tiles from (x0, y0) up to (x1, y1).
+func (tiles *Tilemap) addBoxes(x0, y0, x1, y1 int) ([]*Shape) {
+  w, h  := x1 - x0, y1 - y0
+  solid := make([]bool, w * h)
+  for y:=0; y < h; y++ {
+    for x:=0; x < w; x++ {
+      solid[y * w + x] = tiles.Solid(x0 + x, y0 + y)
+    }
+  }
+  shapes := make([]*Shape, 0)
+  for _, rect := range MergeTiles(solid, w, h) {
+    l, b := x0 + rect.X, y0 + rect.Y
+    r, t := l + rect.W, b + rect.H
+    verts := []Vect{tiles.point(l, b), tiles.point(l, t), tiles.point(r, t),
+      tiles.point(r, b)}
+    shape := PolyShapeNew(tiles.body, verts, VZERO).Shape
+    tiles.add(shape)
+    shapes = append(shapes, shape)
+  }
+  return shapes
+}
+
+// An edge between a solid and an empty tile, from corner (x, y) one tile
+// in direction dir, with the solid tile on its right. The directions are
+// +X, +Y, -X and -Y, counter-clockwise, so edges go clockwise around the
+// solid tiles.
+type tileEdge struct {
+  x, y, dir int
+}
+
+var tileDirs = [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+
+// Returns the corner at the end of the edge.
+func (edge tileEdge) end() (x, y int) {
+  return edge.x + tileDirs[edge.dir][0], edge.y + tileDirs[edge.dir][1]
+}
+
+// Returns the tile on the right of the edge, which is solid.
+func (edge tileEdge) tile() (x, y int) {
+  switch edge.dir {
+    case 0: return edge.x, edge.y - 1
+    case 1: return edge.x, edge.y
+    case 2: return edge.x - 1, edge.y
+  }
+  return edge.x - 1, edge.y - 1
+}
+
+// Returns true if there is a solid tile on the right of the edge, and an
+// empty one on its left.
+func (tiles *Tilemap) isEdge(edge tileEdge) (bool) {
+  x, y   := edge.tile()
+  dx, dy := tileDirs[(edge.dir + 1) % 4][0], tileDirs[(edge.dir + 1) % 4][1]
+  return tiles.Solid(x, y) && !tiles.Solid(x + dx, y + dy)
+}
+
+// Returns the edge after this one, turning right where two solid tiles
+// only touch at a corner, so they stay apart.
+func (tiles *Tilemap) nextEdge(edge tileEdge) (tileEdge, bool) {
+  x, y := edge.end()
+  for _, turn := range []int{3, 0, 1} {
+    next := tileEdge{x, y, (edge.dir + turn) % 4}
+    if tiles.isEdge(next) { return next, true }
+  }
+  return tileEdge{}, false
+}
+
+// Returns the edge before this one.
+func (tiles *Tilemap) prevEdge(edge tileEdge) (tileEdge, bool) {
+  for _, turn := range []int{0, 1, 3} {
+    dir  := (edge.dir + turn) % 4
+    prev := tileEdge{edge.x - tileDirs[dir][0], edge.y - tileDirs[dir][1], dir}
+    if !tiles.isEdge(prev) { continue }
+    if next, ok := tiles.nextEdge(prev); ok && next == edge {
+      return prev, true
+    }
+  }
+  return tileEdge{}, false
+}
+
+// Adds chains of segments along the edges of the solid tiles from (x0, y0)
+// up to (x1, y1). The chains of neighbouring chunks meet at the borders,
+// and know the vertexes across them.
+func (tiles *Tilemap) addChains(x0, y0, x1, y1 int) ([]*Shape) {
+  inChunk := func(edge tileEdge) (bool) {
+    x, y := edge.tile()
+    return x >= x0 && x < x1 && y >= y0 && y < y1
+  }
+  visited := make(map[tileEdge]bool)
+  shapes  := make([]*Shape, 0)
+  for y := y0; y <= y1; y++ {
+    for x := x0; x <= x1; x++ {
+      for dir:=0; dir < 4; dir++ {
+        edge := tileEdge{x, y, dir}
+        if visited[edge] || !inChunk(edge) || !tiles.isEdge(edge) { continue }
+        // Go back to the start of the chain, or around the loop.
+        start := edge
+        for {
+          prev, ok := tiles.prevEdge(start)
+          if !ok || !inChunk(prev) || prev == edge { break }
+          start = prev
+        }
+        points     := []Vect{tiles.point(start.x, start.y)}
+        last, next := start, start
+        for ok := true; ok && inChunk(next) && !visited[next]; {
+          last          = next
+          visited[last] = true
+          points        = append(points, tiles.point(last.end()))
+          next, ok      = tiles.nextEdge(last)
+        }
+        shapes = append(shapes, tiles.addChain(points, start, last)...)
+      }
+    }
+  }
+  return shapes
+}
+
+// Adds a chain along the points, from the start edge to the last edge. An
+// open chain gets the vertexes of the edges before and after it as
+// neighbours.
+func (tiles *Tilemap) addChain(points []Vect, start, last tileEdge) (
+  []*Shape) {
+  points    = PolylineRemoveCollinear(points, 0.0)
+  segments := SegmentShapesNew(tiles.body, points, tiles.options.Radius)
+  if len(segments) == 0 { return nil }
+  if !PolylineIsClosed(points) {
+    first, final := segments[0], segments[len(segments) - 1]
+    if prev, ok := tiles.prevEdge(start); ok {
+      _, next := first.Neighbors()
+      first.SetNeighbors(tiles.point(prev.x, prev.y), next)
+    }
+    if next, ok := tiles.nextEdge(last); ok {
+      prev, _ := final.Neighbors()
+      final.SetNeighbors(prev, tiles.point(next.end()))
+    }
+  }
+  shapes := make([]*Shape, len(segments))
+  for i, segment := range segments {
+    shapes[i] = segment.Shape
+    tiles.add(shapes[i])
+  }
+  return shapes
+}
+
+// Shapes returns all shapes of the tilemap.
+func (tiles *Tilemap) Shapes() ([]*Shape) {
+  shapes := make([]*Shape, 0)
+  for _, chunk := range tiles.chunks {
+    shapes = append(shapes, chunk...)
+  }
+  return shapes
+}
+
+// Remove removes all shapes of the tilemap from the space.
+func (tiles *Tilemap) Remove() {
+  for i, chunk := range tiles.chunks {
+    for _, shape := range chunk {
+      tiles.space.RemoveStaticShape(shape)
+    }
+    tiles.chunks[i] = nil
+  }
+}
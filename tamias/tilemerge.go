@@ -0,0 +1,49 @@
+package tamias
+
+// Merging of solid tiles into larger rectangles, so a tile map adds a few
+// big boxes to the space instead of one box per tile.
+
+// A rectangle of tiles, in tile coordinates.
+type TileRect struct {
+  X, Y, W, H int
+}
+
+// MergeTiles covers the solid tiles of a grid with rectangles, greedily.
+// solid holds width * height cells, row by row. Each rectangle starts at
+// the first solid tile that is not covered yet, grows as wide as it can
+// and then as high as it can. The result is not always the smallest number
+// of rectangles, but it is close, and no rectangles overlap.
+func MergeTiles(solid []bool, width, height int) ([]TileRect) {
+  Assert(len(solid) >= width * height, "Grid is smaller than its size.")
+  covered := make([]bool, width * height)
+  free    := func(x, y int) (bool) {
+    i := y * width + x
+    return solid[i] && !covered[i]
+  }
+  rects := make([]TileRect, 0)
+  for y:=0; y < height; y++ {
+    for x:=0; x < width; x++ {
+      if !free(x, y) { continue }
+      w := 1
+      for x + w < width && free(x + w, y) {
+        w++
+      }
+      h := 1
+      for y + h < height {
+        full := true
+        for i:=x; i < x + w && full; i++ {
+          full = free(i, y + h)
+        }
+        if !full { break }
+        h++
+      }
+      for j:=y; j < y + h; j++ {
+        for i:=x; i < x + w; i++ {
+          covered[j * width + i] = true
+        }
+      }
+      rects = append(rects, TileRect{x, y, w, h})
+    }
+  }
+  return rects
+}
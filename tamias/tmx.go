@@ -0,0 +1,480 @@
+package tamias
+
+// Loading of static level geometry from Tiled TMX maps.
+//
+// Objects in object layers become static shapes: rectangles and polygons
+// become polygons, split into convex pieces if they are concave, polylines
+// become chains of segments, and ellipses become circles. Tiles of tile
+// layers are solid when their tile has the property "solid" or "collides"
+// set to true, or when its collision shapes (made in the tile collision
+// editor) are a single rectangle that covers the whole tile. Solid tiles
+// are merged into larger boxes with MergeTiles.
+// Other collision shapes of tiles are added as they are, for each tile.
+//
+// These properties of objects, of their object layers and of tiles set
+// the fields of the shapes: "friction", "elasticity", "collision_type",
+// "group", "layers", "sensor", and "radius" for the segments of polylines.
+//
+// Positions stay in pixels, but the Y axis is flipped so that it points
+// up, with the bottom of the map at 0. Tilesets in external files, tile
+// objects and points are not supported and are ignored. Tile data must be
+// in the XML, CSV or uncompressed base64 encoding.
+
+import "encoding/base64"
+import "encoding/binary"
+import "io"
+import "os"
+import "strconv"
+import "strings"
+import "xml"
+
+type TMXProperties map[string]string
+
+type TMXObject struct {
+  Name, Type string
+  X, Y, Width, Height, Rotation Float
+  // One of "rect", "ellipse", "polygon", "polyline", "point" or "tile".
+  Kind string
+  // Points of polygons and polylines, relative to X and Y.
+  Points []Vect
+  Properties TMXProperties
+}
+
+type TMXObjectGroup struct {
+  Name string
+  Objects []*TMXObject
+  Properties TMXProperties
+}
+
+type TMXTileLayer struct {
+  Name string
+  Width, Height int
+  // Global tile ids, row by row. 0 means no tile.
+  Gids []uint32
+  Properties TMXProperties
+}
+
+type TMXTile struct {
+  Id int
+  Properties TMXProperties
+  // Collision shapes, relative to the top left of the tile.
+  Objects []*TMXObject
+}
+
+type TMXTileset struct {
+  Name string
+  FirstGid uint32
+  Tiles map[int] *TMXTile
+}
+
+type TMXMap struct {
+  Width, Height, TileWidth, TileHeight int
+  Properties TMXProperties
+  Tilesets []*TMXTileset
+  Layers []*TMXTileLayer
+  ObjectGroups []*TMXObjectGroup
+}
+
+// The top bits of global tile ids are flags for flipped tiles.
+const tmxGidMask = 0x1fffffff
+
+func tmxError(message string) (os.Error) {
+  return os.NewError("tmx: " + message)
+}
+
+// Reads a TMX document element by element.
+type tmxParser struct {
+  xmlReader
+}
+
+func (p *tmxParser) properties(props TMXProperties) {
+  for start := p.child(); start != nil; start = p.child() {
+    if start.Name.Local == "property" {
+      props[xmlAttr(start, "name")] = xmlAttr(start, "value")
+    }
+    p.skip()
+  }
+}
+
+// Parses points like "0,0 10,5 3,-2".
+func (p *tmxParser) points(value string) ([]Vect) {
+  fields := strings.Fields(value)
+  points := make([]Vect, len(fields))
+  for i, field := range fields {
+    comma := strings.Index(field, ",")
+    if comma < 0 {
+      p.fail("bad point " + field)
+      return nil
+    }
+    points[i] = V(p.float(field[0:comma]), p.float(field[comma + 1:]))
+  }
+  return points
+}
+
+func (p *tmxParser) object(start *xml.StartElement) (*TMXObject) {
+  obj           := &TMXObject{Kind: "rect", Properties: make(TMXProperties)}
+  obj.Name       = xmlAttr(start, "name")
+  obj.Type       = xmlAttr(start, "type")
+  obj.X          = p.floatAttr(start, "x")
+  obj.Y          = p.floatAttr(start, "y")
+  obj.Width      = p.floatAttr(start, "width")
+  obj.Height     = p.floatAttr(start, "height")
+  obj.Rotation   = p.floatAttr(start, "rotation")
+  if xmlAttr(start, "gid") != "" {
+    obj.Kind = "tile"
+  }
+  for child := p.child(); child != nil; child = p.child() {
+    switch child.Name.Local {
+      case "properties":
+        p.properties(obj.Properties)
+        continue
+      case "ellipse", "point":
+        obj.Kind   = child.Name.Local
+      case "polygon", "polyline":
+        obj.Kind   = child.Name.Local
+        obj.Points = p.points(xmlAttr(child, "points"))
+    }
+    p.skip()
+  }
+  return obj
+}
+
+func (p *tmxParser) objectGroup(start *xml.StartElement) (*TMXObjectGroup) {
+  group := &TMXObjectGroup{Name: xmlAttr(start, "name"),
+    Objects: make([]*TMXObject, 0), Properties: make(TMXProperties)}
+  for child := p.child(); child != nil; child = p.child() {
+    switch child.Name.Local {
+      case "object":
+        group.Objects = append(group.Objects, p.object(child))
+      case "properties":
+        p.properties(group.Properties)
+      default:
+        p.skip()
+    }
+  }
+  return group
+}
+
+func (p *tmxParser) tileset(start *xml.StartElement) (*TMXTileset) {
+  tileset := &TMXTileset{Name: xmlAttr(start, "name"),
+    FirstGid: uint32(p.intAttr(start, "firstgid")),
+    Tiles: make(map[int] *TMXTile)}
+  for child := p.child(); child != nil; child = p.child() {
+    if child.Name.Local != "tile" {
+      p.skip()
+      continue
+    }
+    tile := &TMXTile{Id: p.intAttr(child, "id"),
+      Properties: make(TMXProperties), Objects: make([]*TMXObject, 0)}
+    for sub := p.child(); sub != nil; sub = p.child() {
+      switch sub.Name.Local {
+        case "properties":
+          p.properties(tile.Properties)
+        case "objectgroup":
+          tile.Objects = p.objectGroup(sub).Objects
+        default:
+          p.skip()
+      }
+    }
+    tileset.Tiles[tile.Id] = tile
+  }
+  return tileset
+}
+
+// Decodes the tile ids in a data element.
+func (p *tmxParser) data(start *xml.StartElement, size int) ([]uint32) {
+  gids := make([]uint32, 0, size)
+  switch xmlAttr(start, "encoding") {
+    case "":
+      for child := p.child(); child != nil; child = p.child() {
+        if child.Name.Local == "tile" {
+          gids = append(gids, uint32(p.intAttr(child, "gid")))
+        }
+        p.skip()
+      }
+    case "csv":
+      text := p.text()
+      for len(text) > 0 {
+        comma := strings.Index(text, ",")
+        if comma < 0 { comma = len(text) }
+        field := strings.TrimSpace(text[0:comma])
+        if field != "" {
+          gid, err := strconv.Atoui64(field)
+          if err != nil { p.fail("bad tile id " + field) }
+          gids = append(gids, uint32(gid))
+        }
+        if comma == len(text) { break }
+        text = text[comma + 1:]
+      }
+    case "base64":
+      if xmlAttr(start, "compression") != "" {
+        p.fail("compressed tile data is not supported")
+        p.skip()
+        return nil
+      }
+      src := []byte(strings.TrimSpace(p.text()))
+      raw := make([]byte, base64.StdEncoding.DecodedLen(len(src)))
+      n, err := base64.StdEncoding.Decode(raw, src)
+      if err != nil {
+        p.fail("bad base64 tile data")
+        return nil
+      }
+      for i:=0; i + 4 <= n; i += 4 {
+        gids = append(gids, binary.LittleEndian.Uint32(raw[i:i + 4]))
+      }
+    default:
+      p.fail("unknown encoding " + xmlAttr(start, "encoding"))
+      p.skip()
+      return nil
+  }
+  if len(gids) != size { p.fail("wrong number of tiles in layer") }
+  return gids
+}
+
+func (p *tmxParser) layer(start *xml.StartElement) (*TMXTileLayer) {
+  layer := &TMXTileLayer{Name: xmlAttr(start, "name"),
+    Width: p.intAttr(start, "width"), Height: p.intAttr(start, "height"),
+    Properties: make(TMXProperties)}
+  for child := p.child(); child != nil; child = p.child() {
+    switch child.Name.Local {
+      case "data":
+        layer.Gids = p.data(child, layer.Width * layer.Height)
+      case "properties":
+        p.properties(layer.Properties)
+      default:
+        p.skip()
+    }
+  }
+  return layer
+}
+
+// ReadTMX parses a TMX map from r.
+func ReadTMX(r io.Reader) (*TMXMap, os.Error) {
+  p     := &tmxParser{xmlReader{parser: xml.NewParser(r), prefix: "tmx"}}
+  start := p.child()
+  if start == nil || start.Name.Local != "map" {
+    if p.err != nil { return nil, p.err }
+    return nil, tmxError("not a TMX map")
+  }
+  tmx := &TMXMap{Properties: make(TMXProperties),
+    Tilesets: make([]*TMXTileset, 0), Layers: make([]*TMXTileLayer, 0),
+    ObjectGroups: make([]*TMXObjectGroup, 0)}
+  tmx.Width      = p.intAttr(start, "width")
+  tmx.Height     = p.intAttr(start, "height")
+  tmx.TileWidth  = p.intAttr(start, "tilewidth")
+  tmx.TileHeight = p.intAttr(start, "tileheight")
+  for child := p.child(); child != nil; child = p.child() {
+    switch child.Name.Local {
+      case "properties":
+        p.properties(tmx.Properties)
+      case "tileset":
+        tmx.Tilesets = append(tmx.Tilesets, p.tileset(child))
+      case "layer":
+        tmx.Layers = append(tmx.Layers, p.layer(child))
+      case "objectgroup":
+        tmx.ObjectGroups = append(tmx.ObjectGroups, p.objectGroup(child))
+      default:
+        p.skip()
+    }
+  }
+  if p.err != nil { return nil, p.err }
+  return tmx, nil
+}
+
+// Returns the tile with the given global id, or nil if it has no
+// properties or collision shapes.
+func (tmx *TMXMap) Tile(gid uint32) (*TMXTile) {
+  gid &= tmxGidMask
+  var found *TMXTileset
+  for _, tileset := range tmx.Tilesets {
+    if tileset.FirstGid <= gid &&
+       (found == nil || tileset.FirstGid > found.FirstGid) {
+      found = tileset
+    }
+  }
+  if found == nil { return nil }
+  return found.Tiles[int(gid - found.FirstGid)]
+}
+
+// Returns the value of a property in props, or else in defaults.
+func tmxProperty(name string, props, defaults TMXProperties) (string) {
+  value, ok := props[name]
+  if !ok { value = defaults[name] }
+  return value
+}
+
+// Adds the shapes of a map to a space.
+type tmxBuilder struct {
+  tmx *TMXMap
+  space *Space
+  body *Body
+  shapes []*Shape
+}
+
+// Converts a point in pixels with the Y axis down to a point in the space.
+func (builder *tmxBuilder) point(x, y Float) (Vect) {
+  return V(x, Float(builder.tmx.Height * builder.tmx.TileHeight) - y)
+}
+
+// Returns the points of the object in the space.
+func (builder *tmxBuilder) objectPoints(obj *TMXObject, local []Vect,
+  x, y Float) ([]Vect) {
+  rot    := (obj.Rotation * PI / 180.0).VectForAngle()
+  points := make([]Vect, len(local))
+  for i, v := range local {
+    v         = v.Rotate(rot)
+    points[i] = builder.point(x + obj.X + v.X, y + obj.Y + v.Y)
+  }
+  return points
+}
+
+func (builder *tmxBuilder) add(shape *Shape, props, defaults TMXProperties) {
+  if value := tmxProperty("friction", props, defaults); value != "" {
+    f, err := strconv.Atof64(value)
+    if err == nil { shape.u = F64Float(f) }
+  }
+  if value := tmxProperty("elasticity", props, defaults); value != "" {
+    f, err := strconv.Atof64(value)
+    if err == nil { shape.e = F64Float(f) }
+  }
+  if value := tmxProperty("collision_type", props, defaults); value != "" {
+    i, err := strconv.Btoi64(value, 0)
+    if err == nil { shape.collision_type = CollisionType(i) }
+  }
+  if value := tmxProperty("group", props, defaults); value != "" {
+    i, err := strconv.Btoi64(value, 0)
+    if err == nil { shape.group = GroupType(i) }
+  }
+  if value := tmxProperty("layers", props, defaults); value != "" {
+    i, err := strconv.Btoui64(value, 0)
+    if err == nil { shape.layers = LayerType(i) }
+  }
+  if value := tmxProperty("sensor", props, defaults); value != "" {
+    b, err := strconv.Atob(value)
+    if err == nil { shape.sensor = b }
+  }
+  builder.space.AddStaticShape(shape)
+  builder.shapes = append(builder.shapes, shape)
+}
+
+// Adds a polygon, split into convex pieces if needed. Polygons that can't
+// be split become a loop of segments.
+func (builder *tmxBuilder) addPolygon(verts []Vect,
+  props, defaults TMXProperties) {
+  if len(verts) < 3 { return }
+  pieces, err := ConvexDecompose(verts, 0)
+  if err != nil {
+    closed := append(verts, verts[0])
+    builder.addChain(closed, props, defaults)
+    return
+  }
+  for _, piece := range pieces {
+    builder.add(PolyShapeNew(builder.body, piece, VZERO).Shape, props,
+      defaults)
+  }
+}
+
+func (builder *tmxBuilder) addChain(points []Vect,
+  props, defaults TMXProperties) {
+  radius := Float(0.0)
+  if value := tmxProperty("radius", props, defaults); value != "" {
+    f, err := strconv.Atof64(value)
+    if err == nil { radius = F64Float(f) }
+  }
+  for _, segment := range SegmentShapesNew(builder.body, points, radius) {
+    builder.add(segment.Shape, props, defaults)
+  }
+}
+
+// Adds an object, offset by x and y pixels.
+func (builder *tmxBuilder) addObject(obj *TMXObject, x, y Float,
+  defaults TMXProperties) {
+  switch obj.Kind {
+    case "rect":
+      if obj.Width <= 0 || obj.Height <= 0 { return }
+      corners := []Vect{V(0, 0), V(obj.Width, 0), V(obj.Width, obj.Height),
+        V(0, obj.Height)}
+      builder.addPolygon(builder.objectPoints(obj, corners, x, y),
+        obj.Properties, defaults)
+    case "ellipse":
+      // Circles can't be stretched, so use the average radius.
+      radius := (obj.Width + obj.Height) / 4.0
+      center := builder.objectPoints(obj,
+        []Vect{V(obj.Width / 2.0, obj.Height / 2.0)}, x, y)[0]
+      builder.add(CircleShapeNew(builder.body, radius, center).Shape,
+        obj.Properties, defaults)
+    case "polygon":
+      builder.addPolygon(builder.objectPoints(obj, obj.Points, x, y),
+        obj.Properties, defaults)
+    case "polyline":
+      builder.addChain(builder.objectPoints(obj, obj.Points, x, y),
+        obj.Properties, defaults)
+  }
+}
+
+// Returns true if the tile is solid as a whole.
+func (builder *tmxBuilder) solid(tile *TMXTile) (bool) {
+  for _, name := range []string{"solid", "collides"} {
+    b, err := strconv.Atob(tile.Properties[name])
+    if err == nil && b { return true }
+  }
+  if len(tile.Objects) != 1 { return false }
+  obj := tile.Objects[0]
+  return obj.Kind == "rect" && obj.Rotation == 0 && obj.X <= 0 &&
+    obj.Y <= 0 && obj.X + obj.Width >= Float(builder.tmx.TileWidth) &&
+    obj.Y + obj.Height >= Float(builder.tmx.TileHeight)
+}
+
+func (builder *tmxBuilder) addLayer(layer *TMXTileLayer) {
+  tw    := Float(builder.tmx.TileWidth)
+  th    := Float(builder.tmx.TileHeight)
+  solid := make([]bool, layer.Width * layer.Height)
+  for i, gid := range layer.Gids {
+    if gid == 0 || i >= len(solid) { continue }
+    tile := builder.tmx.Tile(gid)
+    if tile == nil { continue }
+    if builder.solid(tile) {
+      solid[i] = true
+      continue
+    }
+    x := Float(i % layer.Width) * tw
+    y := Float(i / layer.Width) * th
+    for _, obj := range tile.Objects {
+      builder.addObject(obj, x, y, tile.Properties)
+    }
+  }
+  for _, rect := range MergeTiles(solid, layer.Width, layer.Height) {
+    x0 := Float(rect.X) * tw
+    y0 := Float(rect.Y) * th
+    x1 := Float(rect.X + rect.W) * tw
+    y1 := Float(rect.Y + rect.H) * th
+    verts := []Vect{builder.point(x0, y1), builder.point(x0, y0),
+      builder.point(x1, y0), builder.point(x1, y1)}
+    builder.add(PolyShapeNew(builder.body, verts, VZERO).Shape,
+      layer.Properties, builder.tmx.Properties)
+  }
+}
+
+// AddStaticShapes adds the shapes of the object layers and the solid tiles
+// of the tile layers to the space as static shapes of body, and returns
+// them.
+func (tmx *TMXMap) AddStaticShapes(space *Space, body *Body) ([]*Shape) {
+  builder := &tmxBuilder{tmx, space, body, make([]*Shape, 0)}
+  for _, layer := range tmx.Layers {
+    builder.addLayer(layer)
+  }
+  for _, group := range tmx.ObjectGroups {
+    for _, obj := range group.Objects {
+      builder.addObject(obj, 0, 0, group.Properties)
+    }
+  }
+  return builder.shapes
+}
+
+// LoadTMX reads a TMX map from r and adds its shapes to the space as
+// static shapes of body.
+func LoadTMX(space *Space, body *Body, r io.Reader) ([]*Shape, os.Error) {
+  tmx, err := ReadTMX(r)
+  if err != nil { return nil, err }
+  return tmx.AddStaticShapes(space, body), nil
+}
@@ -0,0 +1,97 @@
+package tamias
+
+// Reading of XML documents element by element, for the importers.
+
+import "os"
+import "strconv"
+import "xml"
+
+// Reads elements from an XML parser. The first error is remembered, and
+// stops the reading. Its message starts with prefix.
+type xmlReader struct {
+  parser *xml.Parser
+  err os.Error
+  prefix string
+}
+
+// Returns the next start element inside the current element, or nil at
+// its end. Text is skipped.
+func (p *xmlReader) child() (*xml.StartElement) {
+  for p.err == nil {
+    token, err := p.parser.Token()
+    if err != nil {
+      p.err = err
+      return nil
+    }
+    switch t := token.(type) {
+      case xml.StartElement:
+        return &t
+      case xml.EndElement:
+        return nil
+    }
+  }
+  return nil
+}
+
+// Skips the rest of the current element.
+func (p *xmlReader) skip() {
+  for p.child() != nil {
+    p.skip()
+  }
+}
+
+// Returns the text in the current element, up to its end.
+func (p *xmlReader) text() (string) {
+  data  := make([]byte, 0)
+  depth := 0
+  for p.err == nil {
+    token, err := p.parser.Token()
+    if err != nil {
+      p.err = err
+      break
+    }
+    switch t := token.(type) {
+      case xml.CharData:
+        data = append(data, []byte(t)...)
+      case xml.StartElement:
+        depth++
+      case xml.EndElement:
+        if depth == 0 { return string(data) }
+        depth--
+    }
+  }
+  return string(data)
+}
+
+func (p *xmlReader) fail(message string) {
+  if p.err == nil {
+    p.err = os.NewError(p.prefix + ": " + message)
+  }
+}
+
+func xmlAttr(start *xml.StartElement, name string) (string) {
+  for _, attr := range start.Attr {
+    if attr.Name.Local == name { return attr.Value }
+  }
+  return ""
+}
+
+func (p *xmlReader) intAttr(start *xml.StartElement, name string) (int) {
+  value := xmlAttr(start, name)
+  if value == "" { return 0 }
+  i, err := strconv.Atoi(value)
+  if err != nil { p.fail("bad number " + value + " in " + name) }
+  return i
+}
+
+func (p *xmlReader) floatAttr(start *xml.StartElement, name string) (Float) {
+  value := xmlAttr(start, name)
+  if value == "" { return 0 }
+  return p.float(value)
+}
+
+func (p *xmlReader) float(value string) (Float) {
+  f, err := strconv.Atof64(value)
+  if err != nil { p.fail("bad number " + value) }
+  return F64Float(f)
+}
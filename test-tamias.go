@@ -1,8 +1,11 @@
 package main
 
+import "bytes"
 import "fmt"
 import "os"
+import "strings"
 import "tamias"
+import "tamias/debugdraw"
 import "exp/iterable"
 /*
 import "exp/draw"
@@ -56,6 +59,458 @@ func TestFloat() {
   assert(f2.Max(f1) == f2, "Max must work properly in reverse.", f1)  
 }
 
+// With 64 bits floats, small steps far from the origin must not get lost.
+func TestPrecision() {
+  far  := tamias.V(10000000.0, -10000000.0)
+  step := tamias.V(0.25, 0.25)
+  moved := far.Add(step).Sub(far)
+  if tamias.FLOAT_BITS == 64 {
+    assert(moved.Equals(step), "64 bits Vect should keep small steps", moved)
+  } else {
+    assert(!moved.Equals(step), "32 bits Vect loses small steps", moved)
+  }
+}
+
+// Returns a small deterministic space with a pile of shapes on the ground,
+// and the top body of the pile.
+func deterministicSpace() (*tamias.Space, *tamias.Body) {
+  // Shape ids are part of the state, so start both spaces from the same
+  // counter like two separate processes would.
+  tamias.ResetShapeIdCounter()
+  space              := tamias.SpaceNew()
+  space.Gravity       = tamias.V(0.0, -100.0)
+  space.Deterministic = true
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  space.AddStaticShape(tamias.SegmentShapeNew(ground,
+    tamias.V(-100.0, 0.0), tamias.V(100.0, 0.0), 1.0).Shape)
+  var last *tamias.Body
+  for i:=0; i < 6; i++ {
+    body   := space.AddBody(tamias.BodyNew(1.0, 10.0))
+    offset := tamias.V(tamias.Float(i % 3) * 7.0, 6.0 + tamias.Float(i) * 9.0)
+    space.AddShape(tamias.CircleShapeNew(body, 5.0, offset).Shape)
+    if last != nil {
+      space.AddConstraint(tamias.DampedRotarySpringNew(last, body, 0.0,
+        10.0, 1.0))
+    }
+    last = body
+  }
+  return space, last
+}
+
+// Two identical deterministic spaces must stay in sync, and StateHash must
+// notice when they don't.
+func TestDeterministic() {
+  a, _   := deterministicSpace()
+  b, top := deterministicSpace()
+  start  := a.StateHash()
+  same   := true
+  for i:=0; i < 60; i++ {
+    a.Step(1.0 / 60.0)
+    b.Step(1.0 / 60.0)
+    same = same && a.StateHash() == b.StateHash()
+  }
+  assert(same, "Identical spaces should have the same StateHash")
+  assert(a.StateHash() != start, "StateHash should change with the state")
+  top.ApplyImpulse(tamias.V(0.0, 1.0), tamias.VZERO)
+  a.Step(1.0 / 60.0)
+  b.Step(1.0 / 60.0)
+  assert(a.StateHash() != b.StateHash(), "StateHash should detect a desync")
+}
+
+// Restoring a snapshot must roll the space back, so it steps the same way
+// again.
+func TestSnapshot() {
+  space, _ := deterministicSpace()
+  for i:=0; i < 30; i++ { space.Step(1.0 / 60.0) }
+  snap := space.Snapshot()
+  hash := space.StateHash()
+  for i:=0; i < 30; i++ { space.Step(1.0 / 60.0) }
+  later := space.StateHash()
+  space.Restore(snap)
+  assert(space.StateHash() == hash, "Restore should roll back the state")
+  assert(space.Snapshot().Equals(snap), "Restore should restore the snapshot")
+  for i:=0; i < 30; i++ { space.Step(1.0 / 60.0) }
+  assert(space.StateHash() == later,
+    "A restored space should step like the first time")
+}
+
+// Records what Space.DebugDraw draws.
+type recordingDrawer struct {
+  calls map[string] int
+  circles []tamias.Vect
+  colors []tamias.DebugColor
+}
+
+func (drawer *recordingDrawer) DrawCircle(center tamias.Vect,
+  angle, radius tamias.Float, outline, fill tamias.DebugColor) {
+  drawer.calls["circle"]++
+  drawer.circles = append(drawer.circles, center)
+  drawer.colors  = append(drawer.colors, outline)
+}
+
+func (drawer *recordingDrawer) DrawSegment(a, b tamias.Vect,
+  color tamias.DebugColor) {
+  drawer.calls["segment"]++
+}
+
+func (drawer *recordingDrawer) DrawFatSegment(a, b tamias.Vect,
+  radius tamias.Float, outline, fill tamias.DebugColor) {
+  drawer.calls["fat segment"]++
+  drawer.colors = append(drawer.colors, outline)
+}
+
+func (drawer *recordingDrawer) DrawPolygon(verts []tamias.Vect,
+  outline, fill tamias.DebugColor) {
+  drawer.calls["polygon"]++
+}
+
+func (drawer *recordingDrawer) DrawDot(size tamias.Float, pos tamias.Vect,
+  color tamias.DebugColor) {
+  drawer.calls["dot"]++
+}
+
+// Space.DebugDraw must draw every shape, constraint and contact with the
+// drawer, and only what the options ask for.
+func TestDebugDrawer() {
+  space  := tamias.SpaceNew()
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  space.AddStaticShape(tamias.SegmentShapeNew(ground, tamias.V(-10.0, 0.0),
+    tamias.V(10.0, 0.0), 0.5).Shape)
+  ball := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  space.AddShape(tamias.CircleShapeNew(ball, 1.0, tamias.V(0.0, 1.2)).Shape)
+  box  := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  space.AddShape(tamias.PolyShapeNewFromPoints(box, []tamias.Vect{
+    tamias.V(4.0, 4.0), tamias.V(6.0, 4.0), tamias.V(6.0, 6.0),
+    tamias.V(4.0, 6.0)}, tamias.VZERO).Shape)
+  space.AddConstraint(tamias.PivotJointNew(ball, box, tamias.V(2.0, 2.0)))
+  space.Step(1.0 / 60.0)
+  contacts := 0
+  space.EachArbiter(func(arb *tamias.Arbiter) {
+    contacts += arb.NumContacts()
+  })
+  assert(contacts > 0, "The ball should touch the ground")
+
+  drawer := &recordingDrawer{calls: make(map[string] int)}
+  space.DebugDraw(drawer, nil)
+  calls  := drawer.calls
+  assert(calls["circle"] == 1 && calls["fat segment"] == 1 &&
+    calls["polygon"] == 1, "DebugDraw should draw the shapes", calls)
+  assert(calls["segment"] == 1 + contacts && calls["dot"] == 2 + contacts,
+    "DebugDraw should draw the constraint and the contacts", calls)
+  center := ball.Local2World(tamias.V(0.0, 1.2))
+  assert(len(drawer.circles) == 1 && drawer.circles[0] == center,
+    "DebugDraw should draw in world coordinates", drawer.circles)
+  // The ground is drawn first.
+  options := tamias.DefaultDebugDrawOptions
+  assert(len(drawer.colors) == 2 &&
+    drawer.colors[0] == options.StaticColor &&
+    drawer.colors[1] == options.ShapeColor,
+    "DebugDraw should color static shapes apart", drawer.colors)
+
+  options.Shapes      = false
+  options.Constraints = false
+  options.Contacts    = false
+  options.BBs         = true
+  drawer = &recordingDrawer{calls: make(map[string] int)}
+  space.DebugDraw(drawer, &options)
+  assert(len(drawer.calls) == 1 && drawer.calls["polygon"] == 3,
+    "DebugDraw should only draw the bounding boxes", drawer.calls)
+}
+
+// Returns a space with shapes and constraints of several kinds, to save
+// and load.
+func sceneSpace() (*tamias.Space) {
+  space        := tamias.SpaceNew()
+  space.Gravity = tamias.V(0.0, -100.0)
+  ground       := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  space.AddStaticShape(tamias.SegmentShapeNew(ground,
+    tamias.V(-100.0, -50.0), tamias.V(100.0, -50.0), 1.0).Shape)
+  rubber := tamias.MaterialNew(0.9, 0.8, 1.0)
+  ball   := space.AddBody(tamias.BodyNew(1.0, 10.0))
+  circle := tamias.CircleShapeNew(ball, 5.0, tamias.V(0.0, 20.0))
+  circle.SetMaterial(rubber)
+  space.AddShape(circle.Shape)
+  box := space.AddBody(tamias.BodyNew(2.0, 20.0))
+  top := tamias.BoxShapeNew(box, 10.0, 10.0)
+  top.SetOneWay(tamias.V(0.0, 1.0))
+  top.SetMaterial(rubber)
+  space.AddShape(top.Shape)
+  space.SetPairMaterial(1, 2, tamias.MaterialNew(0.1, 0.0, 0.0))
+  space.AddConstraint(tamias.PivotJointNew(ball, box, tamias.V(0.0, 10.0)))
+  space.AddConstraint(tamias.DampedSpringNew(ball, box, tamias.VZERO,
+    tamias.VZERO, 20.0, 5.0, 0.5))
+  space.AddStaticChain(ground, []tamias.Vect{tamias.V(-100.0, 50.0),
+    tamias.V(-80.0, 40.0), tamias.V(-60.0, 50.0), tamias.V(-40.0, 40.0)},
+    0.5, true)
+  return space
+}
+
+// Returns true if both spaces are described by the same scene.
+func sameScene(a, b *tamias.Space) (bool) {
+  return fmt.Sprint(*tamias.SceneFromSpace(a)) ==
+    fmt.Sprint(*tamias.SceneFromSpace(b))
+}
+
+// A space saved as JSON must load back the same, and step like the
+// original.
+func TestJSONScene() {
+  space := sceneSpace()
+  buf   := &bytes.Buffer{}
+  err   := space.WriteJSON(buf)
+  assert(err == nil, "Space should save as JSON", err)
+  loaded, err := tamias.ReadJSON(buf)
+  assert(err == nil, "Space should load from JSON", err)
+  if loaded == nil { return }
+  assert(sameScene(space, loaded), "A loaded JSON scene should be the same")
+  for i:=0; i < 60; i++ {
+    space.Step(1.0 / 60.0)
+    loaded.Step(1.0 / 60.0)
+  }
+  assert(sameScene(space, loaded),
+    "A loaded JSON scene should step identically")
+}
+
+// A space loaded from the binary format must step like the original.
+func TestBinaryScene() {
+  space := sceneSpace()
+  buf   := &bytes.Buffer{}
+  err   := space.WriteBinary(buf)
+  assert(err == nil, "Space should save in the binary format", err)
+  data  := buf.Bytes()
+  scene := &bytes.Buffer{}
+  tamias.SceneFromSpace(space).WriteBinary(scene)
+  assert(bytes.Equal(data, scene.Bytes()),
+    "Spaces and their scenes should save the same")
+  // A circle takes its length, kind, flags, body, collision type, group,
+  // layers, material, hash id and 9 floats. With every field in every record
+  // and 64 bit floats, it took 118 bytes.
+  bigger := tamias.SceneFromSpace(space)
+  for _, s := range bigger.Shapes {
+    if s.Kind == "circle" { bigger.Shapes = append(bigger.Shapes, s); break }
+  }
+  scene.Reset()
+  bigger.WriteBinary(scene)
+  grown := scene.Len() - len(data)
+  assert(grown == 34 + 9 * tamias.FLOAT_BITS / 8,
+    "Binary scenes should only write the fields of a kind", grown)
+  loaded, err := tamias.ReadBinary(bytes.NewBuffer(data))
+  assert(err == nil, "Space should load from the binary format", err)
+  if loaded == nil { return }
+  assert(sameScene(space, loaded), "A loaded binary scene should be the same")
+  for i:=0; i < 60; i++ {
+    space.Step(1.0 / 60.0)
+    loaded.Step(1.0 / 60.0)
+  }
+  assert(sameScene(space, loaded),
+    "A loaded binary scene should step identically")
+
+  data[len(data) / 2] ^= 0xff
+  _, err = tamias.ReadBinary(bytes.NewBuffer(data))
+  assert(err != nil, "Corrupt binary scenes should not load")
+}
+
+// A space saved in the middle of a simulation must step on exactly like
+// the original once it is loaded.
+func TestSceneResume() {
+  space, _ := deterministicSpace()
+  // Save while the pile is colliding, so it has contacts to warm start.
+  for i:=0; i < 40; i++ { space.Step(1.0 / 60.0) }
+  buf := &bytes.Buffer{}
+  err := space.WriteJSON(buf)
+  assert(err == nil, "A stepped space should save as JSON", err)
+  loaded, err := tamias.ReadJSON(buf)
+  assert(err == nil, "A stepped space should load from JSON", err)
+  if loaded == nil { return }
+  bin := &bytes.Buffer{}
+  err  = space.WriteBinary(bin)
+  assert(err == nil, "A stepped space should save in the binary format", err)
+  loadedBin, err := tamias.ReadBinary(bin)
+  assert(err == nil, "A stepped space should load from the binary format",
+    err)
+  if loadedBin == nil { return }
+  assert(loaded.StateHash() == space.StateHash(),
+    "A loaded space should have the state of the saved one")
+  assert(loadedBin.StateHash() == space.StateHash(),
+    "A loaded binary space should have the state of the saved one")
+  for i:=0; i < 30; i++ {
+    space.Step(1.0 / 60.0)
+    loaded.Step(1.0 / 60.0)
+    loadedBin.Step(1.0 / 60.0)
+  }
+  assert(loaded.StateHash() == space.StateHash(),
+    "A loaded space should step on like the saved one")
+  assert(loadedBin.StateHash() == space.StateHash(),
+    "A loaded binary space should step on like the saved one")
+}
+
+const testTMX = `<?xml version="1.0" encoding="UTF-8"?>
+<map width="4" height="3" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="walls">
+  <tile id="0"><properties><property name="solid" value="true"/></properties></tile>
+ </tileset>
+ <layer name="ground" width="4" height="3">
+  <data encoding="csv">0,0,0,0,
+1,1,0,0,
+1,1,1,1</data>
+ </layer>
+ <objectgroup name="static">
+  <properties><property name="friction" value="0.5"/></properties>
+  <object x="8" y="8"><ellipse/></object>
+  <object x="0" y="0" width="8" height="8">
+   <properties><property name="collision_type" value="3"/></properties>
+  </object>
+  <object x="0" y="0"><polyline points="0,0 10,0 10,10"/></object>
+ </objectgroup>
+</map>`
+
+// Solid tiles of a TMX map must be merged, and objects must become shapes.
+func TestTMX() {
+  rects := tamias.MergeTiles([]bool{true, true, false, true, true, true}, 3, 2)
+  assert(len(rects) == 2, "MergeTiles should merge tiles into rectangles", rects)
+
+  space := tamias.SpaceNew()
+  body  := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  shapes, err := tamias.LoadTMX(space, body, strings.NewReader(testTMX))
+  assert(err == nil, "TMX map should load", err)
+  // Two boxes for the tiles, a circle, a box and two segments.
+  assert(len(shapes) == 6, "TMX map should have 6 shapes", len(shapes))
+}
+
+const testSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+ <g id="ground" data-friction="0.8">
+  <path d="M 0,90 L 100,90 L 100,100 L 0,100 Z"/>
+  <polygon points="0,0 30,0 30,10 10,10 10,30 0,30"/>
+  <line x1="0" y1="50" x2="20" y2="50"/>
+ </g>
+ <circle cx="50" cy="50" r="5" transform="scale(2)"/>
+ <path d="M 60,10 C 70,0 80,0 90,10"/>
+ <g data-body="wheel"><circle cx="80" cy="60" r="4"/></g>
+</svg>`
+
+// SVG outlines must become shapes, with concave polygons split up.
+func TestSVG() {
+  l := []tamias.Vect{tamias.V(0, 0), tamias.V(30, 0), tamias.V(30, 10),
+    tamias.V(10, 10), tamias.V(10, 30), tamias.V(0, 30)}
+  pieces, err := tamias.ConvexDecompose(l, 0)
+  assert(err == nil && len(pieces) == 2, 
+    "ConvexDecompose should split an L in two", pieces, err)
+  for _, piece := range pieces {
+    assert(tamias.PolyShapeValidate(piece), 
+      "Convex pieces should be valid polygons", piece)
+  }
+
+  space   := tamias.SpaceNew()
+  wheel   := tamias.BodyNew(1.0, 1.0)
+  options := &tamias.SVGOptions{Body: 
+    tamias.BodyNew(tamias.INFINITY, tamias.INFINITY),
+    Bodies: map[string] *tamias.Body{"wheel": wheel}}
+  shapes, err := tamias.LoadSVG(space, strings.NewReader(testSVG), options)
+  assert(err == nil, "SVG drawing should load", err)
+  // A box, two pieces of the L, a segment, a circle, a curve and a wheel.
+  assert(len(shapes) > 7, "SVG drawing should have all shapes", len(shapes))
+  if len(shapes) == 0 { return }
+  assert(shapes[len(shapes) - 1].Body == wheel,
+    "data-body should pick the body of the shapes in the element")
+  assert(shapes[0].Body == options.Body,
+    "Shapes of unnamed bodies should belong to the default body")
+  // The box at the bottom of the drawing ends up at 0, and takes the
+  // friction of its group.
+  box, circle := false, false
+  for _, s := range tamias.SceneFromSpace(space).Shapes {
+    if s.Kind == "poly" && len(s.Verts) == 4 {
+      for _, v := range s.Verts {
+        if v.Near(tamias.V(100.0, 0.0), 0.001) {
+          box = true
+          assert((s.Friction - 0.8).Abs() < 0.0001,
+            "Shapes should take data-friction from their group", s.Friction)
+        }
+      }
+    }
+    if s.Kind == "circle" && s.Radius == 10.0 {
+      circle = true
+      assert(s.Friction == 0.0 && s.Offset.Near(tamias.V(100.0, 0.0), 0.001),
+        "Shapes outside the group should keep their own friction", s)
+    }
+  }
+  assert(box && circle, "The Y axis of SVG drawings should be flipped",
+    box, circle)
+
+  hexagon := `<svg viewBox="0 0 10 10">
+ <polygon points="0,5 3,0 7,0 10,5 7,10 3,10"/>
+</svg>`
+  options.MaxVerts = 4
+  shapes, err = tamias.LoadSVG(tamias.SpaceNew(), strings.NewReader(hexagon),
+    options)
+  assert(err == nil && len(shapes) == 2,
+    "LoadSVG should split polygons with more than MaxVerts vertexes",
+    len(shapes), err)
+}
+
+// The debug renderer must draw shapes, and write PNG and GIF frames.
+func TestDebugDraw() {
+  space := tamias.SpaceNew()
+  body  := tamias.BodyNew(1.0, 1.0)
+  space.AddBody(body)
+  space.AddShape(tamias.CircleShapeNew(body, 10.0, tamias.VZERO).Shape)
+  renderer := debugdraw.RendererNew(64, 48)
+  renderer.Camera.Zoom = 2.0
+  renderer.DrawSpace(space)
+  center := renderer.Image.At(32, 24)
+  corner := renderer.Image.At(0, 0)
+  assert(center != corner, "DebugDraw should draw the circle", center, corner)
+
+  buf := &bytes.Buffer{}
+  err := renderer.WritePNG(buf)
+  assert(err == nil && buf.Len() > 0, "DebugDraw should write PNG", err)
+  buf.Reset()
+  gif := debugdraw.GIFEncoderNew(buf, 64, 48, 4)
+  for i:=0; i < 3; i++ {
+    space.Step(1.0 / 60.0)
+    renderer.DrawSpace(space)
+    gif.AddFrame(renderer.Image)
+  }
+  err = gif.Close()
+  assert(err == nil && buf.Len() > 0, "DebugDraw should write GIF", err)
+
+  buf.Reset()
+  err = space.WriteSVG(buf, nil)
+  assert(err == nil && strings.Contains(buf.String(), `id="shape-`),
+    "Space should write SVG with shape ids", err)
+}
+
+// Step must count the bodies, time its phases, and call the hook of the
+// statistics.
+func TestStats() {
+  space := tamias.SpaceNew()
+  space.Gravity = tamias.V(0.0, -100.0)
+  body  := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  space.AddShape(tamias.CircleShapeNew(body, 0.5, tamias.V(0.0, 0.6)).Shape)
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  space.AddStaticShape(tamias.SegmentShapeNew(ground, tamias.V(-5.0, 0.0),
+    tamias.V(5.0, 0.0), 0.0).Shape)
+  space.Profile = true
+  space.SetStatsWindow(4)
+  hooked := 0
+  space.StatsHook = func(space *tamias.Space, stats *tamias.StepStats) {
+    hooked++
+  }
+  for i:=0; i < 6; i++ { space.Step(1.0 / 60.0) }
+  stats := space.Stats()
+  assert(stats.ActiveBodies == 1, "Stats should count the bodies",
+    stats.ActiveBodies)
+  assert(stats.Total >= stats.Integrate, "Stats should time the step",
+    stats.Total, stats.Integrate)
+  phases := stats.Integrate + stats.CacheBB + stats.Broadphase +
+    stats.Narrowphase + stats.ContactSet + stats.ArbiterPreStep +
+    stats.ConstraintPreStep + stats.Solver + stats.Callbacks
+  assert(phases == stats.Total, "The phases should add up to the total",
+    phases, stats.Total)
+  assert(hooked == 6, "Step should call the stats hook", hooked)
+  average := space.StatsAverage()
+  assert(average.ActiveBodies == 1, "StatsAverage should average the window",
+    average.ActiveBodies)
+}
+
 func TestBB() {
   bb := tamias.BBMake(10.0, 20.0, 40.0, 80.0)  
   // assert(bb != nil , "Bounds Box must be constructable")
@@ -86,6 +541,493 @@ func TestShape() {
   }  
 }
 
+// ConvexHull must give a valid polygon of any points.
+func TestHull() {
+  points := []tamias.Vect{tamias.V(0, 0), tamias.V(1, 1), tamias.V(0, 1),
+    tamias.V(1, 0), tamias.V(0.5, 0.5), tamias.V(0.5, -0.01)}
+  hull := tamias.ConvexHull(points, 0.0)
+  assert(len(hull) == 5 && tamias.PolyShapeValidate(hull),
+    "ConvexHull should give a valid hull", hull)
+  hull = tamias.ConvexHull(points, 0.1)
+  assert(len(hull) == 4, "ConvexHull should merge nearly collinear points",
+    hull)
+  poly := tamias.PolyShapeNewFromPoints(tamias.BodyNew(1.0, 1.0), points,
+    tamias.VZERO)
+  assert(poly.NumVerts() == 5, "PolyShapeNewFromPoints should use the hull",
+    poly.NumVerts())
+}
+
+// Rounded polygons must grow by their radius, and touch like it.
+func TestRoundedPoly() {
+  body := tamias.BodyNew(1.0, 1.0)
+  box  := tamias.RoundedBoxShapeNew(body, 2.0, 2.0, 0.5)
+  assert(box.GetBB().R == 1.5 && box.GetBB().B == -1.5,
+    "RoundedBoxShapeNew should grow the BB by the radius", box.GetBB())
+  assert(box.PointQuery(tamias.V(1.4, 0.0)),
+    "PointQuery should hit the rounded edge")
+  assert(!box.PointQuery(tamias.V(1.4, 1.4)),
+    "PointQuery should miss the rounded corner")
+  verts := []tamias.Vect{tamias.V(-1, -1), tamias.V(-1, 1), tamias.V(1, 1),
+    tamias.V(1, -1)}
+  m1, m2 := tamias.MomentForBox(1.0, 2.0, 2.0),
+    tamias.MomentForPoly(1.0, verts, tamias.VZERO, 0.0)
+  assert((m1 - m2).Abs() < 0.0001,
+    "MomentForPoly of a box should be MomentForBox", m1, m2)
+  other := tamias.PolyShapeNewRounded(tamias.BodyNew(1.0, 1.0), verts,
+    tamias.V(0.0, 2.9), 0.5)
+  contacts := make([]tamias.Contact, tamias.CP_MAX_CONTACTS_PER_ARBITER)
+  num := tamias.CollideShapes(box.Shape, other.Shape, contacts)
+  assert(num == 2, "Rounded faces should touch in two points", num)
+
+  // When the face tilts the other way, the contact at the left keeps its
+  // hash, though it is no longer the closest.
+  var hashes [2]tamias.HashValue
+  for i, tilt := range []tamias.Float{0.05, -0.05} {
+    tilted := []tamias.Vect{tamias.V(-1, -1 - tilt), tamias.V(-1, 1),
+      tamias.V(1, 1), tamias.V(1, -1 + tilt)}
+    other = tamias.PolyShapeNewRounded(tamias.BodyNew(1.0, 1.0), tilted,
+      tamias.V(0.0, 2.9), 0.5)
+    num   = tamias.CollideShapes(box.Shape, other.Shape, contacts)
+    assert(num == 2, "Tilted rounded faces should touch in two points", num)
+    left := 0
+    if contacts[1].P.X < contacts[0].P.X { left = 1 }
+    hashes[i] = contacts[left].Hash
+  }
+  assert(hashes[0] == hashes[1],
+    "Rounded contacts should be hashed by their vertex", hashes)
+}
+
+// Shapes must not catch on the vertexes inside a chain of segments.
+func TestChain() {
+  body   := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  points := []tamias.Vect{tamias.V(0, 0), tamias.V(1, 0), tamias.V(2, 0)}
+  chain  := tamias.SegmentShapesNew(body, points, 0.0)
+  circle := tamias.CircleShapeNew(tamias.BodyNew(1.0, 1.0), 0.5,
+    tamias.V(0.9, 0.4))
+  circle.CacheBB(circle.Body.Pos(), circle.Body.Rot())
+  contacts := make([]tamias.Contact, tamias.CP_MAX_CONTACTS_PER_ARBITER)
+  for i, seg := range chain {
+    seg.CacheBB(body.Pos(), body.Rot())
+    num := tamias.CollideShapes(circle.Shape, seg.Shape, contacts)
+    assert(num == 1 - i, "The chain should hide the inner vertex", i, num)
+  }
+  chain[0].SetOneSided(true)
+  circle = tamias.CircleShapeNew(circle.Body, 0.5, tamias.V(0.5, -0.4))
+  circle.CacheBB(circle.Body.Pos(), circle.Body.Rot())
+  num := tamias.CollideShapes(circle.Shape, chain[0].Shape, contacts)
+  assert(num == 0, "A one-sided segment should not touch from behind", num)
+}
+
+// One-way platforms must only stop shapes that come from their top.
+func TestOneWay() {
+  for _, y := range []tamias.Float{0.9, -0.9} {
+    space    := tamias.SpaceNew()
+    platform := tamias.BoxShapeNew(tamias.BodyNew(tamias.INFINITY,
+      tamias.INFINITY), 2.0, 1.0)
+    platform.SetOneWay(tamias.V(0.0, 1.0))
+    space.AddStaticShape(platform.Shape)
+    body := space.AddBody(tamias.BodyNew(1.0, 1.0))
+    space.AddShape(tamias.CircleShapeNew(body, 0.5, tamias.V(0.0, y)).Shape)
+    space.Step(1.0 / 60.0)
+    arbiters := space.Stats().Arbiters
+    assert((arbiters == 1) == (y > 0),
+      "A one-way platform should only stop shapes from above", y, arbiters)
+  }
+  // A rounded shape that is partly inside the side of the platform
+  // touches it from above at one end, and from the side at the other.
+  space    := tamias.SpaceNew()
+  platform := tamias.BoxShapeNew(tamias.BodyNew(tamias.INFINITY,
+    tamias.INFINITY), 4.0, 1.0)
+  platform.SetOneWay(tamias.V(0.0, 1.0))
+  space.AddStaticShape(platform.Shape)
+  body  := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  verts := []tamias.Vect{tamias.V(-0.5, -0.5), tamias.V(-0.5, 0.5),
+    tamias.V(0.5, 0.5), tamias.V(0.5, -0.3)}
+  space.AddShape(tamias.PolyShapeNewRounded(body, verts,
+    tamias.V(-2.8, 0.2), 0.3).Shape)
+  space.Step(1.0 / 60.0)
+  assert(space.Stats().Arbiters == 0,
+    "A shape partly inside a one-way platform should pass through",
+    space.Stats().Arbiters)
+}
+
+// Pairs of shapes or of collision types with the same HASH_PAIR must still
+// get their own arbiters and handlers.
+func TestPairKeys() {
+  tamias.ResetShapeIdCounter()
+  space   := tamias.SpaceNew()
+  centers := map[int]tamias.Vect{50: tamias.V(0.0, 0.0),
+    253: tamias.V(1.5, 0.0), 51: tamias.V(10.0, 0.0), 252: tamias.V(11.5, 0.0)}
+  bodies  := make([]*tamias.Body, 0)
+  for i:=0; i < 254; i++ {
+    body   := tamias.BodyNew(1.0, 1.0)
+    circle := tamias.CircleShapeNew(body, 1.0, centers[i])
+    if _, ok := centers[i]; !ok { continue }
+    // The first pair has the type 1, and the second the type 2.
+    circle.SetCollisionType(1)
+    if centers[i].X > 5.0 { circle.SetCollisionType(2) }
+    space.AddShape(circle.Shape)
+    bodies = append(bodies, space.AddBody(body))
+  }
+  assert(tamias.HASH_PAIR(50, 253) == tamias.HASH_PAIR(51, 252),
+    "The pairs should have the same hash")
+  solves   := 0
+  preSolve := func(arb *tamias.Arbiter, space *tamias.Space,
+    data interface{}) (int) {
+    solves++
+    return 1
+  }
+  keep := func(arb *tamias.Arbiter, space *tamias.Space,
+    data interface{}) (int) {
+    return 1
+  }
+  space.AddCollisionHandler(1, 1, keep, preSolve, keep, keep, nil)
+  space.Step(1.0 / 60.0)
+  for _, body := range bodies {
+    count := 0
+    body.EachArbiter(func(arb *tamias.Arbiter) { count++ })
+    assert(count == 1, "Each pair should get its own arbiter", count)
+  }
+  assert(solves == 1, "Handlers should only see their own types", solves)
+}
+
+// Collision callbacks must be able to remove shapes after the step.
+func TestPostStep() {
+  space  := tamias.SpaceNew()
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  space.AddStaticShape(tamias.SegmentShapeNew(ground, tamias.V(-10.0, 0.0),
+    tamias.V(10.0, 0.0), 0.0).Shape)
+  body   := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  circle := tamias.CircleShapeNew(body, 1.0, tamias.V(0.0, 0.5))
+  circle.SetCollisionType(1)
+  space.AddShape(circle.Shape)
+  calls := 0
+  begin := func(arb *tamias.Arbiter, space *tamias.Space,
+    data interface{}) (int) {
+    // Twice, and only removed once.
+    space.PostStepRemoveShape(circle.Shape)
+    space.PostStepRemoveShape(circle.Shape)
+    space.AddPostStepCallback(func(space *tamias.Space,
+      obj, data interface{}) {
+      calls++
+    }, space, nil)
+    return 1
+  }
+  keep := func(arb *tamias.Arbiter, space *tamias.Space,
+    data interface{}) (int) {
+    return 1
+  }
+  space.AddCollisionHandler(1, 0, begin, keep, keep, keep, nil)
+  space.Step(1.0 / 60.0)
+  shapes := 0
+  space.EachShape(func(shape *tamias.Shape) { shapes++ })
+  assert(shapes == 0 && calls == 1,
+    "Post step callbacks should run once after the step", shapes, calls)
+  space.Step(1.0 / 60.0)
+  assert(calls == 1, "Post step callbacks should only run once", calls)
+}
+
+// Heightfields must be queried and collided one column at a time.
+func TestHeightfield() {
+  body  := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  field := tamias.HeightfieldShapeNew(body, tamias.VZERO, 1.0,
+    []tamias.Float{0.0, 1.0, 0.0, 0.0})
+  assert(field.PointQuery(tamias.V(0.5, 0.4)) &&
+    !field.PointQuery(tamias.V(0.5, 0.6)),
+    "PointQuery should hit under the surface only")
+  info := field.SegmentQuery(tamias.V(0.5, 2.0), tamias.V(0.5, -1.0))
+  assert(info.Hit() && info.HitPoint(tamias.V(0.5, 2.0),
+    tamias.V(0.5, -1.0)).Near(tamias.V(0.5, 0.5), 0.001),
+    "SegmentQuery should hit the surface", info)
+  field.SetHeight(1, 3.0)
+  assert(field.GetBB().T == 3.0, "SetHeight should update the BB",
+    field.GetBB())
+  circle := tamias.CircleShapeNew(tamias.BodyNew(1.0, 1.0), 0.5,
+    tamias.V(2.4, 0.4))
+  bb := circle.CacheBB(circle.Body.Pos(), circle.Body.Rot())
+  circle.BB = &bb
+  contacts := make([]tamias.Contact, tamias.CP_MAX_CONTACTS_PER_ARBITER)
+  num := tamias.CollideShapes(circle.Shape, field.Shape, contacts)
+  assert(num == 1 && contacts[0].N.Y < 0.0,
+    "A circle should touch the flat column only", num)
+  flat := tamias.HeightfieldShapeNew(body, tamias.VZERO, 1.0,
+    []tamias.Float{0.0, 0.0, 0.0})
+  circle = tamias.CircleShapeNew(circle.Body, 0.5, tamias.V(0.9, 0.4))
+  bb     = circle.CacheBB(circle.Body.Pos(), circle.Body.Rot())
+  circle.BB = &bb
+  num = tamias.CollideShapes(circle.Shape, flat.Shape, contacts)
+  assert(num == 1 && contacts[0].N.Near(tamias.V(0.0, -1.0), 0.001),
+    "A flat heightfield should hide its inner vertexes", num)
+}
+
+// Tilemaps must merge their tiles, and only rebuild the chunks that change.
+func TestTilemap() {
+  space := tamias.SpaceNew()
+  body  := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  solid := []bool{true, true, true, true, true, true, true, true}
+  tiles := tamias.TilemapNew(space, body, solid, 4, 2, tamias.V(1, 1), 2, nil)
+  assert(len(tiles.Shapes()) == 2, "Tilemap should merge the tiles of a chunk",
+    len(tiles.Shapes()))
+  before := tiles.Shapes()[1]
+  tiles.SetTile(0, 0, false)
+  tiles.Update()
+  assert(len(tiles.Shapes()) == 3 && tiles.Shapes()[2] == before,
+    "Update should only rebuild the changed chunk", len(tiles.Shapes()))
+  tiles.Remove()
+  options := tamias.TilemapOptions{Chains: true}
+  tiles    = tamias.TilemapNew(space, body, solid, 4, 2, tamias.V(1, 1), 4,
+    &options)
+  assert(len(tiles.Shapes()) == 4, "Tilemap should trace the edges",
+    len(tiles.Shapes()))
+}
+
+// Bodies must know their shapes and constraints, and lose them with them.
+func TestBodyLists() {
+  space := tamias.SpaceNew()
+  a     := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  b     := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  space.AddShape(tamias.CircleShapeNew(a, 1.0, tamias.VZERO).Shape)
+  space.AddShape(tamias.CircleShapeNew(a, 1.0, tamias.V(2.0, 0.0)).Shape)
+  space.AddConstraint(tamias.DampedRotarySpringNew(a, b, 0.0, 1.0, 1.0))
+  shapes, constraints := 0, 0
+  a.EachShape(func(shape *tamias.Shape) { shapes++ })
+  b.EachConstraint(func(constraint tamias.Constraint) { constraints++ })
+  assert(shapes == 2 && constraints == 1,
+    "Bodies should know their shapes and constraints", shapes, constraints)
+  space.RemoveBody(b)
+  shapes, constraints = 0, 0
+  space.EachShape(func(shape *tamias.Shape) { shapes++ })
+  b.EachConstraint(func(constraint tamias.Constraint) { constraints++ })
+  assert(shapes == 2 && constraints == 1,
+    "RemoveBody should keep the shapes and constraints", shapes, constraints)
+  space.AddBody(b)
+  space.RemoveBodyCascade(a)
+  shapes, constraints = 0, 0
+  space.EachShape(func(shape *tamias.Shape) { shapes++ })
+  b.EachConstraint(func(constraint tamias.Constraint) { constraints++ })
+  assert(shapes == 0 && constraints == 0,
+    "RemoveBodyCascade should remove the shapes and constraints", shapes,
+    constraints)
+}
+
+// Bodies must list their arbiters, and forget them when they go away.
+func TestBodyArbiters() {
+  space  := tamias.SpaceNew()
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  box    := tamias.BoxShapeNew(ground, 2.0, 1.0)
+  space.AddStaticShape(box.Shape)
+  body   := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  circle := tamias.CircleShapeNew(body, 0.5, tamias.V(0.0, 0.9))
+  space.AddShape(circle.Shape)
+  space.Step(1.0 / 60.0)
+  for _, b := range []*tamias.Body{body, ground} {
+    count := 0
+    b.EachArbiter(func(arb *tamias.Arbiter) {
+      count++
+      a, other := arb.GetBodies()
+      normal   := arb.GetNormal(0)
+      assert(a == b && other != b && (normal.Y > 0) == (b == ground),
+        "EachArbiter should turn the arbiter to the body", normal)
+    })
+    assert(count == 1, "EachArbiter should find the arbiter", count)
+  }
+  snap := space.Snapshot()
+  space.RemoveShape(circle.Shape)
+  for _, b := range []*tamias.Body{body, ground} {
+    count := 0
+    b.EachArbiter(func(arb *tamias.Arbiter) { count++ })
+    assert(count == 0, "RemoveShape should drop the arbiters", count)
+  }
+  count := 0
+  space.EachArbiter(func(arb *tamias.Arbiter) { count++ })
+  assert(count == 0, "RemoveShape should drop the arbiters of the space",
+    count)
+  space.AddShape(circle.Shape)
+  space.Step(1.0 / 60.0)
+  space.Restore(snap)
+  count, bodyCount := 0, 0
+  space.EachArbiter(func(arb *tamias.Arbiter) { count++ })
+  ground.EachArbiter(func(arb *tamias.Arbiter) { bodyCount++ })
+  assert(count == 1 && bodyCount == 1,
+    "Restore should bring back the arbiters of the snapshot", count,
+    bodyCount)
+}
+
+// A preSolve callback must be able to change the contacts of an arbiter.
+func TestContactPointSet() {
+  space  := tamias.SpaceNew()
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  space.AddStaticShape(tamias.BoxShapeNew(ground, 2.0, 1.0).Shape)
+  body   := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  space.AddShape(tamias.CircleShapeNew(body, 0.5, tamias.V(0.0, 0.9)).Shape)
+  accept := func(arb *tamias.Arbiter, space *tamias.Space,
+    data interface{}) (int) { return 1 }
+  ice := func(arb *tamias.Arbiter, space *tamias.Space,
+    data interface{}) (int) {
+    set := arb.GetContactPointSet()
+    assert(set.Count == 1 && set.Points[0].Normal == arb.GetNormal(0),
+      "GetContactPointSet should return the contacts", set.Count)
+    set.Points[0].Dist = 0.0
+    arb.SetContactPointSet(&set)
+    arb.SetFriction(0.0)
+    arb.SetSurfaceVelocity(tamias.V(1.0, 0.0))
+    return 1
+  }
+  space.SetDefaultHandler(0, 0, accept, ice, accept, accept, nil)
+  space.Step(1.0 / 60.0)
+  body.EachArbiter(func(arb *tamias.Arbiter) {
+    assert(arb.GetDepth(0) == 0.0 && arb.Friction() == 0.0 &&
+      arb.SurfaceVelocity() == tamias.V(1.0, 0.0),
+      "preSolve should change the arbiter", arb.GetDepth(0))
+  })
+}
+
+// Shared materials must mix by the rules of the space, and give mass.
+func TestMaterial() {
+  space  := tamias.SpaceNew()
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  box    := tamias.BoxShapeNew(ground, 2.0, 1.0)
+  space.AddStaticShape(box.Shape)
+  body   := space.AddBody(tamias.BodyNew(1.0, 1.0))
+  circle := tamias.CircleShapeNew(body, 0.5, tamias.V(0.0, 0.7))
+  space.AddShape(circle.Shape)
+  rubber := tamias.MaterialNew(1.0, 0.8, 2.0)
+  ice    := tamias.MaterialNew(0.1, 0.2, 1.0)
+  circle.SetMaterial(rubber)
+  box.SetMaterial(ice)
+  body.SetMassFromShapes()
+  area := tamias.AreaForCircle(0.0, 0.5)
+  assert((body.Mass() - 2.0 * area).Abs() < 0.0001,
+    "SetMassFromShapes should use the density", body.Mass())
+  space.FrictionMix = tamias.MixMin
+  space.Step(1.0 / 60.0)
+  body.EachArbiter(func(arb *tamias.Arbiter) {
+    assert(arb.Friction() == 0.1 && (arb.Elasticity() - 0.16).Abs() < 0.0001,
+      "The space should mix the materials", arb.Friction(), arb.Elasticity())
+  })
+  space.SetPairMaterial(0, 0, tamias.MaterialNew(0.5, 0.0, 0.0))
+  space.Step(1.0 / 60.0)
+  body.EachArbiter(func(arb *tamias.Arbiter) {
+    assert(arb.Friction() == 0.5, "The pair material should win",
+      arb.Friction())
+  })
+  // Pairs of the same type, or with the same HASH_PAIR, are still
+  // different pairs.
+  circle.SetCollisionType(1)
+  box.SetCollisionType(1)
+  space.Step(1.0 / 60.0)
+  body.EachArbiter(func(arb *tamias.Arbiter) {
+    assert(arb.Friction() == 0.1,
+      "A pair material should only apply to its own pair", arb.Friction())
+  })
+  space.SetPairMaterial(50, 253, ice)
+  assert(space.PairMaterial(1, 1) == nil && space.PairMaterial(51, 252) == nil,
+    "Pair materials should be kept apart by their pairs")
+}
+
+// Returns the speed of a body sliding on a box for a second, with the
+// friction mixed from the materials of the shapes. The surface of the box
+// moves at beltSpeed, like a conveyor belt.
+func slideSpeed(sliderFriction, groundFriction,
+  beltSpeed tamias.Float) (tamias.Float) {
+  space        := tamias.SpaceNew()
+  space.Gravity = tamias.V(0.0, -100.0)
+  space.FrictionMix = tamias.MixMin
+  ground := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  box    := tamias.BoxShapeNew(ground, 100.0, 1.0)
+  belt          := tamias.MaterialNew(groundFriction, 0.0, 0.0)
+  belt.SurfaceV  = tamias.V(beltSpeed, 0.0)
+  box.SetMaterial(belt)
+  space.AddStaticShape(box.Shape)
+  // An infinite moment keeps the body from rolling.
+  body   := space.AddBody(tamias.BodyNew(1.0, tamias.INFINITY))
+  circle := tamias.CircleShapeNew(body, 0.5, tamias.V(0.0, 1.0))
+  circle.SetMaterial(tamias.MaterialNew(sliderFriction, 0.0, 0.0))
+  space.AddShape(circle.Shape)
+  body.ApplyImpulse(tamias.V(10.0, 0.0), tamias.VZERO)
+  for i:=0; i < 60; i++ { space.Step(1.0 / 60.0) }
+  return body.Vel().X
+}
+
+// The mixed friction must survive to the solver in Step.
+func TestMaterialFriction() {
+  ice    := slideSpeed(1.0, 0.0, 0.0)
+  rubber := slideSpeed(1.0, 1.0, 0.0)
+  belt   := slideSpeed(1.0, 1.0, 5.0)
+  assert(ice > 9.9, "A body on ice should keep sliding", ice)
+  assert(rubber < 1.0, "A body on rubber should stop", rubber)
+  assert((belt - 5.0).Abs() < 0.5,
+    "A conveyor belt should carry a body along its surface velocity", belt)
+}
+
+// Concave polygons must split into valid pieces within the vertex limit.
+func TestDecompose() {
+  u := []tamias.Vect{tamias.V(0, 0), tamias.V(30, 0), tamias.V(30, 30),
+    tamias.V(20, 30), tamias.V(20, 10), tamias.V(10, 10), tamias.V(10, 30),
+    tamias.V(0, 30)}
+  body := tamias.BodyNew(1.0, 1.0)
+  shapes, err := tamias.ConcavePolyShapesNew(body, u, 4, tamias.VZERO)
+  assert(err == nil && len(shapes) == 3, 
+    "ConcavePolyShapesNew should split a U in three", len(shapes), err)
+  for _, shape := range shapes {
+    assert(shape.NumVerts() <= 4 && shape.Body == body,
+      "Pieces should keep to the vertex limit", shape.NumVerts())
+  }
+  bowtie := []tamias.Vect{tamias.V(0, 0), tamias.V(10, 10), tamias.V(10, 0),
+    tamias.V(0, 10)}
+  _, err = tamias.ConvexDecompose(bowtie, 0)
+  assert(err == tamias.ErrSelfIntersecting, 
+    "ConvexDecompose should reject self-intersecting polygons", err)
+}
+
+// Marching squares must outline a disc, and regenerate dirty chunks.
+func TestMarch() {
+  disc := func(point tamias.Vect) (tamias.Float) {
+    return 30.0 - point.Dist(tamias.V(50, 50))
+  }
+  bb       := tamias.BBMake(0, 100, 100, 0)
+  outlines := tamias.MarchSquares(bb, 20, 20, 0.0, disc)
+  assert(len(outlines) == 1 && tamias.AreaForPoly(outlines[0]) > 2700,
+    "MarchSquares should outline the disc", len(outlines))
+
+  space   := tamias.SpaceNew()
+  body    := tamias.BodyNew(tamias.INFINITY, tamias.INFINITY)
+  options := tamias.DefaultMarchOptions
+  options.Threshold = 0.0
+  options.Polys     = true
+  terrain := tamias.MarchTerrainNew(space, body, bb, 20, 20, 5, disc,
+    &options)
+  count := len(terrain.Shapes())
+  assert(count > 0, "MarchTerrain should make polygons", count)
+  terrain.Regenerate(tamias.BBMake(40, 60, 60, 40))
+  assert(len(terrain.Shapes()) == count, 
+    "Regenerate should replace the shapes of the chunks", 
+    len(terrain.Shapes()), count)
+}
+
+// The polyline tools must drop and add points as asked.
+func TestPolyline() {
+  line := []tamias.Vect{tamias.V(0, 0), tamias.V(1, 0.01), tamias.V(2, 0),
+    tamias.V(3, 0), tamias.V(3, 3)}
+  simple := tamias.PolylineSimplify(line, 0.1)
+  assert(len(simple) == 3, "PolylineSimplify should drop points", simple)
+  square := []tamias.Vect{tamias.V(0, 0), tamias.V(1, 0), tamias.V(2, 0),
+    tamias.V(2, 2), tamias.V(0, 2), tamias.V(0, 0)}
+  assert(tamias.PolylineIsClosed(square), "Square should be closed")
+  square = tamias.PolylineRemoveCollinear(square, 0.0)
+  assert(len(square) == 5 && tamias.PolylineIsClosed(square),
+    "PolylineRemoveCollinear should keep loops closed", square)
+  smooth := tamias.PolylineSmooth(square, 2)
+  assert(len(smooth) == 17 && tamias.PolylineIsClosed(smooth),
+    "PolylineSmooth should cut every corner", len(smooth))
+  snapped := tamias.PolylineSnap(line, 1.0)
+  assert(len(snapped) == 5 && snapped[1] == tamias.V(1, 0),
+    "PolylineSnap should snap to the grid", snapped)
+  verts := tamias.PolylineLoopVerts(square)
+  assert(tamias.PolyShapeValidate(verts), 
+    "PolylineLoopVerts should make a valid polygon", verts)
+}
+
 func TestVect() {  
   v1 := tamias.VF(3.0, 4.0)
   v2 := tamias.V(1.0, 0.0)
@@ -156,9 +1098,36 @@ func main() {
   // w.Draw(100, 100, draw.Red)
   */  
   TestFloat()
+  TestPrecision()
+  TestDeterministic()
+  TestSnapshot()
+  TestPairKeys()
+  TestPostStep()
+  TestJSONScene()
+  TestBinaryScene()
+  TestSceneResume()
+  TestTMX()
+  TestSVG()
+  TestDebugDraw()
+  TestDebugDrawer()
+  TestStats()
   TestVect()  
   TestBB()  
   TestShape()
+  TestHull()
+  TestRoundedPoly()
+  TestChain()
+  TestOneWay()
+  TestHeightfield()
+  TestTilemap()
+  TestBodyLists()
+  TestBodyArbiters()
+  TestContactPointSet()
+  TestMaterial()
+  TestMaterialFriction()
+  TestDecompose()
+  TestMarch()
+  TestPolyline()
   TestSpaceMap()
   TestResults()
   